@@ -0,0 +1,449 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+
+    "theWhiskyExchangeCrawler/airtable"
+    "theWhiskyExchangeCrawler/config"
+    "theWhiskyExchangeCrawler/sink"
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// Airtable structure - one row per product, merged on SKU
+type AirtableFields struct {
+    SKU                string  `json:"SKU"`
+    Name               string  `json:"Name"`
+    Price              float64 `json:"Price"`      // Changed to float64 as per Postman test
+    ExVatPrice         float64 `json:"ExVATPrice"` // Changed to float64, JSON tag corrected to ExVATPrice
+    ABV                string  `json:"ABV"`
+    Size               string  `json:"Size"`
+    Description        string  `json:"Description"`
+    ImageUrl           string  `json:"Image URL"`
+    ProductUrl         string  `json:"Product URL"`
+    ScrapeDate         string  `json:"ScrapedDate"` // JSON tag corrected to ScrapedDate
+    IsActive           string  `json:"isActive"`
+    MaxOrderQuantity   float64 `json:"MaxOrderQuantity"`
+    Manufacturer       string  `json:"Manufacturer"`
+    Brand              string  `json:"Brand"`
+    MasterCategoryName string  `json:"MasterCategoryName"`
+    CategoryName       string  `json:"CategoryName"`
+    Weight             float64 `json:"Weight"`
+    StockLevel         float64 `json:"StockLevel"`
+    StockControl       float64 `json:"StockControl"`
+    IsOutOfStock       string  `json:"isOutofStock"`
+}
+
+// airtableMergeField identifies a product's row in the table
+const airtableMergeField = "SKU"
+
+// airtableUserAgent names the crawler honestly; the browser User-Agent is
+// only needed for the shop's API.
+var airtableUserAgent = "theWhiskyExchangeCrawler/" + version
+
+// airtableVolatileFields change on every crawl without the product changing,
+// so they don't count as an update on their own. ScrapedDate therefore
+// records when the row last changed.
+var airtableVolatileFields = map[string]bool{"ScrapedDate": true}
+
+// extractAirtableFields maps a decoded Product onto the Airtable column layout
+func extractAirtableFields(product twe.Product) AirtableFields {
+    scrapedDateStr := ""
+    if !product.ScrapedDate.IsZero() {
+        scrapedDateStr = product.ScrapedDate.Format("2006-01-02")
+    } else {
+        scrapedDateStr = time.Now().UTC().Format("2006-01-02")
+        log.Printf("Warning: scrapedDate for %v is not set. Defaulting to current date (YYYY-MM-DD): %s", product.Name, scrapedDateStr)
+    }
+
+    return AirtableFields{
+        SKU:                product.ProductID,
+        Name:               product.Name,
+        Price:              product.SalesPrice,
+        ExVatPrice:         product.SalesPriceExVat,
+        ABV:                formatAirtableNumber(product.StrengthInPC),
+        Size:               formatAirtableNumber(product.SizeInCL),
+        Description:        product.Description,
+        ProductUrl:         product.URL,
+        ImageUrl:           product.ProductImageUrl,
+        ScrapeDate:         scrapedDateStr,
+        IsActive:           strconv.FormatBool(product.IsActive),
+        MaxOrderQuantity:   product.MaxOrderQuantity,
+        Manufacturer:       product.Manufacturer,
+        Brand:              product.Brand,
+        MasterCategoryName: product.MasterCategoryName,
+        CategoryName:       product.CategoryName,
+        Weight:             product.Weight,
+        StockLevel:         product.StockLevel,
+        StockControl:       product.StockControl,
+        IsOutOfStock:       strconv.FormatBool(product.IsOutOfStock),
+    }
+}
+
+// formatAirtableNumber renders numeric values that Airtable stores as text (ABV, Size)
+func formatAirtableNumber(value float64) string {
+    if value == 0 {
+        return ""
+    }
+    return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// airtableFieldMap converts the typed fields into the generic form the
+// Airtable client sends and receives
+func airtableFieldMap(fields AirtableFields) map[string]interface{} {
+    fieldMap := make(map[string]interface{})
+    raw, err := json.Marshal(fields)
+    if err == nil {
+        err = json.Unmarshal(raw, &fieldMap)
+    }
+    if err != nil {
+        log.Printf("Error converting Airtable fields for SKU %s: %v", fields.SKU, err)
+    }
+    return fieldMap
+}
+
+// productTable lays out tabular output (CSV, Parquet, XLSX) with the
+// Airtable columns, in AirtableFields order, followed by the product page
+// details when those are fetched, a column per tagged facet and, for a
+// multi-query crawl, the queries that listed each product. A facet whose
+// name matches a detail column fills it where the page had no value.
+func productTable(withDetails bool, facets []string, withQueries bool) sink.Table {
+    var columns []sink.Column
+    t := reflect.TypeOf(AirtableFields{})
+    for i := 0; i < t.NumField(); i++ {
+        kind := sink.String
+        if t.Field(i).Type.Kind() == reflect.Float64 {
+            kind = sink.Number
+        }
+        columns = append(columns, sink.Column{Name: t.Field(i).Tag.Get("json"), Kind: kind})
+    }
+    if withDetails {
+        columns = append(columns, detailColumns...)
+    }
+    for _, facet := range facets {
+        exists := false
+        for _, c := range columns {
+            exists = exists || c.Name == facet
+        }
+        if !exists {
+            columns = append(columns, sink.Column{Name: facet, Kind: sink.String})
+        }
+    }
+    if withQueries {
+        columns = append(columns, sink.Column{Name: "Queries", Kind: sink.String})
+    }
+    return sink.Table{
+        Columns: columns,
+        Row: func(product twe.Product) map[string]interface{} {
+            row := airtableFieldMap(extractAirtableFields(product))
+            if withDetails {
+                for name, value := range detailFieldMap(product.Details) {
+                    row[name] = value
+                }
+            }
+            for _, facet := range facets {
+                if _, ok := row[facet]; !ok && product.Attributes[facet] != "" {
+                    row[facet] = product.Attributes[facet]
+                }
+            }
+            if withQueries {
+                row["Queries"] = strings.Join(product.Queries, "; ")
+            }
+            return row
+        },
+    }
+}
+
+// sameAirtableFields reports whether existing already holds every value in
+// desired. Airtable omits empty cells from responses, so a missing cell
+// matches an empty string, zero or false.
+func sameAirtableFields(existing, desired map[string]interface{}) bool {
+    for name, want := range desired {
+        if airtableVolatileFields[name] {
+            continue
+        }
+        have, ok := existing[name]
+        if !ok {
+            if want == nil || reflect.ValueOf(want).IsZero() {
+                continue
+            }
+            return false
+        }
+        if !reflect.DeepEqual(have, want) {
+            return false
+        }
+    }
+    return true
+}
+
+// airtableSyncSummary counts what happened to each collected product
+type airtableSyncSummary struct {
+    Created, Updated, Unchanged, Failed int
+}
+
+// uploadDataToAirtable upserts the products in the crawl output at
+// outputPath into the table on SKU, so the table holds one row per product
+// that is updated in place. The output is streamed back from disk; only
+// rows whose fields changed since the last run are kept and sent. It
+// returns how many records failed and were dead-lettered.
+func uploadDataToAirtable(airtableCfg config.AirtableConfig, coversCatalogue bool, outputPath string) (failed int) {
+    if airtableCfg.TableURL == "" {
+        fmt.Fprintln(stdout, "Airtable is not configured (airtable.table_url). Skipping upload.")
+        return 0
+    }
+
+    ctx := context.Background()
+    client := newAirtableClient(airtableCfg)
+
+    fmt.Fprintln(stdout, "Fetching existing Airtable records...")
+    existing, err := client.ListRecords(ctx, nil)
+    listed := err == nil
+    if err != nil {
+        // Upserting everything is still correct, just slower
+        log.Printf("Warning: could not list existing Airtable records, upserting every product: %v", err)
+        existing = nil
+    }
+    existingBySKU := make(map[string]airtable.Record, len(existing))
+    duplicateSKUs := 0
+    for _, record := range existing {
+        sku, _ := record.Fields[airtableMergeField].(string)
+        if sku == "" {
+            continue
+        }
+        if _, seen := existingBySKU[sku]; seen {
+            duplicateSKUs++
+        }
+        existingBySKU[sku] = record
+    }
+    if duplicateSKUs > 0 {
+        log.Printf("Warning: %d SKUs appear more than once in Airtable; upserts for them will fail until the duplicate rows are removed.", duplicateSKUs)
+    }
+
+    // One row per SKU: a product listed twice in the crawl keeps its last entry
+    var summary airtableSyncSummary
+    var pending []airtable.Record
+    pendingIndex := make(map[string]int)
+    seen := make(map[string]bool)
+    total := 0
+    err = sink.ReadJSON(outputPath, func(product twe.Product) error {
+        total++
+        seen[product.ProductID] = true
+        fields := airtableFieldMap(extractAirtableFields(product))
+        if airtableCfg.DelistedDateField != "" {
+            // A product that is listed again is no longer delisted
+            fields[airtableCfg.DelistedDateField] = nil
+        }
+        if record, ok := existingBySKU[product.ProductID]; ok && sameAirtableFields(record.Fields, fields) {
+            summary.Unchanged++
+            return nil
+        }
+        if i, ok := pendingIndex[product.ProductID]; ok {
+            pending[i].Fields = fields
+            return nil
+        }
+        pendingIndex[product.ProductID] = len(pending)
+        pending = append(pending, airtable.Record{Fields: fields})
+        return nil
+    })
+    if err != nil {
+        log.Printf("Error reading the crawl output for Airtable: %v", err)
+        return 0
+    }
+    if total == 0 {
+        fmt.Fprintln(stdout, "No data to upload to Airtable.")
+        return 0
+    }
+
+    fmt.Fprintf(stdout, "Upserting %d of %d products into Airtable (%d unchanged)...\n", len(pending), total, summary.Unchanged)
+    mergeOn := []string{airtableMergeField}
+    result := client.UpsertAll(ctx, pending, mergeOn)
+    summary.Created = len(result.Created)
+    summary.Updated = len(result.Updated)
+    summary.Failed = len(result.Failures)
+    deadLetter(airtableCfg, airtable.NewDeadLetters(airtable.OpUpsert, mergeOn, result.Failures))
+
+    fmt.Fprintf(stdout, "Airtable sync finished: %d created, %d updated, %d unchanged, %d failed.\n",
+        summary.Created, summary.Updated, summary.Unchanged, summary.Failed)
+
+    switch {
+    case !airtableCfg.MarkDelisted:
+    case !listed:
+        log.Println("Warning: skipping delisting because the existing Airtable records could not be listed.")
+    case !coversCatalogue:
+        fmt.Fprintln(stdout, "Skipping delisting: the crawl used filters, so missing products are not necessarily delisted.")
+    default:
+        summary.Failed += markDelistedInAirtable(ctx, client, airtableCfg, existing, seen)
+    }
+    return summary.Failed
+}
+
+// markDelistedInAirtable flags every active row whose SKU was not collected
+// in this crawl as inactive and out of stock. It refuses to run when an
+// implausible share of the catalogue vanished, since that points at a broken
+// crawl rather than real delistings. It returns how many updates failed.
+func markDelistedInAirtable(ctx context.Context, client *airtable.Client, airtableCfg config.AirtableConfig, existing []airtable.Record, seen map[string]bool) int {
+    active := 0
+    var delisted []airtable.Record
+    for _, record := range existing {
+        sku, _ := record.Fields[airtableMergeField].(string)
+        if sku == "" || record.Fields["isActive"] == "false" {
+            continue
+        }
+        active++
+        if seen[sku] {
+            continue
+        }
+
+        fields := map[string]interface{}{
+            "isActive":     "false",
+            "isOutofStock": "true",
+        }
+        if airtableCfg.DelistedDateField != "" {
+            fields[airtableCfg.DelistedDateField] = time.Now().UTC().Format("2006-01-02")
+        }
+        delisted = append(delisted, airtable.Record{ID: record.ID, Fields: fields})
+    }
+
+    if len(delisted) == 0 {
+        fmt.Fprintln(stdout, "No delisted products found in Airtable.")
+        return 0
+    }
+    fraction := float64(len(delisted)) / float64(active)
+    if fraction > airtableCfg.MaxDelistedFraction {
+        log.Printf("Error: %d of %d active Airtable rows (%.1f%%) were not seen in this crawl, above the %.1f%% safety threshold (airtable.max_delisted_fraction). Not marking anything delisted.",
+            len(delisted), active, fraction*100, airtableCfg.MaxDelistedFraction*100)
+        return 0
+    }
+
+    fmt.Fprintf(stdout, "Marking %d delisted products inactive in Airtable...\n", len(delisted))
+    result := client.UpdateAll(ctx, delisted)
+    deadLetter(airtableCfg, airtable.NewDeadLetters(airtable.OpUpdate, nil, result.Failures))
+    fmt.Fprintf(stdout, "Delisting finished: %d of %d rows marked inactive.\n", len(result.Updated), len(delisted))
+    return len(result.Failures)
+}
+
+func newAirtableClient(airtableCfg config.AirtableConfig) *airtable.Client {
+    return airtable.NewClient(airtable.Config{
+        TableURL:          airtableCfg.TableURL,
+        Token:             airtableCfg.Token.Reveal(),
+        UserAgent:         airtableUserAgent,
+        MaxRetries:        retriesSetting(airtableCfg.MaxRetries),
+        RequestsPerSecond: airtableCfg.RequestsPerSecond,
+        RateLimitPause:    airtableCfg.RateLimitPause,
+    })
+}
+
+// deadLetter logs each failed record and appends it to the dead-letter file
+// so `retry-failed` can replay it later
+func deadLetter(airtableCfg config.AirtableConfig, letters []airtable.DeadLetter) {
+    if len(letters) == 0 {
+        return
+    }
+    for _, letter := range letters {
+        log.Printf("Error: Airtable %s failed for SKU %v: %s", letter.Operation, letter.Record.Fields[airtableMergeField], letter.Error)
+    }
+    if airtableCfg.DeadLetterFile == "" {
+        log.Printf("Warning: %d failed Airtable records were dropped (no airtable.dead_letter_file configured).", len(letters))
+        return
+    }
+    if err := airtable.AppendDeadLetters(airtableCfg.DeadLetterFile, letters); err != nil {
+        log.Printf("Error writing %d failed Airtable records to %s: %v", len(letters), airtableCfg.DeadLetterFile, err)
+        return
+    }
+    fmt.Fprintf(stdout, "Saved %d failed Airtable records to %s. Run `retry-failed` to replay them.\n", len(letters), airtableCfg.DeadLetterFile)
+}
+
+// runRetryFailed replays the dead-letter file. Records that fail again stay
+// in the file with their attempt count bumped; the rest are removed.
+func runRetryFailed(args []string) {
+    fs := flag.NewFlagSet("retry-failed", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: theWhiskyExchangeCrawler retry-failed [flags]")
+        fs.PrintDefaults()
+    }
+    configFlags := config.RegisterFlags(fs)
+    fs.Parse(args)
+    cfg := loadConfig(configFlags)
+
+    if cfg.Airtable.TableURL == "" || cfg.Airtable.DeadLetterFile == "" {
+        fmt.Fprintln(stderr, "Airtable is not configured (airtable.table_url and airtable.dead_letter_file are required).")
+        os.Exit(exitUsage)
+    }
+
+    path := cfg.Airtable.DeadLetterFile
+    letters, err := airtable.ReadDeadLetters(path)
+    if err != nil {
+        log.Fatalf("Error reading %s: %v", path, err)
+    }
+    if len(letters) == 0 {
+        fmt.Fprintf(stdout, "Nothing to retry: %s is empty.\n", path)
+        return
+    }
+
+    // Replay each operation (and upsert merge key) as its own batch run
+    groups := make(map[string][]airtable.DeadLetter)
+    var order []string
+    for _, letter := range letters {
+        key := letter.Operation + "\x00" + strings.Join(letter.MergeOn, ",")
+        if _, ok := groups[key]; !ok {
+            order = append(order, key)
+        }
+        groups[key] = append(groups[key], letter)
+    }
+
+    ctx := context.Background()
+    client := newAirtableClient(cfg.Airtable)
+    var remaining []airtable.DeadLetter
+    succeeded := 0
+    for _, key := range order {
+        group := groups[key]
+        records := make([]airtable.Record, len(group))
+        for i, letter := range group {
+            records[i] = letter.Record
+        }
+
+        var result airtable.BatchResult
+        switch group[0].Operation {
+        case airtable.OpUpsert:
+            result = client.UpsertAll(ctx, records, group[0].MergeOn)
+        case airtable.OpUpdate:
+            result = client.UpdateAll(ctx, records)
+        default:
+            log.Printf("Warning: keeping %d dead letters with unknown operation %q", len(group), group[0].Operation)
+            remaining = append(remaining, group...)
+            continue
+        }
+
+        failed := make(map[int]error, len(result.Failures))
+        for _, failure := range result.Failures {
+            failed[failure.Index] = failure.Err
+        }
+        for i, letter := range group {
+            if err, ok := failed[i]; ok {
+                letter.Error = err.Error()
+                letter.FailedAt = time.Now().UTC()
+                letter.Attempts++
+                remaining = append(remaining, letter)
+                log.Printf("Error: Airtable %s failed again for SKU %v: %v", letter.Operation, letter.Record.Fields[airtableMergeField], err)
+                continue
+            }
+            succeeded++
+        }
+    }
+
+    if err := airtable.WriteDeadLetters(path, remaining); err != nil {
+        log.Fatalf("Error rewriting %s: %v", path, err)
+    }
+    fmt.Fprintf(stdout, "Retried %d failed Airtable records: %d succeeded, %d still failing.\n", len(letters), succeeded, len(remaining))
+    if len(remaining) > 0 {
+        os.Exit(exitFailed)
+    }
+}
@@ -0,0 +1,82 @@
+package airtable
+
+import (
+    "context"
+    "errors"
+    "net/http"
+)
+
+// Failure is a record Airtable did not accept, with the reason.
+type Failure struct {
+    Index  int // position in the records passed to UpsertAll/UpdateAll
+    Record Record
+    Err    error
+}
+
+// BatchResult aggregates the outcome of UpsertAll or UpdateAll.
+type BatchResult struct {
+    Created  []string // record IDs created by upserts
+    Updated  []string // record IDs updated
+    Failures []Failure
+}
+
+// UpsertAll upserts records in batches of MaxRecordsPerRequest. Airtable
+// rejects a whole batch with 422 when any record in it is invalid, so such a
+// batch is retried record by record to pin the failure on the records
+// actually at fault.
+func (c *Client) UpsertAll(ctx context.Context, records []Record, mergeOn []string) BatchResult {
+    var result BatchResult
+    c.eachBatch(ctx, records, &result, func(batch []Record) error {
+        res, err := c.Upsert(ctx, batch, mergeOn)
+        if err == nil {
+            result.Created = append(result.Created, res.CreatedRecords...)
+            result.Updated = append(result.Updated, res.UpdatedRecords...)
+        }
+        return err
+    })
+    return result
+}
+
+// UpdateAll updates records (by ID) in batches, isolating invalid records
+// the same way as UpsertAll.
+func (c *Client) UpdateAll(ctx context.Context, records []Record) BatchResult {
+    var result BatchResult
+    c.eachBatch(ctx, records, &result, func(batch []Record) error {
+        updated, err := c.Update(ctx, batch)
+        if err == nil {
+            for _, r := range updated {
+                result.Updated = append(result.Updated, r.ID)
+            }
+        }
+        return err
+    })
+    return result
+}
+
+func (c *Client) eachBatch(ctx context.Context, records []Record, result *BatchResult, send func([]Record) error) {
+    for i := 0; i < len(records); i += MaxRecordsPerRequest {
+        end := i + MaxRecordsPerRequest
+        if end > len(records) {
+            end = len(records)
+        }
+        batch := records[i:end]
+
+        err := send(batch)
+        if err == nil {
+            continue
+        }
+
+        var apiErr *APIError
+        if len(batch) > 1 && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity {
+            for j, record := range batch {
+                if err := send([]Record{record}); err != nil {
+                    result.Failures = append(result.Failures, Failure{Index: i + j, Record: record, Err: err})
+                }
+            }
+            continue
+        }
+        for j, record := range batch {
+            result.Failures = append(result.Failures, Failure{Index: i + j, Record: record, Err: err})
+        }
+    }
+}
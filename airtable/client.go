@@ -0,0 +1,310 @@
+// Package airtable is a small client for the Airtable Web API, covering the
+// calls the crawler needs: listing a table and upserting records.
+package airtable
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math/rand"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+// MaxRecordsPerRequest is Airtable's limit for create/update/upsert calls.
+const MaxRecordsPerRequest = 10
+
+const (
+    // DefaultRequestsPerSecond is Airtable's per-base rate limit.
+    DefaultRequestsPerSecond = 5
+    // DefaultMaxRetries is how often a request is retried after a 429, a 5xx
+    // or a network error before giving up.
+    DefaultMaxRetries = 5
+    // DefaultRateLimitPause is how long Airtable asks clients to wait after a
+    // 429 when the response carries no Retry-After header.
+    DefaultRateLimitPause = 30 * time.Second
+
+    baseBackoff = time.Second
+    maxBackoff  = time.Minute
+)
+
+// Config describes one Airtable table.
+type Config struct {
+    TableURL          string // https://api.airtable.com/v0/{baseId}/{tableIdOrName}
+    Token             string
+    UserAgent         string
+    HTTPClient        *http.Client
+    RequestsPerSecond float64       // default DefaultRequestsPerSecond
+    MaxRetries        int           // default DefaultMaxRetries; negative disables retries
+    RateLimitPause    time.Duration // default DefaultRateLimitPause
+}
+
+// Client talks to a single Airtable table. It is safe for concurrent use;
+// all calls share one rate limiter.
+type Client struct {
+    tableURL       string
+    token          string
+    userAgent      string
+    httpClient     *http.Client
+    limiter        *tokenBucket
+    maxRetries     int
+    rateLimitPause time.Duration
+}
+
+// NewClient builds a Client for cfg.
+func NewClient(cfg Config) *Client {
+    c := &Client{
+        tableURL:       cfg.TableURL,
+        token:          cfg.Token,
+        userAgent:      cfg.UserAgent,
+        httpClient:     cfg.HTTPClient,
+        maxRetries:     cfg.MaxRetries,
+        rateLimitPause: cfg.RateLimitPause,
+    }
+    if c.httpClient == nil {
+        c.httpClient = &http.Client{Timeout: 30 * time.Second}
+    }
+    if c.maxRetries == 0 {
+        c.maxRetries = DefaultMaxRetries
+    }
+    if c.rateLimitPause <= 0 {
+        c.rateLimitPause = DefaultRateLimitPause
+    }
+    rate := cfg.RequestsPerSecond
+    if rate <= 0 {
+        rate = DefaultRequestsPerSecond
+    }
+    // No burst: a full bucket followed by steady refill would put almost
+    // twice the limit into one second. Evenly spaced requests never do.
+    c.limiter = newTokenBucket(rate, 1)
+    return c
+}
+
+// Record is an Airtable row. ID is empty for records not yet created.
+type Record struct {
+    ID     string                 `json:"id,omitempty"`
+    Fields map[string]interface{} `json:"fields"`
+}
+
+// APIError is returned for non-2xx responses. Type and Message come from
+// Airtable's error JSON, e.g. INVALID_VALUE_FOR_COLUMN or UNKNOWN_FIELD_NAME.
+type APIError struct {
+    StatusCode int
+    Type       string
+    Message    string
+    Body       string
+    RetryAfter time.Duration // from the Retry-After header, if any
+}
+
+func (e *APIError) Error() string {
+    switch {
+    case e.Type != "" && e.Message != "":
+        return fmt.Sprintf("airtable returned status %d: %s: %s", e.StatusCode, e.Type, e.Message)
+    case e.Type != "":
+        return fmt.Sprintf("airtable returned status %d: %s", e.StatusCode, e.Type)
+    }
+    return fmt.Sprintf("airtable returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether retrying the same request later may succeed.
+func (e *APIError) Temporary() bool {
+    return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// parseAPIError decodes both forms of Airtable's error body:
+// {"error":{"type":"...","message":"..."}} and {"error":"NOT_FOUND"}.
+func parseAPIError(resp *http.Response, body []byte) *APIError {
+    apiErr := &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+
+    var envelope struct {
+        Error json.RawMessage `json:"error"`
+    }
+    if json.Unmarshal(body, &envelope) == nil && len(envelope.Error) > 0 {
+        var detailed struct {
+            Type    string `json:"type"`
+            Message string `json:"message"`
+        }
+        if json.Unmarshal(envelope.Error, &detailed) == nil {
+            apiErr.Type, apiErr.Message = detailed.Type, detailed.Message
+        } else {
+            json.Unmarshal(envelope.Error, &apiErr.Type)
+        }
+    }
+
+    if value := resp.Header.Get("Retry-After"); value != "" {
+        if seconds, err := strconv.Atoi(value); err == nil {
+            apiErr.RetryAfter = time.Duration(seconds) * time.Second
+        } else if at, err := http.ParseTime(value); err == nil {
+            apiErr.RetryAfter = time.Until(at)
+        }
+    }
+    return apiErr
+}
+
+// ListRecords returns every record in the table, following the offset
+// cursor across pages. fields limits the returned columns when non-empty.
+func (c *Client) ListRecords(ctx context.Context, fields []string) ([]Record, error) {
+    var records []Record
+    offset := ""
+    for {
+        query := url.Values{}
+        query.Set("pageSize", "100")
+        for _, f := range fields {
+            query.Add("fields[]", f)
+        }
+        if offset != "" {
+            query.Set("offset", offset)
+        }
+
+        var page struct {
+            Records []Record `json:"records"`
+            Offset  string   `json:"offset"`
+        }
+        if err := c.do(ctx, http.MethodGet, c.tableURL+"?"+query.Encode(), nil, &page); err != nil {
+            return records, err
+        }
+        records = append(records, page.Records...)
+        if page.Offset == "" {
+            return records, nil
+        }
+        offset = page.Offset
+    }
+}
+
+// UpsertResult reports what one upsert call did.
+type UpsertResult struct {
+    CreatedRecords []string `json:"createdRecords"`
+    UpdatedRecords []string `json:"updatedRecords"`
+    Records        []Record `json:"records"`
+}
+
+// Upsert creates or updates up to MaxRecordsPerRequest records, matching
+// existing rows on the mergeOn fields.
+func (c *Client) Upsert(ctx context.Context, records []Record, mergeOn []string) (*UpsertResult, error) {
+    if len(records) > MaxRecordsPerRequest {
+        return nil, fmt.Errorf("airtable accepts at most %d records per request, got %d", MaxRecordsPerRequest, len(records))
+    }
+
+    payload := map[string]interface{}{
+        "performUpsert": map[string]interface{}{"fieldsToMergeOn": mergeOn},
+        "records":       records,
+    }
+    var result UpsertResult
+    if err := c.do(ctx, http.MethodPatch, c.tableURL, payload, &result); err != nil {
+        return nil, err
+    }
+    return &result, nil
+}
+
+// Update changes fields on existing records (by ID), up to
+// MaxRecordsPerRequest at a time. Fields not mentioned are left untouched.
+func (c *Client) Update(ctx context.Context, records []Record) ([]Record, error) {
+    if len(records) > MaxRecordsPerRequest {
+        return nil, fmt.Errorf("airtable accepts at most %d records per request, got %d", MaxRecordsPerRequest, len(records))
+    }
+
+    var result struct {
+        Records []Record `json:"records"`
+    }
+    if err := c.do(ctx, http.MethodPatch, c.tableURL, map[string]interface{}{"records": records}, &result); err != nil {
+        return nil, err
+    }
+    return result.Records, nil
+}
+
+// do sends one request, retrying rate limits, server errors and network
+// failures with exponential backoff, and decodes a JSON response into out.
+func (c *Client) do(ctx context.Context, method, url string, body, out interface{}) error {
+    var payload []byte
+    if body != nil {
+        var err error
+        if payload, err = json.Marshal(body); err != nil {
+            return err
+        }
+    }
+
+    for attempt := 0; ; attempt++ {
+        retryable, err := c.attempt(ctx, method, url, payload, out)
+        if err == nil || !retryable || ctx.Err() != nil || attempt >= c.maxRetries {
+            return err
+        }
+
+        delay := backoff(attempt)
+        var apiErr *APIError
+        if errors.As(err, &apiErr) {
+            if apiErr.StatusCode == http.StatusTooManyRequests {
+                delay = c.rateLimitPause
+                if apiErr.RetryAfter > 0 {
+                    delay = apiErr.RetryAfter
+                }
+                c.limiter.pause(delay)
+            }
+        }
+
+        timer := time.NewTimer(delay)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return err
+        case <-timer.C:
+        }
+    }
+}
+
+// attempt sends the request once and reports whether a failure is worth
+// retrying: network errors, 429s and 5xx responses are.
+func (c *Client) attempt(ctx context.Context, method, url string, payload []byte, out interface{}) (retryable bool, err error) {
+    if err := c.limiter.Wait(ctx); err != nil {
+        return false, err
+    }
+
+    var reader io.Reader
+    if payload != nil {
+        reader = bytes.NewReader(payload)
+    }
+    req, err := http.NewRequestWithContext(ctx, method, url, reader)
+    if err != nil {
+        return false, err
+    }
+    req.Header.Set("Authorization", "Bearer "+c.token)
+    if payload != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    if c.userAgent != "" {
+        req.Header.Set("User-Agent", c.userAgent)
+    }
+
+    resp, err := c.httpClient.Do(req)
+    if err != nil {
+        return true, err
+    }
+    defer resp.Body.Close()
+
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return true, err
+    }
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        apiErr := parseAPIError(resp, respBody)
+        return apiErr.Temporary(), apiErr
+    }
+    if out == nil {
+        return false, nil
+    }
+    return false, json.Unmarshal(respBody, out)
+}
+
+// backoff returns the delay before retry number attempt+1: exponential with
+// jitter, capped at maxBackoff.
+func backoff(attempt int) time.Duration {
+    d := baseBackoff << uint(attempt)
+    if d <= 0 || d > maxBackoff {
+        d = maxBackoff
+    }
+    return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,120 @@
+package airtable
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// Dead-letter operations.
+const (
+    OpUpsert = "upsert"
+    OpUpdate = "update"
+)
+
+// DeadLetter is one record that could not be written, kept so it can be
+// replayed later. The file is JSON Lines, one DeadLetter per line.
+type DeadLetter struct {
+    Operation string    `json:"operation"`
+    MergeOn   []string  `json:"mergeOn,omitempty"`
+    Record    Record    `json:"record"`
+    Error     string    `json:"error"`
+    FailedAt  time.Time `json:"failedAt"`
+    Attempts  int       `json:"attempts"`
+}
+
+// NewDeadLetters converts failures from one operation into dead letters.
+func NewDeadLetters(operation string, mergeOn []string, failures []Failure) []DeadLetter {
+    now := time.Now().UTC()
+    letters := make([]DeadLetter, 0, len(failures))
+    for _, f := range failures {
+        letters = append(letters, DeadLetter{
+            Operation: operation,
+            MergeOn:   mergeOn,
+            Record:    f.Record,
+            Error:     f.Err.Error(),
+            FailedAt:  now,
+            Attempts:  1,
+        })
+    }
+    return letters
+}
+
+// AppendDeadLetters adds entries to the dead-letter file at path.
+func AppendDeadLetters(path string, entries []DeadLetter) error {
+    if len(entries) == 0 {
+        return nil
+    }
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+    if err != nil {
+        return err
+    }
+    enc := json.NewEncoder(f)
+    for _, entry := range entries {
+        if err := enc.Encode(entry); err != nil {
+            f.Close()
+            return err
+        }
+    }
+    return f.Close()
+}
+
+// ReadDeadLetters loads every entry from path. A missing file is empty.
+func ReadDeadLetters(path string) ([]DeadLetter, error) {
+    f, err := os.Open(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var entries []DeadLetter
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+    for line := 1; scanner.Scan(); line++ {
+        if len(scanner.Bytes()) == 0 {
+            continue
+        }
+        var entry DeadLetter
+        if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+            return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+        }
+        entries = append(entries, entry)
+    }
+    return entries, scanner.Err()
+}
+
+// WriteDeadLetters replaces the file at path with entries, atomically. The
+// file is removed when entries is empty.
+func WriteDeadLetters(path string, entries []DeadLetter) error {
+    if len(entries) == 0 {
+        if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+            return err
+        }
+        return nil
+    }
+
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+    if err != nil {
+        return err
+    }
+    enc := json.NewEncoder(tmp)
+    for _, entry := range entries {
+        if err := enc.Encode(entry); err != nil {
+            tmp.Close()
+            os.Remove(tmp.Name())
+            return err
+        }
+    }
+    if err := tmp.Close(); err != nil {
+        os.Remove(tmp.Name())
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
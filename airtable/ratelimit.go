@@ -0,0 +1,66 @@
+package airtable
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// tokenBucket is a simple token bucket: tokens refill continuously at rate
+// per second up to burst, and each request takes one.
+type tokenBucket struct {
+    mu     sync.Mutex
+    rate   float64
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+    return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (b *tokenBucket) Wait(ctx context.Context) error {
+    for {
+        b.mu.Lock()
+        now := time.Now()
+        var wait time.Duration
+        if now.Before(b.last) {
+            // Paused: nothing refills until b.last.
+            wait = b.last.Sub(now)
+        } else {
+            b.tokens += now.Sub(b.last).Seconds() * b.rate
+            if b.tokens > b.burst {
+                b.tokens = b.burst
+            }
+            b.last = now
+            if b.tokens >= 1 {
+                b.tokens--
+                b.mu.Unlock()
+                return nil
+            }
+            wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+        }
+        b.mu.Unlock()
+
+        timer := time.NewTimer(wait)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return ctx.Err()
+        case <-timer.C:
+        }
+    }
+}
+
+// pause empties the bucket and delays the next refill by d, so every caller
+// backs off together after the server told us to slow down.
+func (b *tokenBucket) pause(d time.Duration) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.tokens = 0
+    if until := time.Now().Add(d); until.After(b.last) {
+        b.last = until
+    }
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+
+    "theWhiskyExchangeCrawler/config"
+)
+
+func TestAirtableClientRetries(t *testing.T) {
+    tests := []struct {
+        maxRetries   int
+        wantRequests int32
+    }{
+        {maxRetries: 0, wantRequests: 1},
+        {maxRetries: 1, wantRequests: 2},
+    }
+    for _, tt := range tests {
+        var requests atomic.Int32
+        srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            requests.Add(1)
+            if ua := r.UserAgent(); ua != "theWhiskyExchangeCrawler/dev" {
+                t.Errorf("User-Agent %q, want the crawler's own", ua)
+            }
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }))
+        client := newAirtableClient(config.AirtableConfig{TableURL: srv.URL, MaxRetries: tt.maxRetries, RequestsPerSecond: 100})
+        if _, err := client.ListRecords(context.Background(), nil); err == nil {
+            t.Errorf("max_retries %d: ListRecords succeeded against a failing server", tt.maxRetries)
+        }
+        srv.Close()
+        if got := requests.Load(); got != tt.wantRequests {
+            t.Errorf("max_retries %d: %d requests, want %d", tt.maxRetries, got, tt.wantRequests)
+        }
+    }
+}
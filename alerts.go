@@ -0,0 +1,102 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+
+    "theWhiskyExchangeCrawler/config"
+    "theWhiskyExchangeCrawler/twe"
+    "theWhiskyExchangeCrawler/watch"
+)
+
+// crawlWatch keeps the watched products of a crawl and checks the watchlist
+// against them once the crawl has finished. A nil *crawlWatch means no
+// watchlist is configured and all its methods do nothing.
+type crawlWatch struct {
+    list     *watch.List
+    path     string
+    products []twe.Product
+}
+
+// newCrawlWatch loads the configured watchlist, returning nil when there is
+// none.
+func newCrawlWatch(cfg config.AlertsConfig) (*crawlWatch, error) {
+    if cfg.Watchlist == "" {
+        return nil, nil
+    }
+    list, err := watch.Load(cfg.Watchlist)
+    if err != nil {
+        return nil, err
+    }
+    return &crawlWatch{list: list, path: cfg.Watchlist}, nil
+}
+
+// observe keeps the products any watch follows.
+func (w *crawlWatch) observe(products []twe.Product) {
+    if w == nil {
+        return
+    }
+    for _, p := range products {
+        if w.list.Follows(p) {
+            w.products = append(w.products, p)
+        }
+    }
+}
+
+// alertReport is the JSON file of the alerts one crawl fired.
+type alertReport struct {
+    Watchlist string        `json:"watchlist"`
+    Run       int64         `json:"run,omitempty"`
+    Since     int64         `json:"since,omitempty"`
+    Watched   int           `json:"watched"`
+    Alerts    []watch.Alert `json:"alerts"`
+}
+
+// evaluate checks the watched products against the previous crawl of the
+// same query in the history, prints the alerts fired, writes them to path
+// and returns them. Without a history only the price and stock thresholds
+// can fire.
+func (w *crawlWatch) evaluate(ctx context.Context, history *crawlHistory, path string) []watch.Alert {
+    if w == nil {
+        return nil
+    }
+    report := alertReport{Watchlist: w.path, Run: history.runID(), Watched: len(w.products)}
+    previous, before, err := history.previous(ctx)
+    if err != nil {
+        log.Printf("Warning: checking the watchlist without the previous crawl: %v", err)
+    }
+    report.Since = previous
+    report.Alerts = w.list.Evaluate(before, w.products)
+    if report.Alerts == nil {
+        report.Alerts = []watch.Alert{}
+    }
+
+    printAlerts(report)
+    if path == "" {
+        return report.Alerts
+    }
+    data, err := json.MarshalIndent(report, "", "  ")
+    if err == nil {
+        err = os.WriteFile(path, append(data, '\n'), 0o644)
+    }
+    if err != nil {
+        log.Printf("Warning: cannot write the alerts: %v", err)
+        return report.Alerts
+    }
+    fmt.Fprintf(stdout, "Wrote the alerts to %s\n", path)
+    return report.Alerts
+}
+
+func printAlerts(report alertReport) {
+    fmt.Fprintf(stdout, "Watchlist: %d products watched, %d alerts", report.Watched, len(report.Alerts))
+    if report.Since == 0 {
+        fmt.Fprint(stdout, " (no earlier crawl to compare with)")
+    }
+    fmt.Fprintln(stdout)
+    for _, a := range report.Alerts {
+        fmt.Fprintf(stdout, "  [%s] %s  %s  %s\n", a.Watch, a.ProductID, a.Name, a.Describe())
+    }
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "sort"
+
+    "theWhiskyExchangeCrawler/config"
+    "theWhiskyExchangeCrawler/sink"
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// auditProduct is what the audit keeps of each product it sees.
+type auditProduct struct {
+    ProductID    string  `json:"productId"`
+    Name         string  `json:"name"`
+    SalesPrice   float64 `json:"salesPrice"`
+    IsOutOfStock bool    `json:"isOutOfStock"`
+}
+
+func newAuditProduct(p twe.Product) auditProduct {
+    return auditProduct{ProductID: p.ProductID, Name: p.Name, SalesPrice: p.SalesPrice, IsOutOfStock: p.IsOutOfStock}
+}
+
+// auditReport is the outcome of an audit, as written by -json.
+type auditReport struct {
+    Query    string           `json:"query"`
+    Baseline int              `json:"baseline"`
+    Sharded  int              `json:"sharded"`
+    Cap      int              `json:"cap"`
+    Bands    []twe.BandResult `json:"bands"`
+    // Missed were listed by a price band but not by the plain crawl.
+    Missed []auditProduct `json:"missed"`
+    // Unbanded were listed by the plain crawl but by no price band.
+    Unbanded []auditProduct `json:"unbanded"`
+}
+
+// runAudit checks whether a crawl is complete: it lists the same query again
+// in price bands, splitting every band that looks capped, and reports the
+// products the plain crawl did not return.
+func runAudit(args []string) {
+    fs := flag.NewFlagSet("audit", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: theWhiskyExchangeCrawler audit [flags]")
+        fs.PrintDefaults()
+    }
+    queryExpr := fs.String("query", "", "the crawl to audit (default the catalogue-wide search)")
+    baselinePath := fs.String("baseline", "", "read the plain crawl from this .json, .ndjson or .jsonl output instead of crawling it")
+    limit := fs.Int("cap", 0, "product count at which a price band looks capped and is split (default the plain crawl's count)")
+    jsonPath := fs.String("json", "", "also write the report to this JSON file")
+    configFlags := config.RegisterFlags(fs)
+    fs.Parse(args)
+
+    cfg := loadConfig(configFlags)
+    if err := cfg.RequireAPIToken(); err != nil {
+        fmt.Fprintln(stderr, "Configuration error:", err)
+        os.Exit(exitUsage)
+    }
+    query, err := buildQuery(*queryExpr, "", 0, "")
+    if err != nil {
+        fmt.Fprintln(stderr, "Invalid query:", err)
+        os.Exit(exitUsage)
+    }
+    if query.Filters.Price != nil {
+        fmt.Fprintln(stderr, "Invalid query: the audit sets the price filter itself")
+        os.Exit(exitUsage)
+    }
+    if *baselinePath != "" && !sink.Readable(*baselinePath) {
+        fmt.Fprintln(stderr, "-baseline must be a .json, .ndjson or .jsonl crawl output")
+        os.Exit(exitUsage)
+    }
+
+    ctx := context.Background()
+    client := newTWEClient(cfg, nil)
+    baseline := make(map[string]auditProduct)
+    if *baselinePath != "" {
+        err := sink.ReadJSON(*baselinePath, func(p twe.Product) error {
+            baseline[p.ProductID] = newAuditProduct(p)
+            return nil
+        })
+        if err != nil {
+            log.Printf("Cannot read the baseline: %v", err)
+            os.Exit(exitFailed)
+        }
+        fmt.Fprintf(stdout, "Plain crawl: %d products in %s\n", len(baseline), *baselinePath)
+    } else {
+        fmt.Fprintln(stdout, "Crawling the plain query")
+        it := client.ListProducts(ctx, query)
+        for it.Next() {
+            page := it.Page()
+            fmt.Fprintf(stdout, "Fetched page %d of %d (%d products)\n", page.Number, page.TotalPages, len(page.Products))
+            for _, p := range page.Products {
+                baseline[p.ProductID] = newAuditProduct(p)
+            }
+        }
+        if err := it.Err(); err != nil {
+            log.Printf("The audit failed: %v", err)
+            os.Exit(failureStatus(err))
+        }
+        fmt.Fprintf(stdout, "Plain crawl: %d products\n", len(baseline))
+    }
+
+    report := auditReport{Query: query.String(), Baseline: len(baseline), Cap: *limit}
+    if report.Cap <= 0 {
+        report.Cap = len(baseline)
+    }
+    if report.Cap <= 0 {
+        fmt.Fprintln(stderr, "The plain crawl found no products; set -cap to audit it.")
+        os.Exit(exitUsage)
+    }
+
+    fmt.Fprintf(stdout, "Listing by price, in and out of stock, splitting bands of %d or more products\n", report.Cap)
+    sharded := make(map[string]auditProduct)
+    err = client.ShardByPrice(ctx, query, report.Cap, func(p twe.Product) {
+        sharded[p.ProductID] = newAuditProduct(p)
+    }, func(band twe.BandResult) {
+        report.Bands = append(report.Bands, band)
+        switch {
+        case band.Split:
+            fmt.Fprintf(stdout, "  £%s: %d or more products, splitting\n", band.Band, band.Products)
+        case band.Capped:
+            log.Printf("Warning: £%s still lists %d products and cannot be split further; it may be capped", band.Band, band.Products)
+        default:
+            fmt.Fprintf(stdout, "  £%s: %d products\n", band.Band, band.Products)
+        }
+    })
+    if err != nil {
+        log.Printf("The audit failed: %v", err)
+        os.Exit(failureStatus(err))
+    }
+    report.Sharded = len(sharded)
+
+    for id, p := range sharded {
+        if _, ok := baseline[id]; !ok {
+            report.Missed = append(report.Missed, p)
+        }
+    }
+    for id, p := range baseline {
+        if _, ok := sharded[id]; !ok {
+            report.Unbanded = append(report.Unbanded, p)
+        }
+    }
+    sortAuditProducts(report.Missed)
+    sortAuditProducts(report.Unbanded)
+    printAuditReport(report, query.Filters.IncludeOutOfStock)
+
+    if *jsonPath != "" {
+        data, err := json.MarshalIndent(report, "", "  ")
+        if err == nil {
+            err = os.WriteFile(*jsonPath, append(data, '\n'), 0o644)
+        }
+        if err != nil {
+            log.Fatalf("Error writing %s: %v", *jsonPath, err)
+        }
+        fmt.Fprintf(stdout, "Wrote %s\n", *jsonPath)
+    }
+}
+
+// sortAuditProducts orders products by price, then ProductID.
+func sortAuditProducts(products []auditProduct) {
+    sort.Slice(products, func(i, j int) bool {
+        if products[i].SalesPrice != products[j].SalesPrice {
+            return products[i].SalesPrice < products[j].SalesPrice
+        }
+        return twe.LessProductID(products[i].ProductID, products[j].ProductID)
+    })
+}
+
+// printAuditReport lists what the plain crawl missed. Out-of-stock products
+// are counted apart, since the plain crawl leaves them out unless it
+// includes out-of-stock products.
+func printAuditReport(report auditReport, includesOutOfStock bool) {
+    fmt.Fprintf(stdout, "Price bands: %d products in %d bands; plain crawl: %d products\n", report.Sharded, len(report.Bands), report.Baseline)
+    if len(report.Missed) == 0 {
+        fmt.Fprintln(stdout, "The plain crawl missed no product.")
+    } else {
+        outOfStock := 0
+        for _, p := range report.Missed {
+            if p.IsOutOfStock {
+                outOfStock++
+            }
+        }
+        fmt.Fprintf(stdout, "The plain crawl missed %d products, %d in stock and %d out of stock:\n", len(report.Missed), len(report.Missed)-outOfStock, outOfStock)
+        for _, p := range report.Missed {
+            stock := ""
+            if p.IsOutOfStock {
+                stock = " (out of stock)"
+            }
+            fmt.Fprintf(stdout, "  %s  £%.2f  %s%s\n", p.ProductID, p.SalesPrice, p.Name, stock)
+        }
+        if outOfStock > 0 && !includesOutOfStock {
+            fmt.Fprintln(stdout, "Out-of-stock products are expected to be missing: the query does not set outofstock.")
+        }
+    }
+    if len(report.Unbanded) > 0 {
+        fmt.Fprintf(stdout, "%d products of the plain crawl are in no price band (no price, or a gap between bands):\n", len(report.Unbanded))
+        for _, p := range report.Unbanded {
+            fmt.Fprintf(stdout, "  %s  £%.2f  %s\n", p.ProductID, p.SalesPrice, p.Name)
+        }
+    }
+}
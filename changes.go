@@ -0,0 +1,255 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+
+    "theWhiskyExchangeCrawler/config"
+    "theWhiskyExchangeCrawler/sink"
+    "theWhiskyExchangeCrawler/store"
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// changeSource is one side of a change report: a recorded run or a crawl
+// output file.
+type changeSource struct {
+    Run       int64  `json:"run,omitempty"`
+    File      string `json:"file,omitempty"`
+    Query     string `json:"query"`
+    StartedAt string `json:"startedAt,omitempty"`
+    Products  int    `json:"products"`
+}
+
+func (s changeSource) String() string {
+    if s.File != "" {
+        return s.File
+    }
+    return fmt.Sprintf("run %d (%s)", s.Run, s.StartedAt)
+}
+
+func runSource(run store.RunInfo, products int) changeSource {
+    return changeSource{Run: run.ID, Query: run.Query, StartedAt: run.StartedAt.Format("2006-01-02 15:04"), Products: products}
+}
+
+// changeReport is the machine-readable report of what changed between two
+// crawls.
+type changeReport struct {
+    From    changeSource           `json:"from"`
+    To      changeSource           `json:"to"`
+    Counts  map[twe.ChangeKind]int `json:"counts"`
+    Changes []twe.ProductChange    `json:"changes"`
+}
+
+func newChangeReport(from, to changeSource, before, after map[string]twe.Product) changeReport {
+    report := changeReport{From: from, To: to, Counts: make(map[twe.ChangeKind]int)}
+    report.Changes = twe.DiffProducts(before, after)
+    for _, change := range report.Changes {
+        report.Counts[change.Kind]++
+    }
+    if report.Changes == nil {
+        report.Changes = []twe.ProductChange{}
+    }
+    return report
+}
+
+// changesSincePrevious compares run with the previous finished run of the
+// same query. ok is false when there is no earlier run to compare with.
+func changesSincePrevious(ctx context.Context, db *store.Store, run store.RunInfo) (report changeReport, ok bool, err error) {
+    previous, ok, err := db.PreviousRun(ctx, run)
+    if err != nil || !ok {
+        return report, false, err
+    }
+    report, err = compareRuns(ctx, db, previous, run)
+    return report, err == nil, err
+}
+
+// compareRuns reports what changed from run from to run to.
+func compareRuns(ctx context.Context, db *store.Store, from, to store.RunInfo) (changeReport, error) {
+    before, err := db.Snapshot(ctx, from.ID)
+    if err != nil {
+        return changeReport{}, err
+    }
+    after, err := db.Snapshot(ctx, to.ID)
+    if err != nil {
+        return changeReport{}, err
+    }
+    return newChangeReport(runSource(from, len(before)), runSource(to, len(after)), before, after), nil
+}
+
+// writeChangeReport writes report as indented JSON to path.
+func writeChangeReport(path string, report changeReport) error {
+    data, err := json.MarshalIndent(report, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// printChangeSummary prints the counts of report and up to limit changes of
+// each kind; limit 0 prints them all.
+func printChangeSummary(report changeReport, limit int) {
+    fmt.Fprintf(stdout, "Changes from %s to %s:", report.From, report.To)
+    if len(report.Changes) == 0 {
+        fmt.Fprintln(stdout, " none")
+        return
+    }
+    sep := " "
+    for _, kind := range twe.ChangeKinds {
+        if n := report.Counts[kind]; n > 0 {
+            fmt.Fprintf(stdout, "%s%d %s", sep, n, kind.Heading())
+            sep = ", "
+        }
+    }
+    fmt.Fprintln(stdout)
+
+    shown := make(map[twe.ChangeKind]int)
+    for _, change := range report.Changes {
+        shown[change.Kind]++
+        n := shown[change.Kind]
+        if n == 1 {
+            fmt.Fprintf(stdout, "%s:\n", change.Kind.Heading())
+        }
+        if limit > 0 && n > limit {
+            if n == limit+1 {
+                fmt.Fprintf(stdout, "  ... and %d more\n", report.Counts[change.Kind]-limit)
+            }
+            continue
+        }
+        fmt.Fprintf(stdout, "  %s  %s\n", change.ProductID, change.Describe())
+    }
+}
+
+// reportChanges compares the crawl just recorded with the previous
+// crawl of the same query, prints a summary and writes the report to path.
+// It returns the report, which is empty when there is nothing to compare.
+// Like the history itself, failures are only logged.
+func (h *crawlHistory) reportChanges(ctx context.Context, path string) changeReport {
+    if h.run == nil {
+        return changeReport{}
+    }
+    run, err := h.db.RunInfo(ctx, h.run.ID)
+    if err != nil {
+        log.Printf("Warning: cannot compare with the previous crawl: %v", err)
+        return changeReport{}
+    }
+    report, ok, err := changesSincePrevious(ctx, h.db, run)
+    if err != nil {
+        log.Printf("Warning: cannot compare with the previous crawl: %v", err)
+        return changeReport{}
+    }
+    if !ok {
+        fmt.Fprintln(stdout, "No earlier crawl of this query to compare with.")
+        return changeReport{}
+    }
+    printChangeSummary(report, 10)
+    if path == "" {
+        return report
+    }
+    if err := writeChangeReport(path, report); err != nil {
+        log.Printf("Warning: cannot write the change report: %v", err)
+        return report
+    }
+    fmt.Fprintf(stdout, "Wrote the change report to %s\n", path)
+    return report
+}
+
+// runChanges reports what changed between two recorded crawls, or between
+// two crawl output files.
+func runChanges(args []string) {
+    fs := flag.NewFlagSet("changes", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: theWhiskyExchangeCrawler changes [flags]")
+        fs.PrintDefaults()
+    }
+    runID := fs.Int64("run", 0, "the later run (default the latest finished run)")
+    sinceID := fs.Int64("since", 0, "the earlier run (default the previous finished run of the same query)")
+    beforePath := fs.String("before", "", "compare crawl output files instead: the earlier .json, .ndjson or .jsonl output")
+    afterPath := fs.String("after", "", "the later crawl output file, with -before")
+    jsonPath := fs.String("json", "", "also write the report to this JSON file")
+    all := fs.Bool("all", false, "list every change instead of the first 10 of each kind")
+    configFlags := config.RegisterFlags(fs)
+    fs.Parse(args)
+
+    limit := 10
+    if *all {
+        limit = 0
+    }
+    var report changeReport
+    if *beforePath != "" || *afterPath != "" {
+        if *beforePath == "" || *afterPath == "" || !sink.Readable(*beforePath) || !sink.Readable(*afterPath) {
+            fmt.Fprintln(stderr, "-before and -after must both name .json, .ndjson or .jsonl crawl outputs")
+            os.Exit(exitUsage)
+        }
+        before, err := readSnapshot(*beforePath)
+        if err != nil {
+            log.Fatalf("Error reading %s: %v", *beforePath, err)
+        }
+        after, err := readSnapshot(*afterPath)
+        if err != nil {
+            log.Fatalf("Error reading %s: %v", *afterPath, err)
+        }
+        report = newChangeReport(changeSource{File: *beforePath, Products: len(before)}, changeSource{File: *afterPath, Products: len(after)}, before, after)
+    } else {
+        cfg := loadConfig(configFlags)
+        if cfg.Database.Path == "" {
+            fmt.Fprintln(stderr, "No history database configured (database.path / -db).")
+            os.Exit(exitUsage)
+        }
+        db, err := store.Open(cfg.Database.Path)
+        if err != nil {
+            log.Fatalf("Error opening history database: %v", err)
+        }
+        defer db.Close()
+
+        ctx := context.Background()
+        var to, from store.RunInfo
+        if *runID != 0 {
+            to, err = db.RunInfo(ctx, *runID)
+        } else {
+            var ok bool
+            to, ok, err = db.LatestRun(ctx)
+            if err == nil && !ok {
+                err = fmt.Errorf("no crawl has finished yet")
+            }
+        }
+        if err == nil && *sinceID != 0 {
+            from, err = db.RunInfo(ctx, *sinceID)
+        } else if err == nil {
+            var ok bool
+            from, ok, err = db.PreviousRun(ctx, to)
+            if err == nil && !ok {
+                err = fmt.Errorf("run %d is the first finished crawl of its query", to.ID)
+            }
+        }
+        if err == nil {
+            report, err = compareRuns(ctx, db, from, to)
+        }
+        if err != nil {
+            fmt.Fprintln(stderr, "Cannot compare runs:", err)
+            db.Close()
+            os.Exit(exitUsage)
+        }
+    }
+
+    printChangeSummary(report, limit)
+    if *jsonPath != "" {
+        if err := writeChangeReport(*jsonPath, report); err != nil {
+            log.Fatalf("Error writing %s: %v", *jsonPath, err)
+        }
+        fmt.Fprintf(stdout, "Wrote %s\n", *jsonPath)
+    }
+}
+
+// readSnapshot reads a crawl output keyed by ProductID.
+func readSnapshot(path string) (map[string]twe.Product, error) {
+    products := make(map[string]twe.Product)
+    err := sink.ReadJSON(path, func(p twe.Product) error {
+        products[p.ProductID] = p
+        return nil
+    })
+    return products, err
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "time"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// A checkpoint directory holds the progress of one crawl:
+//
+//	checkpoint.json   the queries, the last completed page and how much of
+//	                  products.ndjson is valid
+//	products.ndjson   every product collected so far, one per line. In a
+//	                  multi-query crawl a product is saved once for every
+//	                  query that listed it.
+//
+// The products of a page are appended and synced before checkpoint.json is
+// replaced, so after a crash the state always describes complete pages.
+//
+// The directory is the user's to choose and may hold other files, so only
+// these files are ever deleted, and the directory itself only when the
+// crawler created it and nothing else is left in it.
+const (
+    checkpointStateFile    = "checkpoint.json"
+    checkpointProductsFile = "products.ndjson"
+)
+
+// checkpointFiles are the files a checkpoint owns in its directory.
+var checkpointFiles = []string{checkpointStateFile, checkpointStateFile + ".tmp", checkpointProductsFile}
+
+// checkpointState is the content of checkpoint.json.
+type checkpointState struct {
+    Query        string    `json:"query"`
+    StartedAt    time.Time `json:"startedAt"`
+    UpdatedAt    time.Time `json:"updatedAt"`
+    LastPage     int       `json:"lastPage"`
+    TotalPages   int       `json:"totalPages"`
+    Products     int       `json:"products"`
+    ProductsSize int64     `json:"productsSize"` // valid length of products.ndjson
+    HistoryRun   int64     `json:"historyRun,omitempty"`
+    // CreatedDir is set when the crawler created the directory, so removing
+    // the checkpoint may remove the directory too.
+    CreatedDir bool `json:"createdDir,omitempty"`
+
+    // Queries is set instead of Query for a multi-query crawl, and
+    // QueryIndex is the query LastPage and TotalPages belong to.
+    Queries    []checkpointQuery `json:"queries,omitempty"`
+    QueryIndex int               `json:"queryIndex,omitempty"`
+}
+
+// checkpointQuery is one query of a multi-query crawl.
+type checkpointQuery struct {
+    Name  string `json:"name"`
+    Query string `json:"query"`
+}
+
+// crawlCheckpoint saves progress after every page. Like crawlHistory, all
+// methods are no-ops when checkpointing is disabled or has failed, so it
+// never stops the crawl itself.
+type crawlCheckpoint struct {
+    dir      string
+    state    checkpointState
+    products *os.File
+    // pending is set until a fresh checkpoint saves its first page, and
+    // previous is the checkpoint it replaces then, if any.
+    pending  bool
+    previous *checkpointState
+}
+
+// loadCheckpoint reads the checkpoint in dir, returning nil if there is none.
+func loadCheckpoint(dir string) (*checkpointState, error) {
+    if dir == "" {
+        return nil, nil
+    }
+    data, err := os.ReadFile(filepath.Join(dir, checkpointStateFile))
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var state checkpointState
+    if err := json.Unmarshal(data, &state); err != nil {
+        return nil, fmt.Errorf("%s: %w", filepath.Join(dir, checkpointStateFile), err)
+    }
+    return &state, nil
+}
+
+// startCheckpoint prepares a fresh checkpoint in dir. previous, the
+// checkpoint already there, is only discarded once the first page is saved,
+// so a crawl that fails before fetching anything leaves it resumable.
+func startCheckpoint(dir string, queries []crawlQuery, previous *checkpointState) *crawlCheckpoint {
+    c := &crawlCheckpoint{}
+    if dir == "" {
+        return c
+    }
+    now := time.Now().UTC()
+    c.dir, c.pending, c.previous = dir, true, previous
+    c.state = checkpointState{StartedAt: now, UpdatedAt: now}
+    if previous != nil {
+        c.state.CreatedDir = previous.CreatedDir
+    }
+    if len(queries) == 1 {
+        c.state.Query = queries[0].Query.String()
+    } else {
+        for _, q := range queries {
+            c.state.Queries = append(c.state.Queries, checkpointQuery{Name: q.Name, Query: q.Query.String()})
+        }
+    }
+    return c
+}
+
+// open replaces the previous checkpoint's files with empty ones.
+func (c *crawlCheckpoint) open() {
+    c.pending = false
+    if c.previous != nil {
+        log.Printf("Warning: discarding the checkpoint of an interrupted crawl (page %d of %d); use -resume to continue it instead.", c.previous.LastPage, c.previous.TotalPages)
+    }
+    if _, err := os.Stat(c.dir); errors.Is(err, os.ErrNotExist) {
+        c.state.CreatedDir = true
+    }
+    if err := os.MkdirAll(c.dir, 0o700); err != nil {
+        log.Printf("Warning: checkpointing disabled: %v", err)
+        return
+    }
+    os.Remove(filepath.Join(c.dir, checkpointStateFile))
+    products, err := os.OpenFile(filepath.Join(c.dir, checkpointProductsFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+    if err != nil {
+        log.Printf("Warning: checkpointing disabled: %v", err)
+        return
+    }
+    c.products = products
+}
+
+// resumeCheckpoint reopens the checkpoint in dir described by state and
+// passes each product collected before the interruption to fn.
+func resumeCheckpoint(dir string, state *checkpointState, fn func(twe.Product) error) (*crawlCheckpoint, error) {
+    path := filepath.Join(dir, checkpointProductsFile)
+    products, err := os.OpenFile(path, os.O_RDWR, 0o600)
+    if err != nil {
+        return nil, err
+    }
+    // Drop a page that was only partly appended when the crawl died
+    if err := products.Truncate(state.ProductsSize); err != nil {
+        products.Close()
+        return nil, err
+    }
+
+    count := 0
+    scanner := bufio.NewScanner(products)
+    scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+    for line := 1; scanner.Scan(); line++ {
+        var product twe.Product
+        if err := json.Unmarshal(scanner.Bytes(), &product); err != nil {
+            products.Close()
+            return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+        }
+        if err := fn(product); err != nil {
+            products.Close()
+            return nil, err
+        }
+        count++
+    }
+    if err := scanner.Err(); err != nil {
+        products.Close()
+        return nil, err
+    }
+    if count != state.Products {
+        products.Close()
+        return nil, fmt.Errorf("%s holds %d products, checkpoint expects %d", path, count, state.Products)
+    }
+    if _, err := products.Seek(state.ProductsSize, io.SeekStart); err != nil {
+        products.Close()
+        return nil, err
+    }
+    return &crawlCheckpoint{dir: dir, state: *state, products: products}, nil
+}
+
+// setHistoryRun remembers the history run so a resume continues it.
+func (c *crawlCheckpoint) setHistoryRun(id int64) {
+    c.state.HistoryRun = id
+    if c.products != nil {
+        c.writeState()
+    }
+}
+
+// startQuery records that the crawl moved on to query index, which has no
+// completed pages yet.
+func (c *crawlCheckpoint) startQuery(index int) {
+    if c.state.QueryIndex == index {
+        return
+    }
+    c.state.QueryIndex = index
+    c.state.LastPage = 0
+    c.state.TotalPages = 0
+    if c.products != nil {
+        c.writeState()
+    }
+}
+
+// savePage records a completed page.
+func (c *crawlCheckpoint) savePage(page *twe.Page) {
+    if c.pending {
+        c.open()
+    }
+    if c.products == nil {
+        return
+    }
+    w := bufio.NewWriter(c.products)
+    enc := json.NewEncoder(w)
+    for _, product := range page.Products {
+        if err := enc.Encode(product); err != nil {
+            c.fail(err)
+            return
+        }
+    }
+    if err := w.Flush(); err != nil {
+        c.fail(err)
+        return
+    }
+    if err := c.products.Sync(); err != nil {
+        c.fail(err)
+        return
+    }
+    size, err := c.products.Seek(0, io.SeekCurrent)
+    if err != nil {
+        c.fail(err)
+        return
+    }
+
+    c.state.LastPage = page.Number
+    c.state.TotalPages = page.TotalPages
+    c.state.Products += len(page.Products)
+    c.state.ProductsSize = size
+    c.state.UpdatedAt = time.Now().UTC()
+    c.writeState()
+}
+
+// writeState replaces checkpoint.json atomically.
+func (c *crawlCheckpoint) writeState() {
+    data, err := json.MarshalIndent(c.state, "", "  ")
+    if err != nil {
+        c.fail(err)
+        return
+    }
+    path := filepath.Join(c.dir, checkpointStateFile)
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o600); err != nil {
+        c.fail(err)
+        return
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        c.fail(err)
+    }
+}
+
+func (c *crawlCheckpoint) fail(err error) {
+    log.Printf("Warning: checkpointing disabled: %v", err)
+    c.products.Close()
+    c.products = nil
+}
+
+// saved reports whether there is progress a -resume run could pick up.
+func (c *crawlCheckpoint) saved() bool {
+    return c.products != nil && (c.state.LastPage > 0 || c.state.QueryIndex > 0)
+}
+
+// keptPrevious reports whether the checkpoint this one was to replace is
+// still in place, because no page was saved.
+func (c *crawlCheckpoint) keptPrevious() bool {
+    return c.pending && c.previous != nil
+}
+
+// remove deletes the checkpoint once the crawl's output is safely written.
+func (c *crawlCheckpoint) remove() {
+    if c.dir == "" {
+        return
+    }
+    c.close()
+    c.products = nil
+    for _, name := range checkpointFiles {
+        if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+            log.Printf("Warning: could not remove checkpoint %s: %v", c.dir, err)
+        }
+    }
+    if c.state.CreatedDir {
+        os.Remove(c.dir) // fails, as it should, if anything else is in it
+    }
+}
+
+func (c *crawlCheckpoint) close() {
+    if c.products != nil {
+        c.products.Close()
+    }
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+
+    "theWhiskyExchangeCrawler/internal/twetest"
+    "theWhiskyExchangeCrawler/twe"
+)
+
+func checkpointPage(number, total int, ids ...string) *twe.Page {
+    page := &twe.Page{Number: number, TotalPages: total}
+    for _, id := range ids {
+        page.Products = append(page.Products, twe.Product{ProductID: id, Name: "Whisky " + id})
+    }
+    return page
+}
+
+func TestCheckpointResume(t *testing.T) {
+    tests := []struct {
+        name string
+        // damage changes the checkpoint after two pages were saved, the way
+        // a crash or a stray edit might.
+        damage  func(t *testing.T, dir string, state *checkpointState)
+        want    []string
+        wantErr string
+    }{
+        {name: "complete pages", want: []string{"1", "2", "3"}},
+        {
+            name: "page partly appended when the crawl died",
+            damage: func(t *testing.T, dir string, state *checkpointState) {
+                f, err := os.OpenFile(filepath.Join(dir, checkpointProductsFile), os.O_APPEND|os.O_WRONLY, 0)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                f.WriteString(`{"ProductID":"4","Na`)
+                f.Close()
+            },
+            want: []string{"1", "2", "3"},
+        },
+        {
+            name: "products missing",
+            damage: func(t *testing.T, dir string, state *checkpointState) {
+                state.Products++
+            },
+            wantErr: "holds 3 products, checkpoint expects 4",
+        },
+        {
+            name: "corrupt product",
+            damage: func(t *testing.T, dir string, state *checkpointState) {
+                path := filepath.Join(dir, checkpointProductsFile)
+                data, _ := os.ReadFile(path)
+                os.WriteFile(path, []byte(strings.Replace(string(data), "{", "[", 1)), 0o600)
+            },
+            wantErr: checkpointProductsFile + ":1:",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := filepath.Join(t.TempDir(), "checkpoint")
+            c := startCheckpoint(dir, []crawlQuery{{Query: twe.DefaultQuery()}}, nil)
+            c.setHistoryRun(7)
+            c.savePage(checkpointPage(1, 3, "1", "2"))
+            c.savePage(checkpointPage(2, 3, "3"))
+            if !c.saved() {
+                t.Fatal("saved() = false after two pages")
+            }
+            c.close()
+
+            state, err := loadCheckpoint(dir)
+            if err != nil || state == nil {
+                t.Fatalf("loadCheckpoint = %v, %v", state, err)
+            }
+            if state.LastPage != 2 || state.TotalPages != 3 || state.Products != 3 || state.HistoryRun != 7 {
+                t.Errorf("state = %+v, want page 2 of 3 with 3 products in run 7", state)
+            }
+            if tt.damage != nil {
+                tt.damage(t, dir, state)
+            }
+
+            var got []string
+            resumed, err := resumeCheckpoint(dir, state, func(p twe.Product) error {
+                got = append(got, p.ProductID)
+                return nil
+            })
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("resumeCheckpoint error = %v, want one containing %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("resumeCheckpoint: %v", err)
+            }
+            if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+                t.Errorf("resumed products %v, want %v", got, tt.want)
+            }
+
+            // The resumed checkpoint carries on after the valid products.
+            resumed.savePage(checkpointPage(3, 3, "5"))
+            resumed.close()
+            state, _ = loadCheckpoint(dir)
+            got = nil
+            if _, err := resumeCheckpoint(dir, state, func(p twe.Product) error {
+                got = append(got, p.ProductID)
+                return nil
+            }); err != nil {
+                t.Fatalf("second resumeCheckpoint: %v", err)
+            }
+            if want := append(tt.want, "5"); strings.Join(got, ",") != strings.Join(want, ",") {
+                t.Errorf("after another page: products %v, want %v", got, want)
+            }
+        })
+    }
+}
+
+func TestCheckpointQueries(t *testing.T) {
+    dir := filepath.Join(t.TempDir(), "checkpoint")
+    scotland, _ := twe.ParseQuery("country=Scotland")
+    sherry, _ := twe.ParseQuery("casktype=Sherry")
+    c := startCheckpoint(dir, []crawlQuery{{Name: "scotland", Query: scotland}, {Name: "sherry", Query: sherry}}, nil)
+    defer c.close()
+    c.savePage(checkpointPage(1, 2, "1"))
+    c.startQuery(1)
+
+    state, err := loadCheckpoint(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if state.Query != "" || len(state.Queries) != 2 || state.Queries[1].Name != "sherry" || state.Queries[1].Query != "casktype=Sherry" {
+        t.Errorf("queries = %q %+v", state.Query, state.Queries)
+    }
+    if state.QueryIndex != 1 || state.LastPage != 0 || state.TotalPages != 0 || state.Products != 1 {
+        t.Errorf("state = %+v, want query 1 with no pages and 1 product", state)
+    }
+}
+
+func TestCheckpointDisabled(t *testing.T) {
+    state, err := loadCheckpoint("")
+    if state != nil || err != nil {
+        t.Fatalf("loadCheckpoint(\"\") = %v, %v", state, err)
+    }
+    c := startCheckpoint("", nil, nil)
+    c.setHistoryRun(1)
+    c.savePage(checkpointPage(1, 1, "1"))
+    if c.saved() {
+        t.Error("a disabled checkpoint reports saved progress")
+    }
+    c.remove()
+}
+
+func TestCheckpointRemove(t *testing.T) {
+    tests := []struct {
+        name string
+        // existing creates the directory, and files in it, beforehand.
+        existing []string
+        wantDir  bool
+    }{
+        {name: "directory the crawler created", wantDir: false},
+        {name: "directory that was there", existing: []string{}, wantDir: true},
+        {name: "directory holding other files", existing: []string{"checkpoint.json.bak", "notes.txt"}, wantDir: true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := filepath.Join(t.TempDir(), "checkpoint")
+            if tt.existing != nil {
+                if err := os.Mkdir(dir, 0o700); err != nil {
+                    t.Fatal(err)
+                }
+                for _, name := range tt.existing {
+                    os.WriteFile(filepath.Join(dir, name), []byte("keep"), 0o600)
+                }
+            }
+            c := startCheckpoint(dir, []crawlQuery{{Query: twe.DefaultQuery()}}, nil)
+            c.savePage(checkpointPage(1, 2, "1"))
+            c.close()
+
+            // A resumed crawl removes the checkpoint when it finishes.
+            state, err := loadCheckpoint(dir)
+            if err != nil {
+                t.Fatal(err)
+            }
+            resumed, err := resumeCheckpoint(dir, state, func(twe.Product) error { return nil })
+            if err != nil {
+                t.Fatal(err)
+            }
+            resumed.savePage(checkpointPage(2, 2, "2"))
+            resumed.remove()
+
+            entries, err := os.ReadDir(dir)
+            if !tt.wantDir {
+                if !os.IsNotExist(err) {
+                    t.Errorf("checkpoint directory still there (%v)", err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("checkpoint directory removed: %v", err)
+            }
+            var left []string
+            for _, e := range entries {
+                left = append(left, e.Name())
+            }
+            // ReadDir sorts by name, as existing is.
+            if strings.Join(left, ",") != strings.Join(tt.existing, ",") {
+                t.Errorf("left %q in the directory, want %q", left, tt.existing)
+            }
+        })
+    }
+}
+
+func TestCheckpointKeepsPreviousUntilFirstPage(t *testing.T) {
+    dir := filepath.Join(t.TempDir(), "checkpoint")
+    old := startCheckpoint(dir, []crawlQuery{{Query: twe.DefaultQuery()}}, nil)
+    old.savePage(checkpointPage(1, 3, "1", "2"))
+    old.close()
+    previous, err := loadCheckpoint(dir)
+    if err != nil || previous == nil {
+        t.Fatalf("loadCheckpoint = %v, %v", previous, err)
+    }
+
+    // A crawl that fails before its first page, e.g. while harvesting
+    // facets, leaves the previous checkpoint resumable.
+    c := startCheckpoint(dir, []crawlQuery{{Query: twe.DefaultQuery()}}, previous)
+    c.setHistoryRun(9)
+    if c.saved() || !c.keptPrevious() {
+        t.Errorf("saved() = %v, keptPrevious() = %v before the first page", c.saved(), c.keptPrevious())
+    }
+    c.close()
+    if state, err := loadCheckpoint(dir); err != nil || state == nil || state.Products != 2 || state.HistoryRun != 0 {
+        t.Fatalf("previous checkpoint = %+v, %v; want it untouched", state, err)
+    }
+
+    c = startCheckpoint(dir, []crawlQuery{{Query: twe.DefaultQuery()}}, previous)
+    c.setHistoryRun(9)
+    c.savePage(checkpointPage(1, 3, "7"))
+    c.close()
+    if c.keptPrevious() {
+        t.Error("keptPrevious() after the first page")
+    }
+    state, err := loadCheckpoint(dir)
+    if err != nil || state.Products != 1 || state.HistoryRun != 9 || !state.CreatedDir {
+        t.Fatalf("checkpoint = %+v, %v; want the new crawl's, remembering the directory is the crawler's", state, err)
+    }
+}
+
+// TestResume interrupts a crawl with a Cloudflare challenge on page 3 and
+// resumes it against a server that has recovered.
+func TestResume(t *testing.T) {
+    if testing.Short() {
+        t.Skip("runs the crawler twice")
+    }
+    dir := t.TempDir()
+    blocked := twetest.NewServer(twetest.Options{TotalPages: 4, PageSize: 5, ChallengePages: []int{3}})
+    defer blocked.Close()
+    healthy := twetest.NewServer(twetest.Options{TotalPages: 4, PageSize: 5})
+    defer healthy.Close()
+    config := "crawl:\n  request_delay: 0s\ndatabase:\n  path: history.db\n"
+    if err := os.WriteFile(filepath.Join(dir, "crawler.yaml"), []byte(config), 0o644); err != nil {
+        t.Fatal(err)
+    }
+
+    if status := runCrawler(t, dir, "interrupted", "-base-url", blocked.URL); status != exitSessionExpired {
+        t.Fatalf("interrupted crawl exited %d, want %d", status, exitSessionExpired)
+    }
+    state, err := loadCheckpoint(filepath.Join(dir, ".crawl-checkpoint"))
+    if err != nil || state == nil || state.LastPage != 2 || state.Products != 10 {
+        t.Fatalf("checkpoint = %+v, %v; want 10 products up to page 2", state, err)
+    }
+    if _, err := os.Stat(filepath.Join(dir, "output.json")); !os.IsNotExist(err) {
+        t.Errorf("output.json written by a failed crawl (%v)", err)
+    }
+
+    if status := runCrawler(t, dir, "resumed", "-base-url", healthy.URL, "-resume"); status != 0 {
+        t.Fatalf("resumed crawl exited %d", status)
+    }
+    var products []twe.Product
+    readJSON(t, filepath.Join(dir, "output.json"), &products)
+    seen := make(map[string]bool)
+    for _, p := range products {
+        if seen[p.ProductID] {
+            t.Errorf("product %s written twice", p.ProductID)
+        }
+        seen[p.ProductID] = true
+    }
+    for id := 1; id <= 20; id++ {
+        if !seen[strconv.Itoa(id)] {
+            t.Errorf("product %d missing from the output", id)
+        }
+    }
+    if _, err := os.Stat(filepath.Join(dir, ".crawl-checkpoint")); !os.IsNotExist(err) {
+        t.Errorf("checkpoint left behind after the crawl finished (%v)", err)
+    }
+
+    log, _ := os.ReadFile(filepath.Join(dir, "resumed.log"))
+    if !strings.Contains(string(log), "Continuing history run 1") {
+        t.Errorf("the resumed crawl did not continue history run 1:\n%s", log)
+    }
+}
@@ -0,0 +1,543 @@
+// Package config loads the crawler's settings from, in increasing order of
+// precedence: built-in defaults, a YAML config file, a YAML secrets file,
+// environment variables and command line flags.
+package config
+
+import (
+    "bytes"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+
+    "gopkg.in/yaml.v3"
+)
+
+const (
+    // DefaultConfigFile and DefaultSecretsFile are read from the working
+    // directory when no path is given and they exist.
+    DefaultConfigFile  = "crawler.yaml"
+    DefaultSecretsFile = "secrets.yaml"
+
+    // placeholderAPIToken is the value the token used to be hardcoded as.
+    placeholderAPIToken = "tweApiToken"
+)
+
+// Config is the complete crawler configuration.
+type Config struct {
+    TWE      TWEConfig      `yaml:"twe"`
+    Airtable AirtableConfig `yaml:"airtable"`
+    Database DatabaseConfig `yaml:"database"`
+    Crawl    CrawlConfig    `yaml:"crawl"`
+    Output   OutputConfig   `yaml:"output"`
+    Alerts   AlertsConfig   `yaml:"alerts"`
+    Notify   NotifyConfig   `yaml:"notify"`
+    Daemon   DaemonConfig   `yaml:"daemon"`
+}
+
+// TWEConfig configures the twe API client.
+type TWEConfig struct {
+    BaseURL          string `yaml:"base_url"`
+    APIToken         Secret `yaml:"api_token"`
+    Cookies          Secret `yaml:"cookies"`           // raw Cookie header copied from a browser session
+    CustomerSettings Secret `yaml:"customer_settings"` // CurrentCustomerSettings blob from the same session
+    UserAgent        string `yaml:"user_agent"`
+    // MaxRetries bounds the retries of one page after rate limiting or
+    // server errors. Rejected sessions are never retried.
+    MaxRetries int `yaml:"max_retries"`
+}
+
+// AirtableConfig configures the Airtable upload. Leaving TableURL empty
+// disables the upload.
+type AirtableConfig struct {
+    TableURL string `yaml:"table_url"`
+    Token    Secret `yaml:"token"`
+
+    // MarkDelisted flags rows whose SKU was not returned by a catalogue-wide
+    // crawl as inactive and out of stock.
+    MarkDelisted bool `yaml:"mark_delisted"`
+    // MaxDelistedFraction aborts the delisting when more than this share of
+    // the active rows would be flagged, which usually means a broken crawl.
+    MaxDelistedFraction float64 `yaml:"max_delisted_fraction"`
+    // DelistedDateField, when set, names a date column that receives the day
+    // a product disappeared and is cleared when it comes back.
+    DelistedDateField string `yaml:"delisted_date_field"`
+
+    // DeadLetterFile collects records Airtable rejected or that failed after
+    // all retries, for the retry-failed command to replay.
+    DeadLetterFile    string  `yaml:"dead_letter_file"`
+    MaxRetries        int     `yaml:"max_retries"`
+    RequestsPerSecond float64 `yaml:"requests_per_second"`
+    // RateLimitPause is how long to back off after a 429 that carries no
+    // Retry-After header. Airtable locks clients out for 30 seconds.
+    RateLimitPause time.Duration `yaml:"rate_limit_pause"`
+}
+
+// DatabaseConfig configures the SQLite price and stock history. Leaving Path
+// empty disables it.
+type DatabaseConfig struct {
+    Path string `yaml:"path"`
+}
+
+// CrawlConfig controls how a crawl runs.
+type CrawlConfig struct {
+    // CheckpointDir keeps the progress of the running crawl so an
+    // interrupted one can be continued with -resume. Empty disables it.
+    CheckpointDir string `yaml:"checkpoint_dir"`
+    // Parallelism is how many pages are fetched at once once the first page
+    // has revealed the page count.
+    Parallelism int `yaml:"parallelism"`
+    // RequestDelay is the minimum gap between two requests to the site,
+    // however many are in flight.
+    RequestDelay time.Duration `yaml:"request_delay"`
+    // Details visits every product's page after its listing page and adds
+    // tasting notes, facts, rating and GTIN to the product.
+    Details bool `yaml:"details"`
+    // DetailParallelism is how many product pages are fetched at once.
+    DetailParallelism int `yaml:"detail_parallelism"`
+    // Facets are filters (Country, Region, CaskType, ...) whose values are
+    // added to each product by listing the query once per value.
+    Facets []string `yaml:"facets"`
+    // Queries are crawled one after another in a single run, instead of the
+    // catalogue-wide search. Products are written once, annotated with the
+    // names of every query that listed them.
+    Queries []QueryConfig `yaml:"queries"`
+}
+
+// QueryConfig is one query of a multi-query crawl.
+type QueryConfig struct {
+    // Name annotates the products the query lists. It defaults to Query.
+    Name string `yaml:"name"`
+    // Query uses the -query syntax, e.g. "categoryids=40 onoffer".
+    Query string `yaml:"query"`
+}
+
+// OutputConfig chooses where collected products are written.
+type OutputConfig struct {
+    // Path is one or more comma-separated files, each written in the format
+    // its extension names: .json, .ndjson/.jsonl, .csv, .parquet or .xlsx.
+    Path string `yaml:"path"`
+    // Columns picks and orders the columns of CSV, Parquet and XLSX output,
+    // by Airtable field name. Empty means every field.
+    Columns []string `yaml:"columns"`
+    // Changes is the JSON file each crawl writes its changes since the
+    // previous crawl of the same query to. Empty disables it.
+    Changes string `yaml:"changes"`
+}
+
+// AlertsConfig configures the watchlist checked after each crawl. Leaving
+// Watchlist empty disables it.
+type AlertsConfig struct {
+    // Watchlist is a YAML file of watched products and alert conditions,
+    // see watchlist.example.yaml.
+    Watchlist string `yaml:"watchlist"`
+    // Output is the JSON file the fired alerts are written to. Empty only
+    // prints them.
+    Output string `yaml:"output"`
+}
+
+// NotifyConfig configures where each crawl's changes and alerts are sent.
+// Nothing is sent without a channel, or when a crawl has nothing to report.
+type NotifyConfig struct {
+    // Changes includes the changes since the previous crawl; without it
+    // only watchlist alerts are sent.
+    Changes bool `yaml:"changes"`
+    // ChangeLimit is how many changes of each kind chat messages and
+    // emails list. Webhooks always receive every change.
+    ChangeLimit int `yaml:"change_limit"`
+    // MaxRetries and RetryDelay govern retries after network errors, 429s,
+    // 5xx answers and temporary SMTP failures. The delay doubles for each
+    // retry.
+    MaxRetries int           `yaml:"max_retries"`
+    RetryDelay time.Duration `yaml:"retry_delay"`
+
+    Webhooks []WebhookConfig `yaml:"webhooks"`
+    Chat     []ChatConfig    `yaml:"chat"`
+    Email    EmailConfig     `yaml:"email"`
+}
+
+// WebhookConfig is a generic HTTP endpoint that receives each digest as
+// JSON.
+type WebhookConfig struct {
+    URL Secret `yaml:"url"`
+    // Secret signs each delivery with HMAC-SHA256. Empty sends unsigned.
+    Secret Secret `yaml:"secret"`
+}
+
+// ChatConfig is a Slack- or Discord-style incoming webhook.
+type ChatConfig struct {
+    // URL embeds the webhook's credentials.
+    URL Secret `yaml:"url"`
+    // Format is "slack" (the default) or "discord".
+    Format string `yaml:"format"`
+    // Template is a Go text/template file for the message. Empty uses the
+    // built-in one.
+    Template string `yaml:"template"`
+}
+
+// EmailConfig sends each digest as an email. Leaving Addr empty disables
+// it.
+type EmailConfig struct {
+    // Addr is the SMTP server's host:port. STARTTLS is used when offered.
+    Addr string `yaml:"addr"`
+    // Username and Password authenticate with PLAIN, which Go only allows
+    // over TLS or to localhost. Empty sends without authenticating.
+    Username string   `yaml:"username"`
+    Password Secret   `yaml:"password"`
+    From     string   `yaml:"from"`
+    To       []string `yaml:"to"`
+    // Subject is a Go text/template; Template is a text/template file for
+    // the body. Empty uses the built-in ones.
+    Subject  string `yaml:"subject"`
+    Template string `yaml:"template"`
+}
+
+// DaemonConfig configures the scheduled crawls of the daemon command.
+type DaemonConfig struct {
+    // StateFile records each job's last run, so a restarted daemon knows
+    // what it missed.
+    StateFile string `yaml:"state_file"`
+    // Jitter delays every run by a random duration up to it, so scheduled
+    // crawls do not hit the site on the dot.
+    Jitter time.Duration `yaml:"jitter"`
+    // CatchUp runs a job once at start-up if the daemon was down when it
+    // was last due.
+    CatchUp bool        `yaml:"catch_up"`
+    Jobs    []JobConfig `yaml:"jobs"`
+}
+
+// JobConfig is one scheduled crawl.
+type JobConfig struct {
+    // Name identifies the job in logs and the state file, and names its
+    // checkpoint directory.
+    Name string `yaml:"name"`
+    // Schedule is a cron expression in local time, e.g. "0 2 * * *", or
+    // @hourly, @daily, @weekly or "@every 15m".
+    Schedule string `yaml:"schedule"`
+    // Args are the crawl's flags, e.g. ["-query", "onoffer", "-output",
+    // "offers.json"].
+    Args []string `yaml:"args"`
+    // Jitter overrides the daemon's jitter when set.
+    Jitter time.Duration `yaml:"jitter"`
+}
+
+// Paths returns the output files listed in Path.
+func (o OutputConfig) Paths() []string {
+    var paths []string
+    for _, path := range strings.Split(o.Path, ",") {
+        if path = strings.TrimSpace(path); path != "" {
+            paths = append(paths, path)
+        }
+    }
+    return paths
+}
+
+// Secret is a string that never prints its value. Use Reveal to get it.
+type Secret string
+
+const redacted = "[REDACTED]"
+
+// Reveal returns the secret value.
+func (s Secret) Reveal() string { return string(s) }
+
+func (s Secret) String() string {
+    if s == "" {
+        return ""
+    }
+    return redacted
+}
+
+func (s Secret) GoString() string { return `"` + s.String() + `"` }
+
+// MarshalYAML keeps secrets out of dumped configuration.
+func (s Secret) MarshalYAML() (interface{}, error) { return s.String(), nil }
+
+// Default returns the built-in defaults. It contains no credentials.
+func Default() *Config {
+    return &Config{
+        TWE: TWEConfig{
+            BaseURL:    "https://www.thewhiskyexchange.com",
+            MaxRetries: 4,
+        },
+        Airtable: AirtableConfig{
+            MarkDelisted:        true,
+            MaxDelistedFraction: 0.1,
+            DeadLetterFile:      "airtable-failed.jsonl",
+            MaxRetries:          5,
+            RequestsPerSecond:   5,
+            RateLimitPause:      30 * time.Second,
+        },
+        Database: DatabaseConfig{
+            Path: "history.db",
+        },
+        Crawl: CrawlConfig{
+            CheckpointDir:     ".crawl-checkpoint",
+            Parallelism:       3,
+            RequestDelay:      500 * time.Millisecond,
+            DetailParallelism: 4,
+        },
+        Output: OutputConfig{
+            Path:    "output.json",
+            Changes: "changes.json",
+        },
+        Alerts: AlertsConfig{
+            Output: "alerts.json",
+        },
+        Notify: NotifyConfig{
+            Changes:     true,
+            ChangeLimit: 10,
+            MaxRetries:  3,
+            RetryDelay:  5 * time.Second,
+        },
+        Daemon: DaemonConfig{
+            StateFile: "daemon-state.json",
+            Jitter:    time.Minute,
+            CatchUp:   true,
+        },
+    }
+}
+
+// setting ties one configuration value to its flag and environment variable.
+type setting struct {
+    flag  string
+    env   string
+    usage string
+    field func(*Config) *string
+}
+
+var settings = []setting{
+    {"base-url", "TWE_BASE_URL", "site root to crawl", func(c *Config) *string { return &c.TWE.BaseURL }},
+    {"api-token", "TWE_API_TOKEN", "API token sent with each request (prefer the env var or secrets file)", func(c *Config) *string { return (*string)(&c.TWE.APIToken) }},
+    {"cookies", "TWE_COOKIES", "Cookie header from a browser session (prefer the env var or secrets file)", func(c *Config) *string { return (*string)(&c.TWE.Cookies) }},
+    {"customer-settings", "TWE_CUSTOMER_SETTINGS", "CurrentCustomerSettings value from a browser session", func(c *Config) *string { return (*string)(&c.TWE.CustomerSettings) }},
+    {"user-agent", "TWE_USER_AGENT", "User-Agent header to send", func(c *Config) *string { return &c.TWE.UserAgent }},
+    {"airtable-url", "AIRTABLE_TABLE_URL", "Airtable table API URL (empty disables the upload)", func(c *Config) *string { return &c.Airtable.TableURL }},
+    {"db", "TWE_DB", "SQLite price and stock history database (empty disables it)", func(c *Config) *string { return &c.Database.Path }},
+    {"output", "TWE_OUTPUT", "comma-separated files the products are written to (.json, .ndjson/.jsonl, .csv, .parquet, .xlsx)", func(c *Config) *string { return &c.Output.Path }},
+    {"changes", "TWE_CHANGES", "JSON file the changes since the previous crawl are written to (empty disables it)", func(c *Config) *string { return &c.Output.Changes }},
+    {"watchlist", "TWE_WATCHLIST", "watchlist of products and alert conditions checked after each crawl (empty disables it)", func(c *Config) *string { return &c.Alerts.Watchlist }},
+    {"alerts", "TWE_ALERTS", "JSON file the alerts fired by the watchlist are written to (empty only prints them)", func(c *Config) *string { return &c.Alerts.Output }},
+    {"checkpoint-dir", "TWE_CHECKPOINT_DIR", "directory keeping crawl progress for -resume (empty disables it)", func(c *Config) *string { return &c.Crawl.CheckpointDir }},
+    {"smtp-password", "TWE_SMTP_PASSWORD", "password for notify.email (prefer the env var or secrets file)", func(c *Config) *string { return (*string)(&c.Notify.Email.Password) }},
+    {"airtable-token", "AIRTABLE_TOKEN", "Airtable personal access token (prefer the env var or secrets file)", func(c *Config) *string { return (*string)(&c.Airtable.Token) }},
+}
+
+// Flags holds the command line layer until it is applied.
+type Flags struct {
+    fs         *flag.FlagSet
+    values     map[string]*string
+    ConfigFile string
+    SecretFile string
+}
+
+// RegisterFlags adds -config, -secrets and one flag per setting to fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+    f := &Flags{fs: fs, values: make(map[string]*string)}
+    fs.StringVar(&f.ConfigFile, "config", "", "YAML config file (default $TWE_CONFIG or ./"+DefaultConfigFile+")")
+    fs.StringVar(&f.SecretFile, "secrets", "", "YAML secrets file (default $TWE_SECRETS or ./"+DefaultSecretsFile+")")
+    for _, s := range settings {
+        f.values[s.flag] = fs.String(s.flag, "", s.usage+" [$"+s.env+"]")
+    }
+    return f
+}
+
+// Args returns the -config, -secrets and setting flags given on the
+// command line, so a child process can be started with the same settings.
+func (f *Flags) Args() []string {
+    var args []string
+    f.fs.Visit(func(fl *flag.Flag) {
+        if _, ok := f.values[fl.Name]; ok || fl.Name == "config" || fl.Name == "secrets" {
+            args = append(args, "-"+fl.Name+"="+fl.Value.String())
+        }
+    })
+    return args
+}
+
+// Load builds the configuration from every layer. It must be called after
+// the flag set has been parsed.
+func (f *Flags) Load() (*Config, error) {
+    cfg := Default()
+
+    configFile := firstNonEmpty(f.ConfigFile, os.Getenv("TWE_CONFIG"))
+    if err := loadFile(cfg, configFile, DefaultConfigFile, false); err != nil {
+        return nil, err
+    }
+    secretFile := firstNonEmpty(f.SecretFile, os.Getenv("TWE_SECRETS"))
+    if err := loadFile(cfg, secretFile, DefaultSecretsFile, true); err != nil {
+        return nil, err
+    }
+
+    for _, s := range settings {
+        if value, ok := os.LookupEnv(s.env); ok {
+            *s.field(cfg) = value
+        }
+    }
+
+    f.fs.Visit(func(fl *flag.Flag) {
+        if value, ok := f.values[fl.Name]; ok {
+            for _, s := range settings {
+                if s.flag == fl.Name {
+                    *s.field(cfg) = *value
+                }
+            }
+        }
+    })
+
+    return cfg, cfg.Validate()
+}
+
+// loadFile merges a YAML file into cfg. An explicitly named file must exist;
+// the default file is skipped silently when missing.
+func loadFile(cfg *Config, path, defaultPath string, secret bool) error {
+    explicit := path != ""
+    if !explicit {
+        path = defaultPath
+    }
+
+    info, err := os.Stat(path)
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) && !explicit {
+            return nil
+        }
+        return err
+    }
+    if secret && info.Mode().Perm()&0o077 != 0 {
+        log.Printf("Warning: secrets file %s is readable by other users (mode %v); consider chmod 600", path, info.Mode().Perm())
+    }
+
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    dec := yaml.NewDecoder(bytes.NewReader(data))
+    dec.KnownFields(true)
+    if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+        return fmt.Errorf("parsing %s: %w", path, err)
+    }
+    return nil
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+    var problems []string
+
+    if u, err := url.Parse(c.TWE.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        problems = append(problems, fmt.Sprintf("twe.base_url %q is not an http(s) URL", c.TWE.BaseURL))
+    }
+    if c.Airtable.TableURL != "" {
+        if u, err := url.Parse(c.Airtable.TableURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            problems = append(problems, fmt.Sprintf("airtable.table_url %q is not an http(s) URL", c.Airtable.TableURL))
+        }
+        if c.Airtable.Token == "" {
+            problems = append(problems, "airtable.token is required when airtable.table_url is set")
+        }
+    }
+    if c.TWE.MaxRetries < 0 {
+        problems = append(problems, "twe.max_retries must not be negative")
+    }
+    if len(c.Output.Paths()) == 0 {
+        problems = append(problems, "output.path must be set")
+    }
+    if c.Crawl.Parallelism < 1 {
+        problems = append(problems, "crawl.parallelism must be at least 1")
+    }
+    if c.Crawl.DetailParallelism < 1 {
+        problems = append(problems, "crawl.detail_parallelism must be at least 1")
+    }
+    if c.Crawl.RequestDelay < 0 {
+        problems = append(problems, "crawl.request_delay must not be negative")
+    }
+    if c.Airtable.MaxRetries < 0 {
+        problems = append(problems, "airtable.max_retries must not be negative")
+    }
+    if c.Airtable.RateLimitPause <= 0 {
+        problems = append(problems, "airtable.rate_limit_pause must be positive")
+    }
+    if c.Airtable.RequestsPerSecond <= 0 {
+        problems = append(problems, "airtable.requests_per_second must be positive")
+    }
+    if c.Airtable.MaxDelistedFraction < 0 || c.Airtable.MaxDelistedFraction > 1 {
+        problems = append(problems, fmt.Sprintf("airtable.max_delisted_fraction %v must be between 0 and 1", c.Airtable.MaxDelistedFraction))
+    }
+    problems = append(problems, c.Notify.problems()...)
+
+    if len(problems) > 0 {
+        return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+    }
+    return nil
+}
+
+func (n *NotifyConfig) problems() []string {
+    var problems []string
+    httpURL := func(s string) bool {
+        u, err := url.Parse(s)
+        return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+    }
+    for i, w := range n.Webhooks {
+        if !httpURL(w.URL.Reveal()) {
+            problems = append(problems, fmt.Sprintf("notify.webhooks[%d].url is not an http(s) URL", i))
+        }
+    }
+    for i, c := range n.Chat {
+        if !httpURL(c.URL.Reveal()) {
+            problems = append(problems, fmt.Sprintf("notify.chat[%d].url is not an http(s) URL", i))
+        }
+        if c.Format != "" && c.Format != "slack" && c.Format != "discord" {
+            problems = append(problems, fmt.Sprintf("notify.chat[%d].format %q must be slack or discord", i, c.Format))
+        }
+    }
+    if n.Email.Addr != "" {
+        if _, port, err := net.SplitHostPort(n.Email.Addr); err != nil || port == "" {
+            problems = append(problems, fmt.Sprintf("notify.email.addr %q must be host:port", n.Email.Addr))
+        }
+        if n.Email.From == "" || len(n.Email.To) == 0 {
+            problems = append(problems, "notify.email needs from and to")
+        }
+    }
+    if n.ChangeLimit < 0 {
+        problems = append(problems, "notify.change_limit must not be negative")
+    }
+    if n.MaxRetries < 0 {
+        problems = append(problems, "notify.max_retries must not be negative")
+    }
+    if n.RetryDelay <= 0 {
+        problems = append(problems, "notify.retry_delay must be positive")
+    }
+    return problems
+}
+
+// RequireAPIToken checks the settings only needed by commands that talk to
+// the API, so offline commands work without credentials.
+func (c *Config) RequireAPIToken() error {
+    switch c.TWE.APIToken {
+    case "":
+        return errors.New("twe.api_token is not set (use the secrets file or $TWE_API_TOKEN)")
+    case placeholderAPIToken:
+        return errors.New("twe.api_token is still the placeholder value")
+    }
+    return nil
+}
+
+// Dump renders the effective configuration as YAML with secrets redacted.
+func (c *Config) Dump() string {
+    out, err := yaml.Marshal(c)
+    if err != nil {
+        return err.Error()
+    }
+    return string(out)
+}
+
+func firstNonEmpty(values ...string) string {
+    for _, v := range values {
+        if v != "" {
+            return v
+        }
+    }
+    return ""
+}
@@ -0,0 +1,310 @@
+package config
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// isolate runs the test in an empty directory with none of the crawler's
+// environment variables set.
+func isolate(t *testing.T) string {
+    t.Helper()
+    dir := t.TempDir()
+    t.Chdir(dir)
+    for _, env := range []string{"TWE_CONFIG", "TWE_SECRETS"} {
+        t.Setenv(env, "")
+        os.Unsetenv(env)
+    }
+    for _, s := range settings {
+        t.Setenv(s.env, "")
+        os.Unsetenv(s.env)
+    }
+    return dir
+}
+
+func load(t *testing.T, args ...string) (*Config, error) {
+    t.Helper()
+    fs := flag.NewFlagSet("test", flag.ContinueOnError)
+    flags := RegisterFlags(fs)
+    if err := fs.Parse(args); err != nil {
+        t.Fatal(err)
+    }
+    return flags.Load()
+}
+
+func TestLoadPrecedence(t *testing.T) {
+    const configFile = "twe:\n  base_url: https://file.example\ndatabase:\n  path: file.db\noutput:\n  path: file.json\n"
+    // values are the settings compared; the rest must keep their defaults.
+    type values struct{ BaseURL, APIToken, DB, Output string }
+    tests := []struct {
+        name    string
+        config  string // crawler.yaml
+        secrets string // secrets.yaml
+        env     map[string]string
+        args    []string
+        want    values
+    }{
+        {
+            name: "defaults",
+            want: values{"https://www.thewhiskyexchange.com", "", "history.db", "output.json"},
+        },
+        {
+            name:   "file over defaults",
+            config: "twe:\n  base_url: https://file.example\n",
+            want:   values{"https://file.example", "", "history.db", "output.json"},
+        },
+        {
+            name:    "secrets file over config file",
+            config:  "twe:\n  api_token: from-config\n",
+            secrets: "twe:\n  api_token: from-secrets\n",
+            want:    values{"https://www.thewhiskyexchange.com", "from-secrets", "history.db", "output.json"},
+        },
+        {
+            name:    "env over files",
+            config:  configFile,
+            secrets: "twe:\n  api_token: from-secrets\n",
+            env:     map[string]string{"TWE_BASE_URL": "https://env.example", "TWE_API_TOKEN": "from-env"},
+            want:    values{"https://env.example", "from-env", "file.db", "file.json"},
+        },
+        {
+            name:   "flags over env",
+            config: configFile,
+            env:    map[string]string{"TWE_BASE_URL": "https://env.example", "TWE_DB": "env.db"},
+            args:   []string{"-db", "flag.db", "-api-token", "from-flag"},
+            want:   values{"https://env.example", "from-flag", "flag.db", "file.json"},
+        },
+        {
+            name:   "empty env value overrides the file",
+            config: configFile,
+            env:    map[string]string{"TWE_DB": ""},
+            want:   values{"https://file.example", "", "", "file.json"},
+        },
+        {
+            name:   "empty flag overrides the env",
+            config: configFile,
+            env:    map[string]string{"TWE_DB": "env.db"},
+            args:   []string{"-db="},
+            want:   values{"https://file.example", "", "", "file.json"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := isolate(t)
+            if tt.config != "" {
+                os.WriteFile(filepath.Join(dir, DefaultConfigFile), []byte(tt.config), 0o644)
+            }
+            if tt.secrets != "" {
+                os.WriteFile(filepath.Join(dir, DefaultSecretsFile), []byte(tt.secrets), 0o600)
+            }
+            for k, v := range tt.env {
+                t.Setenv(k, v)
+            }
+            cfg, err := load(t, tt.args...)
+            if err != nil {
+                t.Fatalf("Load: %v", err)
+            }
+            got := values{cfg.TWE.BaseURL, cfg.TWE.APIToken.Reveal(), cfg.Database.Path, cfg.Output.Path}
+            if got != tt.want {
+                t.Errorf("Load = %+v, want %+v", got, tt.want)
+            }
+            if cfg.Crawl.Parallelism != Default().Crawl.Parallelism {
+                t.Errorf("crawl.parallelism = %d, want the default", cfg.Crawl.Parallelism)
+            }
+        })
+    }
+}
+
+func TestLoadFiles(t *testing.T) {
+    tests := []struct {
+        name    string
+        args    []string
+        env     map[string]string
+        wantErr string
+        wantURL string
+    }{
+        {name: "named by flag", args: []string{"-config", "other.yaml"}, wantURL: "https://other.example"},
+        {name: "named by env", env: map[string]string{"TWE_CONFIG": "other.yaml"}, wantURL: "https://other.example"},
+        {name: "flag over env", args: []string{"-config", "other.yaml"}, env: map[string]string{"TWE_CONFIG": "missing.yaml"}, wantURL: "https://other.example"},
+        {name: "named file missing", args: []string{"-config", "missing.yaml"}, wantErr: "missing.yaml"},
+        {name: "named secrets file missing", args: []string{"-secrets", "missing.yaml"}, wantErr: "missing.yaml"},
+        {name: "unknown key", args: []string{"-config", "typo.yaml"}, wantErr: "parsing typo.yaml: yaml: unmarshal errors:\n  line 2: field base_ur not found"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := isolate(t)
+            os.WriteFile(filepath.Join(dir, "other.yaml"), []byte("twe:\n  base_url: https://other.example\n"), 0o644)
+            os.WriteFile(filepath.Join(dir, "typo.yaml"), []byte("twe:\n  base_ur: https://other.example\n"), 0o644)
+            for k, v := range tt.env {
+                t.Setenv(k, v)
+            }
+            cfg, err := load(t, tt.args...)
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("Load error = %v, want one containing %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("Load: %v", err)
+            }
+            if cfg.TWE.BaseURL != tt.wantURL {
+                t.Errorf("twe.base_url = %q, want %q", cfg.TWE.BaseURL, tt.wantURL)
+            }
+        })
+    }
+}
+
+func TestLoadExamples(t *testing.T) {
+    root, err := filepath.Abs("..")
+    if err != nil {
+        t.Fatal(err)
+    }
+    dir := isolate(t)
+    // The secrets file is copied so it can be private, as the loader wants.
+    secrets, err := os.ReadFile(filepath.Join(root, "secrets.example.yaml"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    os.WriteFile(filepath.Join(dir, DefaultSecretsFile), secrets, 0o600)
+    if _, err := load(t, "-config", filepath.Join(root, "crawler.example.yaml")); err != nil {
+        t.Fatalf("Load: %v", err)
+    }
+}
+
+func TestValidate(t *testing.T) {
+    tests := []struct {
+        name    string
+        edit    func(c *Config)
+        wantErr []string
+    }{
+        {name: "defaults", edit: func(c *Config) {}},
+        {name: "base url", edit: func(c *Config) { c.TWE.BaseURL = "www.thewhiskyexchange.com" }, wantErr: []string{`twe.base_url "www.thewhiskyexchange.com" is not an http(s) URL`}},
+        {
+            name:    "airtable without token",
+            edit:    func(c *Config) { c.Airtable.TableURL = "https://api.airtable.com/v0/app/Products" },
+            wantErr: []string{"airtable.token is required"},
+        },
+        {
+            name:    "airtable url",
+            edit:    func(c *Config) { c.Airtable.TableURL, c.Airtable.Token = "api.airtable.com", "token" },
+            wantErr: []string{"airtable.table_url"},
+        },
+        {name: "no output", edit: func(c *Config) { c.Output.Path = " , " }, wantErr: []string{"output.path must be set"}},
+        {
+            name: "every problem at once",
+            edit: func(c *Config) {
+                c.TWE.MaxRetries, c.Airtable.MaxRetries, c.Notify.MaxRetries = -1, -1, -1
+                c.Crawl.Parallelism, c.Crawl.DetailParallelism, c.Crawl.RequestDelay = 0, 0, -1
+                c.Airtable.RateLimitPause, c.Airtable.RequestsPerSecond, c.Airtable.MaxDelistedFraction = 0, 0, 1.5
+                c.Notify.ChangeLimit, c.Notify.RetryDelay = -1, 0
+            },
+            wantErr: []string{
+                "twe.max_retries", "crawl.parallelism", "crawl.detail_parallelism", "crawl.request_delay",
+                "airtable.max_retries", "airtable.rate_limit_pause", "airtable.requests_per_second",
+                "airtable.max_delisted_fraction 1.5", "notify.change_limit", "notify.max_retries", "notify.retry_delay",
+            },
+        },
+        {
+            name: "notify channels",
+            edit: func(c *Config) {
+                c.Notify.Webhooks = []WebhookConfig{{URL: "hooks.example.com"}}
+                c.Notify.Chat = []ChatConfig{{URL: "https://hooks.slack.com/services/x", Format: "teams"}}
+                c.Notify.Email = EmailConfig{Addr: "smtp.example.com"}
+            },
+            wantErr: []string{
+                "notify.webhooks[0].url is not an http(s) URL",
+                `notify.chat[0].format "teams" must be slack or discord`,
+                `notify.email.addr "smtp.example.com" must be host:port`,
+                "notify.email needs from and to",
+            },
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            cfg := Default()
+            tt.edit(cfg)
+            err := cfg.Validate()
+            if len(tt.wantErr) == 0 {
+                if err != nil {
+                    t.Fatalf("Validate: %v", err)
+                }
+                return
+            }
+            if err == nil {
+                t.Fatal("Validate succeeded")
+            }
+            if problems := strings.Count(err.Error(), "\n  - "); problems != len(tt.wantErr) {
+                t.Errorf("Validate reported %d problems, want %d:\n%v", problems, len(tt.wantErr), err)
+            }
+            for _, want := range tt.wantErr {
+                if !strings.Contains(err.Error(), want) {
+                    t.Errorf("Validate error does not mention %q:\n%v", want, err)
+                }
+            }
+        })
+    }
+}
+
+func TestRequireAPIToken(t *testing.T) {
+    for token, ok := range map[Secret]bool{"": false, placeholderAPIToken: false, "a-real-token": true} {
+        cfg := Default()
+        cfg.TWE.APIToken = token
+        if err := cfg.RequireAPIToken(); (err == nil) != ok {
+            t.Errorf("RequireAPIToken with %q = %v", token.Reveal(), err)
+        }
+    }
+}
+
+func TestRedaction(t *testing.T) {
+    cfg := Default()
+    cfg.TWE.APIToken = "api-token-123"
+    cfg.TWE.Cookies = "cf_clearance=clearance-456; session=session-789; flag=true"
+    cfg.Airtable.Token = "pat-airtable"
+    cfg.Notify.Chat = []ChatConfig{{URL: "https://hooks.slack.com/services/T0/B0/xyz"}}
+    cfg.Notify.Webhooks = []WebhookConfig{{URL: "https://hooks.example.com/in", Secret: "hmac-secret"}}
+    secrets := []string{"api-token-123", "clearance-456", "session-789", "pat-airtable", "https://hooks.slack.com/services/T0/B0/xyz", "hmac-secret"}
+
+    values := cfg.SecretValues()
+    for _, secret := range secrets {
+        found := false
+        for _, v := range values {
+            found = found || v == secret
+        }
+        if !found {
+            t.Errorf("SecretValues %q misses %q", values, secret)
+        }
+    }
+    for _, v := range values {
+        if v == "true" {
+            t.Errorf("SecretValues holds the short cookie value %q", v)
+        }
+    }
+
+    var buf strings.Builder
+    w := NewRedactor(values).Writer(&buf)
+    for _, secret := range secrets {
+        fmt.Fprintf(w, "request with %s failed\n", secret)
+    }
+    fmt.Fprintln(w, "sent cf_clearance=clearance-456 and flag=true")
+    out := buf.String()
+    for _, secret := range secrets {
+        if strings.Contains(out, secret) {
+            t.Errorf("%q not redacted from:\n%s", secret, out)
+        }
+    }
+    if strings.Count(out, redacted) != len(secrets)+1 || !strings.Contains(out, "flag=true") {
+        t.Errorf("redacted output:\n%s", out)
+    }
+
+    // Secrets never print, whether formatted or dumped.
+    printed := fmt.Sprintf("%v %+v %#v", cfg.TWE.APIToken, cfg.TWE, cfg.Airtable) + cfg.Dump()
+    for _, secret := range secrets {
+        if strings.Contains(printed, secret) {
+            t.Errorf("%q printed in:\n%s", secret, printed)
+        }
+    }
+}
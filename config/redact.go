@@ -0,0 +1,92 @@
+package config
+
+import (
+    "io"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// minSecretLen keeps short, common strings (e.g. cookie flags like "true")
+// from being treated as secrets.
+const minSecretLen = 6
+
+// SecretValues returns every secret string in the configuration, including
+// the individual values inside the cookie header, longest first.
+func (c *Config) SecretValues() []string {
+    var values []string
+    add := func(s string) {
+        s = strings.TrimSpace(s)
+        if len(s) >= minSecretLen {
+            values = append(values, s)
+        }
+    }
+
+    add(c.TWE.APIToken.Reveal())
+    add(c.TWE.CustomerSettings.Reveal())
+    add(c.Airtable.Token.Reveal())
+    add(c.Notify.Email.Password.Reveal())
+    for _, w := range c.Notify.Webhooks {
+        add(w.URL.Reveal())
+        add(w.Secret.Reveal())
+    }
+    for _, ch := range c.Notify.Chat {
+        add(ch.URL.Reveal())
+    }
+    cookies := c.TWE.Cookies.Reveal()
+    add(cookies)
+    for _, part := range strings.Split(cookies, ";") {
+        if _, value, ok := strings.Cut(part, "="); ok {
+            add(value)
+        }
+    }
+
+    // Longest first so a secret containing another is replaced whole.
+    sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+    return values
+}
+
+// Redactor replaces secret values with [REDACTED]. It only covers what is
+// passed through it: the crawler routes the standard logger and its own
+// stdout and stderr through Writer.
+type Redactor struct {
+    replacer *strings.Replacer
+}
+
+// NewRedactor builds a Redactor for the given secret values.
+func NewRedactor(secrets []string) *Redactor {
+    var pairs []string
+    for _, s := range secrets {
+        if s != "" {
+            pairs = append(pairs, s, redacted)
+        }
+    }
+    return &Redactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+// Redact returns s with every secret replaced.
+func (r *Redactor) Redact(s string) string {
+    return r.replacer.Replace(s)
+}
+
+// Writer wraps w so that everything written through it is redacted. Each
+// Write is redacted on its own, which suits line-oriented writers such as
+// the standard logger.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+    return &redactingWriter{redactor: r, w: w}
+}
+
+type redactingWriter struct {
+    mu       sync.Mutex
+    redactor *Redactor
+    w        io.Writer
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+    rw.mu.Lock()
+    defer rw.mu.Unlock()
+    if _, err := io.WriteString(rw.w, rw.redactor.Redact(string(p))); err != nil {
+        return 0, err
+    }
+    return len(p), nil
+}
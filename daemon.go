@@ -0,0 +1,440 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "math/rand"
+    "os"
+    "os/exec"
+    "os/signal"
+    "regexp"
+    "sync"
+    "syscall"
+    "time"
+
+    "theWhiskyExchangeCrawler/config"
+    "theWhiskyExchangeCrawler/schedule"
+)
+
+// daemonJob is a scheduled crawl and when it is next due.
+type daemonJob struct {
+    name     string
+    schedule *schedule.Schedule
+    args     []string
+    jitter   time.Duration
+    // slot is the scheduled time of the next run; the run starts at due,
+    // slot plus jitter.
+    slot time.Time
+    due  time.Time
+}
+
+// plan sets the next run to slot.
+func (j *daemonJob) plan(slot time.Time) {
+    j.slot, j.due = slot, slot
+    if j.jitter > 0 {
+        j.due = slot.Add(time.Duration(rand.Int63n(int64(j.jitter))))
+    }
+}
+
+// jobState is what the state file records about a job.
+type jobState struct {
+    // LastSlot is the scheduled time of the last run, the one a restarted
+    // daemon counts missed runs from.
+    LastSlot   time.Time `json:"lastSlot"`
+    LastStart  time.Time `json:"lastStart"`
+    LastFinish time.Time `json:"lastFinish"`
+    // LastResult is running, succeeded, failed or stopped; LastStatus is
+    // the crawl's exit status.
+    LastResult string    `json:"lastResult"`
+    LastStatus int       `json:"lastStatus"`
+    Runs       int       `json:"runs"`
+    Failures   int       `json:"failures"`
+    Skipped    int       `json:"skipped"`
+    NextRun    time.Time `json:"nextRun"`
+}
+
+// daemonState is the state file. It is safe for concurrent use.
+type daemonState struct {
+    path string
+    mu   sync.Mutex
+    Jobs map[string]*jobState `json:"jobs"`
+}
+
+func loadDaemonState(path string) (*daemonState, error) {
+    s := &daemonState{path: path, Jobs: make(map[string]*jobState)}
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return s, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal(data, s); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    if s.Jobs == nil {
+        s.Jobs = make(map[string]*jobState)
+    }
+    return s, nil
+}
+
+// update changes the state of job name and saves the file. Failures to
+// save are only logged: they cost the catch-up after a restart, not the
+// schedule.
+func (s *daemonState) update(name string, change func(*jobState)) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    st := s.Jobs[name]
+    if st == nil {
+        st = &jobState{}
+        s.Jobs[name] = st
+    }
+    change(st)
+
+    data, err := json.MarshalIndent(s, "", "  ")
+    if err == nil {
+        tmp := s.path + ".tmp"
+        if err = os.WriteFile(tmp, append(data, '\n'), 0o644); err == nil {
+            err = os.Rename(tmp, s.path)
+        }
+    }
+    if err != nil {
+        log.Printf("Warning: cannot save the daemon state: %v", err)
+    }
+}
+
+// get returns a copy of the state of job name.
+func (s *daemonState) get(name string) jobState {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if st := s.Jobs[name]; st != nil {
+        return *st
+    }
+    return jobState{}
+}
+
+var jobNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// daemonJobs checks the configured jobs and parses their schedules.
+func daemonJobs(cfg config.DaemonConfig) ([]*daemonJob, error) {
+    if len(cfg.Jobs) == 0 {
+        return nil, errors.New("no jobs configured (daemon.jobs)")
+    }
+    seen := make(map[string]bool)
+    var jobs []*daemonJob
+    for i, jc := range cfg.Jobs {
+        if !jobNamePattern.MatchString(jc.Name) {
+            return nil, fmt.Errorf("daemon.jobs[%d].name %q must be letters, digits, '.', '_' or '-'", i, jc.Name)
+        }
+        if seen[jc.Name] {
+            return nil, fmt.Errorf("daemon.jobs: two jobs are named %q", jc.Name)
+        }
+        seen[jc.Name] = true
+        sched, err := schedule.Parse(jc.Schedule)
+        if err != nil {
+            return nil, fmt.Errorf("daemon.jobs[%d] (%s): %w", i, jc.Name, err)
+        }
+        j := &daemonJob{name: jc.Name, schedule: sched, args: jc.Args, jitter: cfg.Jitter}
+        if jc.Jitter > 0 {
+            j.jitter = jc.Jitter
+        }
+        if j.jitter < 0 {
+            return nil, fmt.Errorf("daemon.jobs[%d] (%s): jitter must not be negative", i, jc.Name)
+        }
+        jobs = append(jobs, j)
+    }
+    return jobs, nil
+}
+
+// daemon runs scheduled crawls one at a time, each as a child process.
+type daemon struct {
+    exe           string
+    configArgs    []string
+    checkpointDir string
+    state         *daemonState
+    // stdout and stderr take the jobs' output, with secrets redacted.
+    stdout, stderr io.Writer
+
+    mu       sync.Mutex
+    stopping bool // no further job may start
+    child    *exec.Cmd
+    running  string
+}
+
+// runDaemon runs the configured crawl jobs on their schedules until it
+// receives SIGTERM or SIGINT.
+func runDaemon(args []string) {
+    fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: theWhiskyExchangeCrawler daemon [flags]")
+        fs.PrintDefaults()
+    }
+    list := fs.Bool("list", false, "list the jobs, their last run and when they run next, then exit")
+    configFlags := config.RegisterFlags(fs)
+    fs.Parse(args)
+
+    cfg := loadConfig(configFlags)
+    jobs, err := daemonJobs(cfg.Daemon)
+    if err != nil {
+        fmt.Fprintln(stderr, "Configuration error:", err)
+        os.Exit(exitUsage)
+    }
+    state, err := loadDaemonState(cfg.Daemon.StateFile)
+    if err != nil {
+        fmt.Fprintln(stderr, "Cannot read the daemon state:", err)
+        os.Exit(exitUsage)
+    }
+    exe, err := os.Executable()
+    if err != nil {
+        fmt.Fprintln(stderr, "Cannot locate the crawler binary:", err)
+        os.Exit(exitFailed)
+    }
+    d := &daemon{
+        exe: exe, configArgs: configFlags.Args(), checkpointDir: cfg.Crawl.CheckpointDir, state: state,
+        stdout: stdout, stderr: stderr,
+    }
+
+    now := time.Now()
+    for _, j := range jobs {
+        last := state.get(j.name)
+        switch {
+        case last.LastResult == "running":
+            // The daemon died during the run; its checkpoint lets it resume.
+            log.Printf("Job %s was interrupted at %s; running it again.", j.name, last.LastStart.Format(time.DateTime))
+            j.plan(now)
+        case cfg.Daemon.CatchUp && !last.LastSlot.IsZero() && !j.schedule.Next(last.LastSlot).After(now):
+            log.Printf("Job %s missed its run at %s; running it now.", j.name, j.schedule.Next(last.LastSlot).Format(time.DateTime))
+            j.plan(now)
+        default:
+            j.plan(j.schedule.Next(now))
+        }
+        if !*list {
+            state.update(j.name, func(st *jobState) { st.NextRun = j.due })
+        }
+    }
+    if *list {
+        for _, j := range jobs {
+            last := state.get(j.name)
+            fmt.Fprintf(stdout, "%s  %s  next %s", j.name, j.schedule, j.due.Format(time.DateTime))
+            if !last.LastStart.IsZero() {
+                fmt.Fprintf(stdout, "  last %s %s (%d runs, %d failed)", last.LastStart.Format(time.DateTime), last.LastResult, last.Runs, last.Failures)
+            }
+            fmt.Fprintln(stdout)
+        }
+        return
+    }
+
+    signals := make(chan os.Signal, 2)
+    signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+    queue := make(chan *daemonJob, len(jobs))
+    finished := make(chan *daemonJob)
+    workerDone := make(chan struct{})
+    go func() {
+        defer close(workerDone)
+        for j := range queue {
+            d.run(j)
+            finished <- j
+        }
+    }()
+
+    log.Printf("Daemon started with %d jobs; state in %s.", len(jobs), cfg.Daemon.StateFile)
+    pending := make(map[string]bool) // queued or running
+    timer := time.NewTimer(0)
+    for {
+        next := jobs[0]
+        for _, j := range jobs[1:] {
+            if j.due.Before(next.due) {
+                next = j
+            }
+        }
+        if !timer.Stop() {
+            select {
+            case <-timer.C:
+            default:
+            }
+        }
+        timer.Reset(time.Until(next.due))
+
+        select {
+        case <-timer.C:
+            now := time.Now()
+            for _, j := range jobs {
+                if j.due.After(now) {
+                    continue
+                }
+                if pending[j.name] {
+                    log.Printf("Job %s is still running or waiting for another job; skipping its %s run.", j.name, j.slot.Format(time.DateTime))
+                    state.update(j.name, func(st *jobState) { st.Skipped++ })
+                } else {
+                    pending[j.name] = true
+                    run := *j
+                    queue <- &run
+                }
+                slot := j.slot
+                if slot.Before(now) {
+                    slot = now
+                }
+                j.plan(j.schedule.Next(slot))
+                state.update(j.name, func(st *jobState) { st.NextRun = j.due })
+            }
+        case j := <-finished:
+            delete(pending, j.name)
+        case sig := <-signals:
+            close(queue)
+            d.shutdown(sig, signals, finished, workerDone)
+            return
+        }
+    }
+}
+
+// run runs one job to completion and records the outcome. Once the daemon
+// is stopping it starts nothing.
+func (d *daemon) run(j *daemonJob) {
+    args := append(append([]string{}, d.configArgs...), j.args...)
+    if d.checkpointDir != "" {
+        // Each job keeps its own checkpoint, so an interrupted crawl
+        // resumes in its next run.
+        dir := d.checkpointDir + "-" + j.name
+        args = append(args, "-checkpoint-dir", dir)
+        if saved, err := loadCheckpoint(dir); err == nil && saved != nil {
+            args = append(args, "-resume")
+        }
+    }
+
+    stdout := &prefixWriter{prefix: "[" + j.name + "] ", w: d.stdout}
+    stderr := &prefixWriter{prefix: "[" + j.name + "] ", w: d.stderr}
+    cmd := exec.Command(d.exe, args...)
+    cmd.Stdout, cmd.Stderr = stdout, stderr
+    detach(cmd)
+
+    // Starting under the lock means shutdown either sees the crawl or
+    // keeps it from starting.
+    d.mu.Lock()
+    if d.stopping {
+        d.mu.Unlock()
+        log.Printf("Job %s not started: the daemon is stopping.", j.name)
+        return
+    }
+    err := cmd.Start()
+    if err == nil {
+        d.child, d.running = cmd, j.name
+    }
+    d.mu.Unlock()
+
+    started := time.Now()
+    d.state.update(j.name, func(st *jobState) {
+        st.LastSlot, st.LastStart, st.LastFinish = j.slot, started, time.Time{}
+        st.LastResult, st.LastStatus = "running", 0
+        st.Runs++
+    })
+    if err == nil {
+        log.Printf("Job %s started.", j.name)
+        err = cmd.Wait()
+        d.mu.Lock()
+        d.child, d.running = nil, ""
+        d.mu.Unlock()
+    }
+    stdout.flush()
+    stderr.flush()
+
+    status := 0
+    var exitErr *exec.ExitError
+    switch {
+    case errors.As(err, &exitErr):
+        status = exitErr.ExitCode()
+    case err != nil:
+        log.Printf("Job %s could not run: %v", j.name, err)
+        status = exitFailed
+    }
+    result := "succeeded"
+    switch status {
+    case 0:
+    case exitStopped:
+        result = "stopped"
+    default:
+        result = "failed"
+    }
+    d.state.update(j.name, func(st *jobState) {
+        st.LastFinish, st.LastResult, st.LastStatus = time.Now(), result, status
+        if result == "failed" {
+            st.Failures++
+        }
+    })
+    log.Printf("Job %s %s with exit status %d after %s.", j.name, result, status, time.Since(started).Round(time.Second))
+}
+
+// shutdown stops scheduling and asks a running crawl to stop after the
+// page in hand. It returns once the worker has finished, so no crawl is
+// left behind; jobs still queued are not started. Another signal kills the
+// crawl at once.
+func (d *daemon) shutdown(sig os.Signal, signals chan os.Signal, finished chan *daemonJob, workerDone chan struct{}) {
+    d.mu.Lock()
+    d.stopping = true
+    child, running := d.child, d.running
+    d.mu.Unlock()
+    if child == nil {
+        log.Printf("Received %v; no job running, exiting.", sig)
+    } else {
+        log.Printf("Received %v; asking job %s to stop after the page in hand (signal again to kill it).", sig, running)
+        if err := child.Process.Signal(syscall.SIGTERM); err != nil {
+            child.Process.Kill()
+        }
+    }
+    for {
+        select {
+        case <-finished:
+        case <-workerDone:
+            log.Printf("Daemon stopped.")
+            return
+        case sig := <-signals:
+            d.mu.Lock()
+            child, running := d.child, d.running
+            d.mu.Unlock()
+            if child != nil {
+                log.Printf("Received %v again; killing job %s.", sig, running)
+                child.Process.Kill()
+            }
+        }
+    }
+}
+
+// prefixWriter prefixes every line written through it, so the output of
+// a job can be told apart.
+type prefixWriter struct {
+    prefix string
+    w      io.Writer
+
+    mu  sync.Mutex
+    buf []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.buf = append(p.buf, b...)
+    for {
+        i := bytes.IndexByte(p.buf, '\n')
+        if i < 0 {
+            return len(b), nil
+        }
+        if _, err := fmt.Fprintf(p.w, "%s%s", p.prefix, p.buf[:i+1]); err != nil {
+            return len(b), err
+        }
+        p.buf = p.buf[i+1:]
+    }
+}
+
+// flush writes out a last line that did not end in a newline.
+func (p *prefixWriter) flush() {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if len(p.buf) > 0 {
+        fmt.Fprintf(p.w, "%s%s\n", p.prefix, p.buf)
+        p.buf = nil
+    }
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import (
+    "os/exec"
+    "syscall"
+)
+
+// detach starts cmd in a process group of its own, so a Ctrl-C at the
+// terminal reaches only the daemon, which passes it on once.
+func detach(cmd *exec.Cmd) {
+    cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
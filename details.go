@@ -0,0 +1,97 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+
+    "theWhiskyExchangeCrawler/sink"
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// detailColumns are the tabular output columns filled from product pages.
+var detailColumns = []sink.Column{
+    {Name: "Nose", Kind: sink.String},
+    {Name: "Palate", Kind: sink.String},
+    {Name: "Finish", Kind: sink.String},
+    {Name: "Distillery", Kind: sink.String},
+    {Name: "Region", Kind: sink.String},
+    {Name: "Cask Type", Kind: sink.String},
+    {Name: "Bottler", Kind: sink.String},
+    {Name: "Vintage", Kind: sink.String},
+    {Name: "Rating", Kind: sink.Number},
+    {Name: "Review Count", Kind: sink.Number},
+    {Name: "GTIN", Kind: sink.String},
+    {Name: "MPN", Kind: sink.String},
+}
+
+// detailFieldMap returns the detailColumns values of d. Missing details and
+// empty fields are left out, so they become empty cells.
+func detailFieldMap(d *twe.Details) map[string]interface{} {
+    fields := make(map[string]interface{})
+    if d == nil {
+        return fields
+    }
+    for name, value := range map[string]string{
+        "Nose": d.Nose, "Palate": d.Palate, "Finish": d.Finish,
+        "Distillery": d.Distillery, "Region": d.Region, "Cask Type": d.CaskType,
+        "Bottler": d.Bottler, "Vintage": d.Vintage, "GTIN": d.GTIN, "MPN": d.MPN,
+    } {
+        if value != "" {
+            fields[name] = value
+        }
+    }
+    if d.Rating != 0 {
+        fields["Rating"] = d.Rating
+    }
+    if d.ReviewCount != 0 {
+        fields["Review Count"] = d.ReviewCount
+    }
+    return fields
+}
+
+// detailEnricher visits the product pages of each listing page before its
+// products are written, so every output carries the details. All methods are
+// no-ops when detail fetching is disabled.
+type detailEnricher struct {
+    fetcher  *twe.DetailFetcher
+    enriched int
+    failed   int
+}
+
+func newDetailEnricher(client *twe.Client, enabled bool, parallelism int) *detailEnricher {
+    d := &detailEnricher{}
+    if enabled {
+        fmt.Fprintf(stdout, "Visiting product pages for details, %d at a time\n", parallelism)
+        d.fetcher = client.Details(parallelism, nil)
+    }
+    return d
+}
+
+// enrich adds details to products, usually those of one listing page. Pages
+// that fail are logged and their products written without details; only an
+// error that would fail every further request (a challenge or rejected
+// session) is returned.
+func (d *detailEnricher) enrich(ctx context.Context, products []twe.Product) error {
+    if d.fetcher == nil {
+        return nil
+    }
+    failures, err := d.fetcher.Enrich(ctx, products)
+    for _, failure := range failures {
+        log.Printf("Warning: no details for %v", failure)
+    }
+    if err != nil {
+        return fmt.Errorf("fetching product pages: %w", err)
+    }
+    d.failed += len(failures)
+    d.enriched += len(products) - len(failures)
+    return nil
+}
+
+// summary reports how many products got their details.
+func (d *detailEnricher) summary() {
+    if d.fetcher == nil {
+        return
+    }
+    fmt.Fprintf(stdout, "Product details: %d added, %d failed\n", d.enriched, d.failed)
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "theWhiskyExchangeCrawler/airtable"
+    "theWhiskyExchangeCrawler/internal/airtabletest"
+    "theWhiskyExchangeCrawler/internal/notifytest"
+    "theWhiskyExchangeCrawler/internal/twetest"
+    "theWhiskyExchangeCrawler/notify"
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// runMainEnv makes the test binary run the crawler's main instead of the
+// tests, so the end-to-end tests can run the crawler as a process of its
+// own, exit statuses included.
+const runMainEnv = "TWE_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+    if os.Getenv(runMainEnv) == "1" {
+        main()
+        os.Exit(0)
+    }
+    os.Exit(m.Run())
+}
+
+// crawlerCommand returns a command running the crawler with args in dir, with
+// credentials for the fakes only.
+func crawlerCommand(t *testing.T, dir string, args ...string) *exec.Cmd {
+    t.Helper()
+    var env []string
+    for _, kv := range os.Environ() {
+        if !strings.HasPrefix(kv, "TWE_") && !strings.HasPrefix(kv, "AIRTABLE_") {
+            env = append(env, kv)
+        }
+    }
+    env = append(env, runMainEnv+"=1", "TWE_API_TOKEN=e2e-api-token", "AIRTABLE_TOKEN=e2e-airtable-token")
+    cmd := exec.Command(os.Args[0], args...)
+    cmd.Dir = dir
+    cmd.Env = env
+    return cmd
+}
+
+// runCrawler runs the crawler to completion, logging its output to
+// <name>.log in dir, and returns its exit status.
+func runCrawler(t *testing.T, dir, name string, args ...string) int {
+    t.Helper()
+    var out bytes.Buffer
+    cmd := crawlerCommand(t, dir, args...)
+    cmd.Stdout, cmd.Stderr = &out, &out
+    err := cmd.Run()
+    os.WriteFile(filepath.Join(dir, name+".log"), out.Bytes(), 0o644)
+    if testing.Verbose() {
+        t.Logf("%s:\n%s", name, out.Bytes())
+    }
+    if exitErr, ok := err.(*exec.ExitError); ok {
+        return exitErr.ExitCode()
+    }
+    if err != nil {
+        t.Fatalf("running %s: %v", name, err)
+    }
+    return 0
+}
+
+func readJSON(t *testing.T, path string, v interface{}) {
+    t.Helper()
+    data, err := os.ReadFile(path)
+    if err == nil {
+        err = json.Unmarshal(data, v)
+    }
+    if err != nil {
+        t.Fatalf("reading %s: %v", filepath.Base(path), err)
+    }
+}
+
+// airtableSchema is the column layout of the production table.
+var airtableSchema = map[string]airtabletest.FieldType{
+    "SKU":                airtabletest.Text,
+    "Name":               airtabletest.Text,
+    "Price":              airtabletest.Number,
+    "ExVATPrice":         airtabletest.Number,
+    "ABV":                airtabletest.Text,
+    "Size":               airtabletest.Text,
+    "Description":        airtabletest.LongText,
+    "Image URL":          airtabletest.URL,
+    "Product URL":        airtabletest.URL,
+    "ScrapedDate":        airtabletest.Date,
+    "isActive":           airtabletest.Text,
+    "MaxOrderQuantity":   airtabletest.Number,
+    "Manufacturer":       airtabletest.Text,
+    "Brand":              airtabletest.Text,
+    "MasterCategoryName": airtabletest.Text,
+    "CategoryName":       airtabletest.Text,
+    "Weight":             airtabletest.Number,
+    "StockLevel":         airtabletest.Number,
+    "StockControl":       airtabletest.Number,
+    "isOutofStock":       airtabletest.Text,
+}
+
+// TestEndToEnd runs the crawler against a fake productlistdata endpoint, a
+// fake Airtable table and fake notification channels, and checks what
+// reaches them: details and facet tags, batching, upserts, delisting,
+// retries after 5xx and 429, dead-lettering of a rejected record and
+// replaying it with retry-failed, several queries in one run, change
+// detection, watchlist alerts and notifications. Each step builds on the
+// one before.
+func TestEndToEnd(t *testing.T) {
+    if testing.Short() {
+        t.Skip("end-to-end test runs the crawler several times")
+    }
+    dir := t.TempDir()
+
+    // 36 products over 3 gzip pages
+    tweServer := twetest.NewServer(twetest.Options{TotalPages: 3, PageSize: 12, Encoding: "gzip"})
+    defer tweServer.Close()
+
+    // The table already holds the first 20 products at stale prices, plus a
+    // product that has since left the catalogue. SKU 7 is rejected until
+    // the "fix" before retry-failed.
+    var rejectSKU7 atomic.Bool
+    rejectSKU7.Store(true)
+    seed := []airtable.Record{{Fields: map[string]interface{}{"SKU": "9999", "Name": "Gone", "isActive": "true"}}}
+    for sku := 1; sku <= 20; sku++ {
+        if sku == 7 {
+            continue
+        }
+        seed = append(seed, airtable.Record{Fields: map[string]interface{}{"SKU": strconv.Itoa(sku), "Price": 1.0, "isActive": "true"}})
+    }
+    tableServer := airtabletest.NewServer(airtabletest.Options{
+        Token:            "e2e-airtable-token",
+        Schema:           airtableSchema,
+        Records:          seed,
+        RateLimitPenalty: 2 * time.Second,
+        Faults:           map[int]int{3: 503, 5: 429},
+        Validate: func(fields map[string]interface{}) string {
+            if rejectSKU7.Load() && fields["SKU"] == "7" {
+                return `Field "Price" cannot accept the provided value`
+            }
+            return ""
+        },
+    })
+    defer tableServer.Close()
+
+    // Each notification channel fails its first delivery.
+    webhookServer := notifytest.NewWebhookServer(notifytest.Options{Secret: "e2e-webhook-secret", Faults: map[int]int{1: 503}})
+    defer webhookServer.Close()
+    chatServer := notifytest.NewWebhookServer(notifytest.Options{Faults: map[int]int{1: 429}})
+    defer chatServer.Close()
+    smtpServer, err := notifytest.NewSMTPServer(1)
+    if err != nil {
+        t.Fatalf("starting SMTP server: %v", err)
+    }
+    defer smtpServer.Close()
+
+    configYAML := fmt.Sprintf(`twe:
+  base_url: %s
+  max_retries: 2
+airtable:
+  table_url: %s
+  rate_limit_pause: 2s
+  dead_letter_file: airtable-failed.jsonl
+database:
+  path: history.db
+crawl:
+  details: true
+  facets: [Country, CaskType]
+  request_delay: 50ms
+notify:
+  retry_delay: 50ms
+  webhooks:
+    - url: %s/hook
+      secret: e2e-webhook-secret
+  chat:
+    - url: %s/chat
+      format: discord
+  email:
+    addr: %s
+    from: crawler@example.invalid
+    to: [buyers@example.invalid]
+`, tweServer.URL, tableServer.TableURL(), webhookServer.URL, chatServer.URL, smtpServer.Addr())
+    if err := os.WriteFile(filepath.Join(dir, "crawler.yaml"), []byte(configYAML), 0o644); err != nil {
+        t.Fatal(err)
+    }
+
+    var products []twe.Product
+    t.Run("first crawl", func(t *testing.T) {
+        // The rejected SKU 7 fails the upload
+        if status := runCrawler(t, dir, "crawl-1"); status != exitFailed {
+            t.Fatalf("crawl exited %d, want %d", status, exitFailed)
+        }
+        readJSON(t, filepath.Join(dir, "output.json"), &products)
+        if len(products) != 36 {
+            t.Errorf("output.json holds %d products, want 36", len(products))
+        }
+        for _, product := range products {
+            id, _ := strconv.Atoi(product.ProductID)
+            want := twetest.ProductDetails(id)
+            if d := product.Details; d == nil || d.Nose != want["Nose"] || d.Region != want["Region"] || d.GTIN != want["GTIN"] {
+                t.Errorf("product %s details = %+v, want those of its product page", product.ProductID, product.Details)
+            }
+            attrs := twetest.Attributes(id)
+            if product.Attributes["Country"] != strings.Join(attrs["Country"], "; ") || product.Attributes["CaskType"] != strings.Join(attrs["CaskType"], "; ") {
+                t.Errorf("product %s attributes = %v, want Country and CaskType %v", product.ProductID, product.Attributes, attrs)
+            }
+        }
+
+        stats := tableServer.Stats()
+        if stats.MaxBatch > airtable.MaxRecordsPerRequest {
+            t.Errorf("a write held %d records, more than %d", stats.MaxBatch, airtable.MaxRecordsPerRequest)
+        }
+        if stats.RateLimited != 0 {
+            t.Errorf("the client was rate limited %d times", stats.RateLimited)
+        }
+        if stats.Faults != 2 {
+            t.Errorf("%d injected faults were served, want both", stats.Faults)
+        }
+
+        rows := make(map[string]map[string]interface{})
+        for _, record := range tableServer.Records() {
+            if sku, ok := record.Fields["SKU"].(string); ok {
+                if _, dup := rows[sku]; dup {
+                    t.Errorf("SKU %s appears more than once", sku)
+                }
+                rows[sku] = record.Fields
+            }
+        }
+        for _, product := range products {
+            row, ok := rows[product.ProductID]
+            if product.ProductID == "7" {
+                if ok {
+                    t.Error("the rejected SKU 7 is in the table")
+                }
+                continue
+            }
+            if !ok || row["Price"] != product.SalesPrice || row["Name"] != product.Name {
+                t.Errorf("SKU %s row = %v, want price %v and name %q", product.ProductID, row, product.SalesPrice, product.Name)
+            }
+        }
+        if rows["9999"]["isActive"] != "false" || rows["9999"]["isOutofStock"] != "true" {
+            t.Errorf("the vanished SKU 9999 = %v, want it inactive and out of stock", rows["9999"])
+        }
+
+        letters, err := airtable.ReadDeadLetters(filepath.Join(dir, "airtable-failed.jsonl"))
+        if err != nil || len(letters) != 1 || letters[0].Record.Fields["SKU"] != "7" {
+            t.Errorf("dead letters = %v, %v; want SKU 7 only", letters, err)
+        }
+    })
+
+    t.Run("second crawl with nothing changed", func(t *testing.T) {
+        before := tableServer.Stats()
+        // The rejected SKU 7 fails the upload
+        if status := runCrawler(t, dir, "crawl-2"); status != exitFailed {
+            t.Fatalf("crawl exited %d, want %d", status, exitFailed)
+        }
+        if writes := tableServer.Stats().Writes - before.Writes; writes != 1 {
+            t.Errorf("%d writes, want only the still-rejected SKU 7", writes)
+        }
+    })
+
+    t.Run("retry-failed after fixing the rejection", func(t *testing.T) {
+        rejectSKU7.Store(false)
+        if status := runCrawler(t, dir, "retry-failed", "retry-failed"); status != 0 {
+            t.Fatalf("retry-failed exited %d", status)
+        }
+        found := false
+        for _, record := range tableServer.Records() {
+            found = found || record.Fields["SKU"] == "7"
+        }
+        if !found {
+            t.Error("SKU 7 is not in the table")
+        }
+        if _, err := os.Stat(filepath.Join(dir, "airtable-failed.jsonl")); !os.IsNotExist(err) {
+            t.Errorf("the dead-letter file is still there (%v)", err)
+        }
+    })
+
+    t.Run("two overlapping queries in one run", func(t *testing.T) {
+        status := runCrawler(t, dir, "queries", "-query", "country=Scotland", "-query", "casktype=Sherry",
+            "-output", "queries.json", "-airtable-url", "", "-db", "")
+        if status != 0 {
+            t.Fatalf("crawl exited %d", status)
+        }
+        var listed []twe.Product
+        readJSON(t, filepath.Join(dir, "queries.json"), &listed)
+        want := make(map[string][]string)
+        for id := 1; id <= 36; id++ {
+            attrs := twetest.Attributes(id)
+            var queries []string
+            for _, q := range []struct{ facet, value string }{{"Country", "Scotland"}, {"CaskType", "Sherry"}} {
+                for _, v := range attrs[q.facet] {
+                    if v == q.value {
+                        queries = append(queries, strings.ToLower(q.facet)+"="+q.value)
+                    }
+                }
+            }
+            if len(queries) > 0 {
+                want[strconv.Itoa(id)] = queries
+            }
+        }
+        if len(listed) != len(want) {
+            t.Errorf("queries.json holds %d products, want each of the %d matching products once", len(listed), len(want))
+        }
+        for _, product := range listed {
+            if got := strings.Join(product.Queries, ","); got != strings.Join(want[product.ProductID], ",") {
+                t.Errorf("product %s was listed by %q, want %q", product.ProductID, got, want[product.ProductID])
+            }
+        }
+    })
+
+    t.Run("third crawl after the catalogue moved on", func(t *testing.T) {
+        tweServer.SetRevision(1)
+        watchlist := "watches:\n  - name: favourites\n    products: [\"5\", \"7\"]\n    when: {price_below: 22, back_in_stock: true}\n"
+        if err := os.WriteFile(filepath.Join(dir, "watchlist.yaml"), []byte(watchlist), 0o644); err != nil {
+            t.Fatal(err)
+        }
+        if status := runCrawler(t, dir, "crawl-3", "-airtable-url", "", "-watchlist", "watchlist.yaml"); status != 0 {
+            t.Fatalf("crawl exited %d", status)
+        }
+
+        var report changeReport
+        readJSON(t, filepath.Join(dir, "changes.json"), &report)
+        if report.From.Run != 2 || report.To.Run != 3 {
+            t.Errorf("changes.json compares run %d with run %d, want 2 with 3", report.From.Run, report.To.Run)
+        }
+        wantCounts := map[twe.ChangeKind]int{twe.ChangeNew: 3}
+        for id := 1; id <= 36; id++ {
+            switch {
+            case id%13 == 0:
+                wantCounts[twe.ChangeRemoved]++
+                continue
+            case id%5 == 0:
+                wantCounts[twe.ChangePriceDown]++
+            }
+            switch id % 7 {
+            case 0:
+                wantCounts[twe.ChangeRestocked]++
+            case 3:
+                wantCounts[twe.ChangeOutOfStock]++
+            case 1:
+                wantCounts[twe.ChangeStockLevel]++
+            }
+            if id%11 == 0 {
+                wantCounts[twe.ChangeRenamed]++
+            }
+        }
+        for _, kind := range twe.ChangeKinds {
+            if report.Counts[kind] != wantCounts[kind] {
+                t.Errorf("%d %s reported, want %d", report.Counts[kind], kind.Heading(), wantCounts[kind])
+            }
+        }
+
+        var alerts alertReport
+        readJSON(t, filepath.Join(dir, "alerts.json"), &alerts)
+        fired := make([]string, len(alerts.Alerts))
+        for i, a := range alerts.Alerts {
+            fired[i] = fmt.Sprintf("%s %s", a.ProductID, a.Condition)
+        }
+        if got := strings.Join(fired, ","); got != "5 price_below,7 back_in_stock" {
+            t.Errorf("the watchlist fired %q, want SKU 5 under £22 and SKU 7 back in stock", got)
+        }
+
+        hooks := webhookServer.Requests()
+        if len(hooks) != 1 || webhookServer.Seen() != 2 || webhookServer.Rejected() != 0 {
+            t.Fatalf("webhook: %d accepted of %d requests, %d bad signatures; want one signed digest after a retry",
+                len(hooks), webhookServer.Seen(), webhookServer.Rejected())
+        }
+        var digest notify.Digest
+        if err := json.Unmarshal(hooks[0].Body, &digest); err != nil {
+            t.Fatalf("decoding the webhook digest: %v", err)
+        }
+        if len(digest.Changes) != len(report.Changes) || len(digest.Alerts) != 2 || digest.Since != 2 {
+            t.Errorf("the digest holds %d changes and %d alerts since run %d, want %d, 2 and 2",
+                len(digest.Changes), len(digest.Alerts), digest.Since, len(report.Changes))
+        }
+
+        longest, text := 0, ""
+        for _, req := range chatServer.Requests() {
+            var msg struct{ Content string }
+            json.Unmarshal(req.Body, &msg)
+            longest = max(longest, len([]rune(msg.Content)))
+            text += msg.Content + "\n"
+        }
+        if len(chatServer.Requests()) == 0 || longest > 2000 {
+            t.Errorf("chat: %d messages, longest %d; want them within Discord's limit", len(chatServer.Requests()), longest)
+        }
+        if !strings.Contains(text, "[favourites] 5 ") || !strings.Contains(text, "price drops (7):") {
+            t.Errorf("the chat digest lacks the alert or the price drops:\n%s", text)
+        }
+
+        mails := smtpServer.Mails()
+        if len(mails) != 1 {
+            t.Fatalf("%d emails sent, want one after a temporary failure", len(mails))
+        }
+        subject, _, err := mails[0].Decode()
+        if err != nil || !strings.HasPrefix(subject, "The Whisky Exchange: 2 alerts and") {
+            t.Errorf("email subject = %q, %v", subject, err)
+        }
+    })
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// facetNames resolves the configured facets to filter names, in order and
+// without duplicates.
+func facetNames(configured []string) ([]string, error) {
+    var names []string
+    seen := make(map[string]bool)
+    for _, name := range configured {
+        filter, ok := twe.FilterName(name)
+        if !ok {
+            return nil, fmt.Errorf("%q is not a filter (see -query-keys)", name)
+        }
+        if !seen[filter] {
+            seen[filter] = true
+            names = append(names, filter)
+        }
+    }
+    return names, nil
+}
+
+// harvestFacets tags the products of query with the values of each named
+// facet: it reads the facet values the endpoint offers for query, then
+// lists query once per value. Facets the endpoint doesn't offer are skipped
+// with a warning.
+func harvestFacets(ctx context.Context, client *twe.Client, query twe.Query, names []string) (twe.FacetTags, error) {
+    tags := make(twe.FacetTags)
+    if len(names) == 0 {
+        return tags, nil
+    }
+    offered, err := client.Facets(ctx, query)
+    if err != nil {
+        return nil, fmt.Errorf("reading facets: %w", err)
+    }
+    for _, name := range names {
+        if value := query.Filter(name); value != "" {
+            log.Printf("Warning: the query already filters on %s=%s; products are not tagged with it.", name, value)
+            continue
+        }
+        facet, ok := twe.FindFacet(offered, name)
+        if !ok {
+            log.Printf("Warning: the endpoint offers no %s facet for this query; products are not tagged with it.", name)
+            continue
+        }
+        facet.Name = name
+        fmt.Fprintf(stdout, "Tagging products with %d %s values\n", len(facet.Values), name)
+        err := client.TagFacet(ctx, query, facet, tags, func(value twe.FacetValue, found int) {
+            fmt.Fprintf(stdout, "  %s=%s: %d products\n", name, value, found)
+            if value.Count != 0 && found != value.Count {
+                log.Printf("Warning: %s=%s listed %d products but the site counts %d", name, value, found, value.Count)
+            }
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+    fmt.Fprintf(stdout, "Facet values found for %d products\n", len(tags))
+    return tags, nil
+}
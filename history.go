@@ -0,0 +1,187 @@
+package main
+
+import (
+    "context"
+    "encoding/csv"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strconv"
+    "time"
+
+    "theWhiskyExchangeCrawler/config"
+    "theWhiskyExchangeCrawler/store"
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// crawlHistory records a crawl into the SQLite history store. All methods are
+// no-ops when the store is disabled or failed to open, so a broken database
+// never stops the crawl itself.
+type crawlHistory struct {
+    db  *store.Store
+    run *store.Run
+}
+
+// openHistory starts a new history run, or continues run resumeID when it is
+// non-zero and still unfinished.
+func openHistory(ctx context.Context, dbConfig config.DatabaseConfig, query string, resumeID int64) *crawlHistory {
+    h := &crawlHistory{}
+    if dbConfig.Path == "" {
+        return h
+    }
+
+    db, err := store.Open(dbConfig.Path)
+    if err != nil {
+        log.Printf("Warning: history database disabled: %v", err)
+        return h
+    }
+    if resumeID != 0 {
+        run, err := db.ResumeRun(ctx, resumeID)
+        if err == nil {
+            fmt.Fprintf(stdout, "Continuing history run %d in %s\n", run.ID, dbConfig.Path)
+            h.db, h.run = db, run
+            return h
+        }
+        log.Printf("Warning: cannot continue history run %d, starting a new one: %v", resumeID, err)
+    }
+    run, err := db.BeginRun(ctx, query)
+    if err != nil {
+        log.Printf("Warning: history database disabled: could not start run: %v", err)
+        db.Close()
+        return h
+    }
+    fmt.Fprintf(stdout, "Recording crawl as run %d in %s\n", run.ID, dbConfig.Path)
+    h.db, h.run = db, run
+    return h
+}
+
+// runID returns the history run being recorded, or 0 when disabled.
+func (h *crawlHistory) runID() int64 {
+    if h.run == nil {
+        return 0
+    }
+    return h.run.ID
+}
+
+func (h *crawlHistory) record(ctx context.Context, products []twe.Product) {
+    if h.run == nil || len(products) == 0 {
+        return
+    }
+    if err := h.run.Record(ctx, products); err != nil {
+        log.Printf("Error recording %d products in history database: %v", len(products), err)
+    }
+}
+
+// recordMissing records those of a resumed crawl's earlier products that
+// the run has no observation for. The checkpoint is saved before each page
+// is recorded, so a crawl that died in between left that page out.
+func (h *crawlHistory) recordMissing(ctx context.Context, products []twe.Product) {
+    if h.run == nil || len(products) == 0 {
+        return
+    }
+    seen, err := h.run.Observed(ctx)
+    if err != nil {
+        log.Printf("Error reading history run %d: %v", h.run.ID, err)
+        return
+    }
+    var missing []twe.Product
+    for _, p := range products {
+        if !seen[p.ProductID] {
+            missing = append(missing, p)
+        }
+    }
+    if len(missing) > 0 {
+        fmt.Fprintf(stdout, "Recording %d checkpointed products missing from history run %d\n", len(missing), h.run.ID)
+        h.record(ctx, missing)
+    }
+}
+
+func (h *crawlHistory) finish(ctx context.Context, productCount int) {
+    if h.run == nil {
+        return
+    }
+    if err := h.run.Finish(ctx, productCount); err != nil {
+        log.Printf("Error finishing history run %d: %v", h.run.ID, err)
+    }
+}
+
+// previous returns the previous finished crawl of the same query and its
+// products, or 0 and no products when there is none or the history is
+// disabled.
+func (h *crawlHistory) previous(ctx context.Context) (int64, map[string]twe.Product, error) {
+    if h.run == nil {
+        return 0, nil, nil
+    }
+    run, err := h.db.RunInfo(ctx, h.run.ID)
+    if err != nil {
+        return 0, nil, err
+    }
+    previous, ok, err := h.db.PreviousRun(ctx, run)
+    if err != nil || !ok {
+        return 0, nil, err
+    }
+    products, err := h.db.Snapshot(ctx, previous.ID)
+    if err != nil {
+        return 0, nil, err
+    }
+    return previous.ID, products, nil
+}
+
+func (h *crawlHistory) close() {
+    if h.db != nil {
+        h.db.Close()
+    }
+}
+
+// runHistory prints the recorded price and stock history of one product as
+// CSV, ready to chart.
+func runHistory(args []string) {
+    fs := flag.NewFlagSet("history", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: theWhiskyExchangeCrawler history [flags] <ProductID>")
+        fs.PrintDefaults()
+    }
+    configFlags := config.RegisterFlags(fs)
+    fs.Parse(args)
+    if fs.NArg() != 1 {
+        fs.Usage()
+        os.Exit(exitUsage)
+    }
+
+    cfg := loadConfig(configFlags)
+    if cfg.Database.Path == "" {
+        fmt.Fprintln(stderr, "No history database configured (database.path / -db).")
+        os.Exit(exitUsage)
+    }
+
+    db, err := store.Open(cfg.Database.Path)
+    if err != nil {
+        log.Fatalf("Error opening history database: %v", err)
+    }
+    defer db.Close()
+
+    observations, err := db.History(context.Background(), fs.Arg(0))
+    if err != nil {
+        log.Fatalf("Error reading history for %s: %v", fs.Arg(0), err)
+    }
+
+    w := csv.NewWriter(stdout)
+    w.Write([]string{"scraped_at", "run_id", "sales_price", "sales_price_ex_vat", "stock_level", "stock_control", "is_out_of_stock", "is_active"})
+    for _, o := range observations {
+        w.Write([]string{
+            o.ScrapedAt.Format(time.RFC3339),
+            strconv.FormatInt(o.RunID, 10),
+            strconv.FormatFloat(o.SalesPrice, 'f', -1, 64),
+            strconv.FormatFloat(o.SalesPriceExVat, 'f', -1, 64),
+            strconv.FormatFloat(o.StockLevel, 'f', -1, 64),
+            strconv.FormatFloat(o.StockControl, 'f', -1, 64),
+            strconv.FormatBool(o.IsOutOfStock),
+            strconv.FormatBool(o.IsActive),
+        })
+    }
+    w.Flush()
+    if err := w.Error(); err != nil {
+        log.Fatalf("Error writing CSV: %v", err)
+    }
+}
@@ -0,0 +1,449 @@
+// Package airtabletest provides a stand-in for one Airtable table that
+// enforces the constraints of the real Web API: bearer token auth, at most
+// 10 records per write, a per-second request limit with a lockout after a
+// 429, and per-column type validation answered with 422s.
+package airtabletest
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "theWhiskyExchangeCrawler/airtable"
+)
+
+// FieldType is an Airtable column type, as far as validation cares.
+type FieldType string
+
+const (
+    Text     FieldType = "singleLineText"
+    LongText FieldType = "multilineText"
+    URL      FieldType = "url"
+    Number   FieldType = "number"
+    Checkbox FieldType = "checkbox"
+    Date     FieldType = "date" // YYYY-MM-DD
+)
+
+// Options configure the fake table.
+type Options struct {
+    // Token is the API token every request must carry. Empty accepts any.
+    Token string
+    // Schema lists the table's columns. Writes to other fields fail with
+    // UNKNOWN_FIELD_NAME; nil accepts any field with any value.
+    Schema map[string]FieldType
+    // Records are the rows the table starts with. Records without an ID get
+    // one assigned.
+    Records []airtable.Record
+
+    // RequestsPerSecond is the rate limit, 5 by default like the real API.
+    // Requests are counted over a sliding window of slightly under a second,
+    // so evenly spaced requests at exactly the limit are not penalised for
+    // scheduling jitter.
+    RequestsPerSecond int
+    // RateLimitPenalty is how long every request is refused after a 429,
+    // 30 seconds by default like the real API. No Retry-After is sent.
+    RateLimitPenalty time.Duration
+
+    // Faults answers the n-th request (counting from 1) with the given
+    // status instead of serving it, to exercise retries.
+    Faults map[int]int
+    // Validate, if set, can reject individual records on top of the schema
+    // checks. A non-empty message fails the whole request with
+    // INVALID_VALUE_FOR_COLUMN, as Airtable does.
+    Validate func(fields map[string]interface{}) string
+}
+
+// Stats counts what the fake has seen.
+type Stats struct {
+    Requests       int // every request, including refused ones
+    Writes         int // PATCH and POST requests
+    RateLimited    int // 429s caused by the client's request rate
+    Rejected       int // 422s
+    Faults         int // injected faults served
+    MaxBatch       int // most records received in one write
+    RecordsWritten int // records created or updated by successful writes
+}
+
+// Handler serves the table at any /v0/{baseId}/{table} path.
+type Handler struct {
+    opts Options
+
+    mu          sync.Mutex
+    records     map[string]map[string]interface{}
+    nextID      int
+    recent      []time.Time // request times within the rate window
+    lockedUntil time.Time
+    stats       Stats
+}
+
+// rateWindow is the sliding window requests are counted over.
+const rateWindow = 950 * time.Millisecond
+
+// NewHandler returns a Handler for opts, filling in defaults.
+func NewHandler(opts Options) *Handler {
+    if opts.RequestsPerSecond <= 0 {
+        opts.RequestsPerSecond = 5
+    }
+    if opts.RateLimitPenalty <= 0 {
+        opts.RateLimitPenalty = 30 * time.Second
+    }
+    h := &Handler{opts: opts, records: make(map[string]map[string]interface{})}
+    for _, r := range opts.Records {
+        id := r.ID
+        if id == "" {
+            id = h.newID()
+        }
+        h.records[id] = copyFields(r.Fields)
+    }
+    return h
+}
+
+// Records returns a snapshot of the table, ordered by record ID.
+func (h *Handler) Records() []airtable.Record {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    records := make([]airtable.Record, 0, len(h.records))
+    for id, fields := range h.records {
+        records = append(records, airtable.Record{ID: id, Fields: copyFields(fields)})
+    }
+    sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+    return records
+}
+
+// Stats returns the counters so far.
+func (h *Handler) Stats() Stats {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    return h.stats
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.stats.Requests++
+
+    if parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/"); len(parts) != 3 || parts[0] != "v0" {
+        writeError(w, http.StatusNotFound, "NOT_FOUND", "")
+        return
+    }
+    if h.opts.Token != "" && r.Header.Get("Authorization") != "Bearer "+h.opts.Token {
+        writeError(w, http.StatusUnauthorized, "AUTHENTICATION_REQUIRED", "Authentication required")
+        return
+    }
+    if !h.allow(time.Now()) {
+        writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+            "errors": []map[string]string{{"error": "RATE_LIMIT_REACHED", "message": "Rate limit exceeded. Please try again later"}},
+        })
+        return
+    }
+    if status, ok := h.opts.Faults[h.stats.Requests]; ok {
+        h.stats.Faults++
+        writeError(w, status, strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_")), "injected fault")
+        return
+    }
+
+    switch r.Method {
+    case http.MethodGet:
+        h.list(w, r)
+    case http.MethodPatch, http.MethodPost:
+        h.stats.Writes++
+        h.write(w, r)
+    default:
+        writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "")
+    }
+}
+
+// allow applies the rate limit. Exceeding it locks the client out for
+// RateLimitPenalty, during which every request is refused.
+func (h *Handler) allow(now time.Time) bool {
+    if now.Before(h.lockedUntil) {
+        h.stats.RateLimited++
+        return false
+    }
+    kept := h.recent[:0]
+    for _, t := range h.recent {
+        if now.Sub(t) < rateWindow {
+            kept = append(kept, t)
+        }
+    }
+    h.recent = append(kept, now)
+    if len(h.recent) > h.opts.RequestsPerSecond {
+        h.lockedUntil = now.Add(h.opts.RateLimitPenalty)
+        h.stats.RateLimited++
+        return false
+    }
+    return true
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    pageSize := 100
+    if value := query.Get("pageSize"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 1 || n > 100 {
+            writeError(w, http.StatusUnprocessableEntity, "INVALID_PAGE_SIZE", "pageSize must be between 1 and 100")
+            return
+        }
+        pageSize = n
+    }
+    offset := 0
+    if value := query.Get("offset"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 0 {
+            writeError(w, http.StatusUnprocessableEntity, "LIST_RECORDS_ITERATOR_NOT_AVAILABLE", "")
+            return
+        }
+        offset = n
+    }
+    only := query["fields[]"]
+
+    ids := make([]string, 0, len(h.records))
+    for id := range h.records {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+
+    page := []airtable.Record{}
+    for i := offset; i < len(ids) && i < offset+pageSize; i++ {
+        fields := make(map[string]interface{})
+        for name, value := range h.records[ids[i]] {
+            // Airtable leaves empty cells out of responses
+            if value == nil || reflect.ValueOf(value).IsZero() {
+                continue
+            }
+            if len(only) > 0 && !containsString(only, name) {
+                continue
+            }
+            fields[name] = value
+        }
+        page = append(page, airtable.Record{ID: ids[i], Fields: fields})
+    }
+    response := map[string]interface{}{"records": page}
+    if offset+pageSize < len(ids) {
+        response["offset"] = strconv.Itoa(offset + pageSize)
+    }
+    writeJSON(w, http.StatusOK, response)
+}
+
+// write handles create (POST), update by ID and upsert (PATCH). Like the
+// real API it is all or nothing: one bad record fails the whole request.
+func (h *Handler) write(w http.ResponseWriter, r *http.Request) {
+    var body struct {
+        Records       []airtable.Record `json:"records"`
+        PerformUpsert *struct {
+            FieldsToMergeOn []string `json:"fieldsToMergeOn"`
+        } `json:"performUpsert"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+        writeError(w, http.StatusUnprocessableEntity, "INVALID_REQUEST_BODY", err.Error())
+        return
+    }
+    if len(body.Records) > h.stats.MaxBatch {
+        h.stats.MaxBatch = len(body.Records)
+    }
+    if len(body.Records) == 0 || len(body.Records) > airtable.MaxRecordsPerRequest {
+        h.reject(w, "INVALID_RECORDS", fmt.Sprintf("You must provide an array of up to %d record objects", airtable.MaxRecordsPerRequest))
+        return
+    }
+
+    for _, record := range body.Records {
+        if errType, msg := h.validate(record.Fields); errType != "" {
+            h.reject(w, errType, msg)
+            return
+        }
+    }
+
+    var mergeOn []string
+    if body.PerformUpsert != nil {
+        mergeOn = body.PerformUpsert.FieldsToMergeOn
+        if len(mergeOn) == 0 || len(mergeOn) > 3 {
+            h.reject(w, "INVALID_REQUEST_BODY", "fieldsToMergeOn must list 1 to 3 fields")
+            return
+        }
+    }
+
+    // Resolve every target before changing anything
+    targets := make([]string, len(body.Records))
+    for i, record := range body.Records {
+        switch {
+        case mergeOn != nil:
+            matches := h.match(record.Fields, mergeOn)
+            if len(matches) > 1 {
+                h.reject(w, "INVALID_MULTIPLE_MATCHES", fmt.Sprintf("More than one record matches fieldsToMergeOn %v", mergeOn))
+                return
+            }
+            if len(matches) == 1 {
+                targets[i] = matches[0]
+            }
+        case r.Method == http.MethodPatch:
+            if _, ok := h.records[record.ID]; !ok {
+                h.reject(w, "ROW_DOES_NOT_EXIST", fmt.Sprintf("Record ID %q does not exist", record.ID))
+                return
+            }
+            targets[i] = record.ID
+        }
+    }
+
+    var created, updated []string
+    result := make([]airtable.Record, len(body.Records))
+    for i, record := range body.Records {
+        id := targets[i]
+        if id == "" {
+            id = h.newID()
+            h.records[id] = make(map[string]interface{})
+            created = append(created, id)
+        } else {
+            updated = append(updated, id)
+        }
+        for name, value := range record.Fields {
+            h.records[id][name] = value
+        }
+        result[i] = airtable.Record{ID: id, Fields: copyFields(h.records[id])}
+    }
+    h.stats.RecordsWritten += len(body.Records)
+
+    response := map[string]interface{}{"records": result}
+    if mergeOn != nil {
+        response["createdRecords"] = nonNil(created)
+        response["updatedRecords"] = nonNil(updated)
+    }
+    writeJSON(w, http.StatusOK, response)
+}
+
+// validate checks fields against the schema and the Validate hook.
+func (h *Handler) validate(fields map[string]interface{}) (errType, msg string) {
+    if h.opts.Schema != nil {
+        names := make([]string, 0, len(fields))
+        for name := range fields {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+            fieldType, ok := h.opts.Schema[name]
+            if !ok {
+                return "UNKNOWN_FIELD_NAME", fmt.Sprintf("Unknown field name: %q", name)
+            }
+            if !validValue(fieldType, fields[name]) {
+                return "INVALID_VALUE_FOR_COLUMN", fmt.Sprintf("Field %q cannot accept the provided value", name)
+            }
+        }
+    }
+    if h.opts.Validate != nil {
+        if msg := h.opts.Validate(fields); msg != "" {
+            return "INVALID_VALUE_FOR_COLUMN", msg
+        }
+    }
+    return "", ""
+}
+
+func validValue(fieldType FieldType, value interface{}) bool {
+    if value == nil {
+        return true // clears the cell
+    }
+    switch fieldType {
+    case Text, LongText, URL:
+        _, ok := value.(string)
+        return ok
+    case Number:
+        _, ok := value.(float64)
+        return ok
+    case Checkbox:
+        _, ok := value.(bool)
+        return ok
+    case Date:
+        s, ok := value.(string)
+        if !ok {
+            return false
+        }
+        _, err := time.Parse("2006-01-02", s)
+        return s == "" || err == nil
+    }
+    return false
+}
+
+// match returns the IDs of rows whose mergeOn fields equal those in fields.
+func (h *Handler) match(fields map[string]interface{}, mergeOn []string) []string {
+    var ids []string
+    for id, existing := range h.records {
+        matched := true
+        for _, name := range mergeOn {
+            if !reflect.DeepEqual(existing[name], fields[name]) {
+                matched = false
+                break
+            }
+        }
+        if matched {
+            ids = append(ids, id)
+        }
+    }
+    sort.Strings(ids)
+    return ids
+}
+
+func (h *Handler) reject(w http.ResponseWriter, errType, msg string) {
+    h.stats.Rejected++
+    writeError(w, http.StatusUnprocessableEntity, errType, msg)
+}
+
+func (h *Handler) newID() string {
+    h.nextID++
+    return fmt.Sprintf("rec%014d", h.nextID)
+}
+
+// Server is a Handler running on a local httptest server.
+type Server struct {
+    *httptest.Server
+    *Handler
+}
+
+// NewServer starts a fake table. Close it when done.
+func NewServer(opts Options) *Server {
+    h := NewHandler(opts)
+    return &Server{Server: httptest.NewServer(h), Handler: h}
+}
+
+// TableURL is the URL to configure as the Airtable table.
+func (s *Server) TableURL() string {
+    return s.URL + "/v0/appFAKE/Products"
+}
+
+func writeError(w http.ResponseWriter, status int, errType, msg string) {
+    writeJSON(w, status, map[string]interface{}{"error": map[string]string{"type": errType, "message": msg}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
+func copyFields(fields map[string]interface{}) map[string]interface{} {
+    out := make(map[string]interface{}, len(fields))
+    for name, value := range fields {
+        out[name] = value
+    }
+    return out
+}
+
+func containsString(list []string, s string) bool {
+    for _, item := range list {
+        if item == s {
+            return true
+        }
+    }
+    return false
+}
+
+func nonNil(ids []string) []string {
+    if ids == nil {
+        return []string{}
+    }
+    return ids
+}
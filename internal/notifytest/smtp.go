@@ -0,0 +1,157 @@
+package notifytest
+
+import (
+    "bytes"
+    "io"
+    "mime"
+    "mime/quotedprintable"
+    "net"
+    "net/mail"
+    "net/textproto"
+    "strings"
+    "sync"
+)
+
+// Mail is one message the SMTP stand-in accepted.
+type Mail struct {
+    From string
+    To   []string
+    Data []byte
+}
+
+// Decode returns the subject and plain-text body of m.
+func (m Mail) Decode() (subject, body string, err error) {
+    msg, err := mail.ReadMessage(bytes.NewReader(m.Data))
+    if err != nil {
+        return "", "", err
+    }
+    if subject, err = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); err != nil {
+        return "", "", err
+    }
+    var r io.Reader = msg.Body
+    if strings.EqualFold(msg.Header.Get("Content-Transfer-Encoding"), "quoted-printable") {
+        r = quotedprintable.NewReader(r)
+    }
+    data, err := io.ReadAll(r)
+    return subject, strings.ReplaceAll(string(data), "\r\n", "\n"), err
+}
+
+// SMTPServer is a minimal SMTP server on a local port. It accepts any
+// sender and recipient and offers neither STARTTLS nor AUTH.
+type SMTPServer struct {
+    listener net.Listener
+
+    mu        sync.Mutex
+    mails     []Mail
+    tempFails int
+    wg        sync.WaitGroup
+}
+
+// NewSMTPServer starts an SMTP stand-in that answers the first tempFails
+// messages with a temporary 451 failure. Close it when done.
+func NewSMTPServer(tempFails int) (*SMTPServer, error) {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        return nil, err
+    }
+    s := &SMTPServer{listener: l, tempFails: tempFails}
+    s.wg.Add(1)
+    go s.accept()
+    return s, nil
+}
+
+// Addr is the host:port to send to.
+func (s *SMTPServer) Addr() string {
+    return s.listener.Addr().String()
+}
+
+// Mails returns the accepted messages in arrival order.
+func (s *SMTPServer) Mails() []Mail {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]Mail(nil), s.mails...)
+}
+
+// Close stops the server.
+func (s *SMTPServer) Close() {
+    s.listener.Close()
+    s.wg.Wait()
+}
+
+func (s *SMTPServer) accept() {
+    defer s.wg.Done()
+    for {
+        conn, err := s.listener.Accept()
+        if err != nil {
+            return
+        }
+        s.wg.Add(1)
+        go func() {
+            defer s.wg.Done()
+            defer conn.Close()
+            s.session(textproto.NewConn(conn))
+        }()
+    }
+}
+
+// session serves one connection until QUIT or an error.
+func (s *SMTPServer) session(c *textproto.Conn) {
+    c.PrintfLine("220 notifytest ESMTP")
+    var current Mail
+    for {
+        line, err := c.ReadLine()
+        if err != nil {
+            return
+        }
+        verb, arg, _ := strings.Cut(line, " ")
+        switch strings.ToUpper(verb) {
+        case "EHLO":
+            c.PrintfLine("250-notifytest")
+            c.PrintfLine("250 8BITMIME")
+        case "HELO", "NOOP":
+            c.PrintfLine("250 OK")
+        case "RSET":
+            current = Mail{}
+            c.PrintfLine("250 OK")
+        case "MAIL":
+            current = Mail{From: address(arg)}
+            c.PrintfLine("250 OK")
+        case "RCPT":
+            current.To = append(current.To, address(arg))
+            c.PrintfLine("250 OK")
+        case "DATA":
+            c.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+            data, err := c.ReadDotBytes()
+            if err != nil {
+                return
+            }
+            current.Data = data
+            s.mu.Lock()
+            fail := s.tempFails > 0
+            if fail {
+                s.tempFails--
+            } else {
+                s.mails = append(s.mails, current)
+            }
+            s.mu.Unlock()
+            if fail {
+                c.PrintfLine("451 Try again later")
+            } else {
+                c.PrintfLine("250 OK")
+            }
+            current = Mail{}
+        case "QUIT":
+            c.PrintfLine("221 Bye")
+            return
+        default:
+            c.PrintfLine("502 Command not implemented")
+        }
+    }
+}
+
+// address extracts the address from "FROM:<a@b>" or "TO:<a@b>".
+func address(arg string) string {
+    _, addr, _ := strings.Cut(arg, ":")
+    addr, _, _ = strings.Cut(strings.TrimSpace(addr), " ")
+    return strings.Trim(addr, "<>")
+}
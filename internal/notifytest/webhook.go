@@ -0,0 +1,102 @@
+// Package notifytest provides stand-ins for the destinations notify
+// delivers to: an HTTP endpoint for webhooks and chat webhooks that checks
+// signatures, and an SMTP server. Both record what they receive and can be
+// told to fail.
+package notifytest
+
+import (
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "sync"
+
+    "theWhiskyExchangeCrawler/notify"
+)
+
+// Options configure a webhook stand-in.
+type Options struct {
+    // Secret, when set, must have signed every delivery or it is rejected
+    // with 401.
+    Secret string
+    // Faults answers the n-th request (counting from 1) with the given
+    // status instead of accepting it, to exercise retries.
+    Faults map[int]int
+}
+
+// Request is one accepted delivery.
+type Request struct {
+    Path   string
+    Header http.Header
+    Body   []byte
+}
+
+// WebhookServer accepts POSTs on any path.
+type WebhookServer struct {
+    *httptest.Server
+    opts Options
+
+    mu       sync.Mutex
+    seen     int
+    requests []Request
+    rejected int
+}
+
+// NewWebhookServer starts a webhook stand-in. Close it when done.
+func NewWebhookServer(opts Options) *WebhookServer {
+    s := &WebhookServer{opts: opts}
+    s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+    return s
+}
+
+func (s *WebhookServer) serve(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "POST only", http.StatusMethodNotAllowed)
+        return
+    }
+    body, err := io.ReadAll(r.Body)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.seen++
+    if status, ok := s.opts.Faults[s.seen]; ok {
+        w.Header().Set("Retry-After", "0")
+        http.Error(w, http.StatusText(status), status)
+        return
+    }
+    if s.opts.Secret != "" {
+        ts, err := strconv.ParseInt(r.Header.Get(notify.TimestampHeader), 10, 64)
+        if err != nil || !notify.Verify(s.opts.Secret, r.Header.Get(notify.SignatureHeader), ts, body) {
+            s.rejected++
+            http.Error(w, "bad signature", http.StatusUnauthorized)
+            return
+        }
+    }
+    s.requests = append(s.requests, Request{Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// Requests returns the accepted deliveries in arrival order.
+func (s *WebhookServer) Requests() []Request {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]Request(nil), s.requests...)
+}
+
+// Seen returns how many requests arrived, failed ones included.
+func (s *WebhookServer) Seen() int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.seen
+}
+
+// Rejected returns how many deliveries had a bad signature.
+func (s *WebhookServer) Rejected() int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.rejected
+}
@@ -0,0 +1,63 @@
+package twetest
+
+import (
+    "fmt"
+    "html/template"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// serveProductPage answers /p/{id} with a product page laid out like the
+// live site's: a facts list, tasting notes and schema.org JSON-LD.
+func (h *Handler) serveProductPage(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/p/"))
+    if err != nil || !h.isListed(id) || contains(h.opts.MissingProducts, id) {
+        http.NotFound(w, r)
+        return
+    }
+    w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+    productPage.Execute(w, ProductDetails(id))
+}
+
+// ProductDetails returns the details the fake product page for id carries,
+// in the form the crawler should extract them.
+func ProductDetails(id int) map[string]string {
+    attrs := Attributes(id)
+    return map[string]string{
+        "Name":        fmt.Sprintf("Fake Distillery %d Year Old", 10+id%15),
+        "Nose":        fmt.Sprintf("Peat smoke and sea spray, note %d.", id),
+        "Palate":      "Honeyed malt with a hint of oak.",
+        "Finish":      "Long and warming.",
+        "Distillery":  fmt.Sprintf("Fake Distillery %d", id%5),
+        "Region":      attrs["Region"][0],
+        "CaskType":    strings.Join(attrs["CaskType"], ", "),
+        "Bottler":     attrs["Bottler"][0],
+        "Vintage":     strconv.Itoa(2000 + id%20),
+        "Rating":      fmt.Sprintf("%.1f", 3+float64(id%5)/2),
+        "ReviewCount": strconv.Itoa(id * 3),
+        "GTIN":        fmt.Sprintf("50%011d", id),
+    }
+}
+
+var productPage = template.Must(template.New("product").Parse(`<!DOCTYPE html>
+<html lang="en"><head><title>{{.Name}} | The Fake Whisky Exchange</title>
+<script type="application/ld+json">{"@context":"https://schema.org","@graph":[
+{"@type":"BreadcrumbList","itemListElement":[]},
+{"@type":"Product","name":"{{.Name}}","gtin13":"{{.GTIN}}","aggregateRating":{"@type":"AggregateRating","ratingValue":"{{.Rating}}","reviewCount":{{.ReviewCount}}}}]}</script>
+</head><body>
+<h1 class="product-main__name">{{.Name}}</h1>
+<ul class="product-facts__list">
+<li class="product-facts__item"><h3 class="product-facts__type">Distillery</h3><p class="product-facts__data">{{.Distillery}}</p></li>
+<li class="product-facts__item"><h3 class="product-facts__type">Region</h3><p class="product-facts__data">{{.Region}}</p></li>
+<li class="product-facts__item"><h3 class="product-facts__type">Cask Type</h3><p class="product-facts__data">{{.CaskType}}</p></li>
+<li class="product-facts__item"><h3 class="product-facts__type">Bottler</h3><p class="product-facts__data">{{.Bottler}}</p></li>
+<li class="product-facts__item"><h3 class="product-facts__type">Vintage</h3><p class="product-facts__data">{{.Vintage}}</p></li>
+</ul>
+<div class="product-tasting-notes">
+<p><strong>Nose:</strong> {{.Nose}}</p>
+<p><strong>Palate:</strong> {{.Palate}}</p>
+<p><strong>Finish:</strong> {{.Finish}}</p>
+</div>
+</body></html>
+`))
@@ -0,0 +1,123 @@
+package twetest
+
+import (
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// Facets lists the filters the fake catalogue supports, in the order
+// FiltersData reports them.
+var Facets = []string{"Country", "Region", "Age", "CaskType", "Bottler", "Style"}
+
+// facetLabels are the names the site shows for facets whose filter name
+// differs.
+var facetLabels = map[string]string{"CaskType": "Cask Type"}
+
+// Attributes returns the facet values of product id. Like on the live site,
+// they are only visible through filtering, never in the product array. Some
+// products have two cask types.
+func Attributes(id int) map[string][]string {
+    attrs := map[string][]string{
+        "Country": {"Scotland"},
+        "Region":  {[]string{"Islay", "Speyside", "Highlands", "Campbeltown"}[id%4]},
+        "Age":     {strconv.Itoa(10 + id%15)},
+        "Bottler": {"Distillery Bottling"},
+        "Style":   {"Unpeated"},
+    }
+    if id%5 == 4 {
+        attrs["Country"] = []string{"Japan"}
+        attrs["Region"] = []string{"Hokkaido"}
+    }
+    switch id % 3 {
+    case 0:
+        attrs["CaskType"] = []string{"Sherry"}
+    case 1:
+        attrs["CaskType"] = []string{"Ex-Bourbon"}
+    default:
+        attrs["CaskType"] = []string{"Ex-Bourbon", "Sherry"}
+    }
+    if id%4 == 0 {
+        attrs["Bottler"] = []string{"Independent Bottlers Ltd"}
+    }
+    if attrs["Region"][0] == "Islay" {
+        attrs["Style"] = []string{"Peated"}
+    }
+    return attrs
+}
+
+// catalogue returns the ProductIDs listed at revision rev that match the
+// facet and price filters set in f. Other filters are ignored.
+func (h *Handler) catalogue(f twe.FilteringCriterias, rev int) []int {
+    filters := reflect.ValueOf(f)
+    var ids []int
+    for _, id := range h.listed(rev) {
+        attrs := Attributes(id)
+        matches := true
+        for _, facet := range Facets {
+            if want := filters.FieldByName(facet).String(); want != "" && !containsString(attrs[facet], want) {
+                matches = false
+                break
+            }
+        }
+        if matches && inPriceRange(f.Price, PriceAt(id, rev)) {
+            ids = append(ids, id)
+        }
+    }
+    return ids
+}
+
+// inPriceRange reports whether price falls in the "min-max" Price filter,
+// which is unset when nil or empty.
+func inPriceRange(filter interface{}, price float64) bool {
+    band, _ := filter.(string)
+    if band == "" {
+        return true
+    }
+    min, max, err := twe.ParsePriceRange(band)
+    if err != nil {
+        return false
+    }
+    return price >= min && (strings.HasSuffix(band, "-") || price <= max)
+}
+
+// filtersData counts the facet values among ids, in the shape the endpoint
+// sends as FiltersData.
+func filtersData(ids []int) []map[string]interface{} {
+    data := make([]map[string]interface{}, 0, len(Facets))
+    for _, facet := range Facets {
+        counts := make(map[string]int)
+        for _, id := range ids {
+            for _, value := range Attributes(id)[facet] {
+                counts[value]++
+            }
+        }
+        values := make([]string, 0, len(counts))
+        for value := range counts {
+            values = append(values, value)
+        }
+        sort.Strings(values)
+        options := make([]map[string]interface{}, 0, len(values))
+        for _, value := range values {
+            options = append(options, map[string]interface{}{"Value": value, "Name": value, "Count": counts[value]})
+        }
+        label := facetLabels[facet]
+        if label == "" {
+            label = facet
+        }
+        data = append(data, map[string]interface{}{"FilterName": facet, "DisplayName": label, "Options": options})
+    }
+    return data
+}
+
+func containsString(values []string, want string) bool {
+    for _, value := range values {
+        if value == want {
+            return true
+        }
+    }
+    return false
+}
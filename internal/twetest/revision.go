@@ -0,0 +1,93 @@
+package twetest
+
+import "fmt"
+
+// newArrivals is how many products join the catalogue from revision 1.
+const newArrivals = 3
+
+// SetRevision switches the catalogue to revision r from the next request
+// on. Revision 0 is the catalogue NewHandler starts with. From revision 1
+// it has moved on the way a shop does between crawls, so change detection
+// has something to find:
+//
+//   - every thirteenth product is delisted and three new ones arrive after
+//     the last;
+//   - every fifth product is £5 cheaper;
+//   - products that were out of stock are restocked, those with three left
+//     sell out and those with one left get four more;
+//   - every eleventh product is renamed.
+func (h *Handler) SetRevision(r int) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.revision = r
+}
+
+// listed returns the ProductIDs in the catalogue at revision rev.
+func (h *Handler) listed(rev int) []int {
+    size := h.opts.TotalPages * h.opts.PageSize
+    var ids []int
+    for id := 1; id <= size; id++ {
+        if rev == 0 || id%13 != 0 {
+            ids = append(ids, id)
+        }
+    }
+    if rev > 0 {
+        for id := size + 1; id <= size+newArrivals; id++ {
+            ids = append(ids, id)
+        }
+    }
+    return ids
+}
+
+// isListed reports whether product id is in the current catalogue.
+func (h *Handler) isListed(id int) bool {
+    h.mu.Lock()
+    rev := h.revision
+    h.mu.Unlock()
+    for _, listed := range h.listed(rev) {
+        if listed == id {
+            return true
+        }
+    }
+    return false
+}
+
+// Price is the SalesPrice of product id at revision 0.
+func Price(id int) float64 {
+    return PriceAt(id, 0)
+}
+
+// PriceAt is the SalesPrice of product id at revision rev.
+func PriceAt(id, rev int) float64 {
+    price := 20 + float64(id%80) + 0.95
+    if rev > 0 && id%5 == 0 {
+        price -= 5
+    }
+    return price
+}
+
+func name(id, rev int) string {
+    name := fmt.Sprintf("Fake Distillery %d Year Old", 10+id%15)
+    if rev > 0 && id%11 == 0 {
+        name += " (New Edition)"
+    }
+    return name
+}
+
+// stockLevel is the StockLevel of product id at revision rev; the product
+// is out of stock at 0.
+func stockLevel(id, rev int) int {
+    level := id % 7
+    if rev == 0 {
+        return level
+    }
+    switch level {
+    case 0:
+        return 4
+    case 1:
+        return 5
+    case 3:
+        return 0
+    }
+    return level
+}
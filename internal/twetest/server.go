@@ -0,0 +1,229 @@
+// Package twetest provides a fake productlistdata endpoint, and the product
+// pages behind it, for testing the crawler without network access or
+// session cookies.
+package twetest
+
+import (
+    "bytes"
+    "compress/gzip"
+    "compress/zlib"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+
+    "github.com/andybalholm/brotli"
+    "github.com/klauspost/compress/zstd"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// Options shape the fake catalogue and how it misbehaves.
+type Options struct {
+    TotalPages int // default 3
+    PageSize   int // products per page, default 5
+
+    // Encoding is the Content-Encoding of every response: "" (identity),
+    // "gzip", "deflate", "br" or "zstd".
+    Encoding string
+
+    // MalformedPages are answered with truncated JSON.
+    MalformedPages []int
+    // ChallengePages are answered with a Cloudflare challenge page.
+    ChallengePages []int
+    // APIToken, when set, must match the Apitoken header or the request is
+    // rejected with 401.
+    APIToken string
+    // MissingProducts are ProductIDs whose product page answers 404.
+    MissingProducts []int
+    // ResultCap, when set, truncates every listing to its first ResultCap
+    // products, like a search that silently stops counting.
+    ResultCap int
+    // Revision, when above 0, serves the catalogue as it looks some time
+    // later; see SetRevision.
+    Revision int
+}
+
+// Handler serves the fake endpoint at the productlistdata path and the
+// product pages at /p/{id}.
+type Handler struct {
+    opts Options
+
+    mu       sync.Mutex
+    hits     map[int]int
+    revision int
+}
+
+// NewHandler returns a Handler for opts, filling in defaults.
+func NewHandler(opts Options) *Handler {
+    if opts.TotalPages <= 0 {
+        opts.TotalPages = 3
+    }
+    if opts.PageSize <= 0 {
+        opts.PageSize = 5
+    }
+    return &Handler{opts: opts, hits: make(map[int]int), revision: opts.Revision}
+}
+
+// Hits returns how many times page was requested.
+func (h *Handler) Hits(page int) int {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    return h.hits[page]
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/p/") {
+        h.serveProductPage(w, r)
+        return
+    }
+    if r.Method != http.MethodPost || r.URL.Path != "/api/product/productlistdata" {
+        http.NotFound(w, r)
+        return
+    }
+    if h.opts.APIToken != "" && r.Header.Get("Apitoken") != `"`+h.opts.APIToken+`"` {
+        http.Error(w, `{"Message":"Authorization has been denied for this request."}`, http.StatusUnauthorized)
+        return
+    }
+
+    var payload twe.RequestPayload
+    if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    page := payload.Model.DisplaySettings.PageNumber
+
+    h.mu.Lock()
+    h.hits[page]++
+    rev := h.revision
+    h.mu.Unlock()
+
+    if contains(h.opts.ChallengePages, page) {
+        w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+        w.Header().Set("Cf-Mitigated", "challenge")
+        w.WriteHeader(http.StatusForbidden)
+        io.WriteString(w, challengePage)
+        return
+    }
+
+    ids := h.catalogue(payload.Model.FilteringCriterias, rev)
+    if h.opts.ResultCap > 0 && len(ids) > h.opts.ResultCap {
+        ids = ids[:h.opts.ResultCap]
+    }
+    totalPages := (len(ids) + h.opts.PageSize - 1) / h.opts.PageSize
+    body, err := json.Marshal(map[string]interface{}{
+        "CurrentPage": page,
+        "TotalPages":  totalPages,
+        "Products":    h.products(ids, page, rev),
+        "FiltersData": filtersData(ids),
+    })
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if contains(h.opts.MalformedPages, page) {
+        body = body[:len(body)/2]
+    }
+
+    encoded, err := encode(h.opts.Encoding, body)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json; charset=utf-8")
+    if h.opts.Encoding != "" {
+        w.Header().Set("Content-Encoding", h.opts.Encoding)
+    }
+    w.Write(encoded)
+}
+
+// products builds a deterministic page of the products in ids as they are
+// at revision rev.
+func (h *Handler) products(ids []int, page, rev int) []map[string]interface{} {
+    products := make([]map[string]interface{}, 0, h.opts.PageSize)
+    for i := (page - 1) * h.opts.PageSize; page >= 1 && i < page*h.opts.PageSize && i < len(ids); i++ {
+        id := ids[i]
+        price := PriceAt(id, rev)
+        stock := stockLevel(id, rev)
+        products = append(products, map[string]interface{}{
+            "ProductID":          id,
+            "Name":               name(id, rev),
+            "Description":        "A fake whisky for offline testing.",
+            "SalesPrice":         price,
+            "SalesPriceExVat":    fmt.Sprintf("%.2f", price/1.2),
+            "StrengthInPC":       40 + float64(id%20),
+            "SizeInCL":           70,
+            "ProductImageUrl":    fmt.Sprintf("https://img.example.invalid/%d.jpg", id),
+            "IsActive":           true,
+            "MaxOrderQuantity":   6,
+            "Manufacturer":       "Fake Distillers Ltd",
+            "Brand":              fmt.Sprintf("Fake Brand %d", id%4),
+            "MasterCategoryName": "Whisky",
+            "CategoryName":       "Single Malt Scotch Whisky",
+            "Weight":             1.4,
+            "StockLevel":         stock,
+            "StockControl":       1,
+            "IsOutOfStock":       stock == 0,
+        })
+    }
+    return products
+}
+
+// Server is a Handler running on a local httptest server. Use its URL as
+// the crawler's base URL.
+type Server struct {
+    *httptest.Server
+    *Handler
+}
+
+// NewServer starts a fake endpoint. Close it when done.
+func NewServer(opts Options) *Server {
+    h := NewHandler(opts)
+    return &Server{Server: httptest.NewServer(h), Handler: h}
+}
+
+func encode(encoding string, body []byte) ([]byte, error) {
+    var buf bytes.Buffer
+    var w io.WriteCloser
+    switch encoding {
+    case "", "identity":
+        return body, nil
+    case "gzip":
+        w = gzip.NewWriter(&buf)
+    case "deflate":
+        w = zlib.NewWriter(&buf)
+    case "br":
+        w = brotli.NewWriter(&buf)
+    case "zstd":
+        zw, err := zstd.NewWriter(&buf)
+        if err != nil {
+            return nil, err
+        }
+        w = zw
+    default:
+        return nil, fmt.Errorf("unsupported encoding %q", encoding)
+    }
+    if _, err := w.Write(body); err != nil {
+        return nil, err
+    }
+    if err := w.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+func contains(pages []int, page int) bool {
+    for _, p := range pages {
+        if p == page {
+            return true
+        }
+    }
+    return false
+}
+
+const challengePage = `<!DOCTYPE html><html lang="en-US"><head><title>Just a moment...</title></head>
+<body><div id="challenge-running">Checking if the site connection is secure</div>
+<script src="/cdn-cgi/challenge-platform/h/b/orchestrate/chl_page/v1"></script></body></html>`
@@ -0,0 +1,149 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "text/template"
+    "time"
+
+    "theWhiskyExchangeCrawler/config"
+    "theWhiskyExchangeCrawler/notify"
+    "theWhiskyExchangeCrawler/twe"
+    "theWhiskyExchangeCrawler/watch"
+)
+
+// crawlNotifier sends each crawl's digest to the configured channels. A nil
+// *crawlNotifier means none are configured and send does nothing. Like the
+// history, failed deliveries are only logged.
+type crawlNotifier struct {
+    channels []notify.Channel
+    changes  bool
+}
+
+// newCrawlNotifier builds the channels of cfg, returning nil when there
+// are none. It fails on templates that do not parse.
+func newCrawlNotifier(cfg config.NotifyConfig) (*crawlNotifier, error) {
+    retry := notify.Retry{MaxRetries: cfg.MaxRetries, Delay: cfg.RetryDelay}
+    client := &http.Client{Timeout: 30 * time.Second}
+    n := &crawlNotifier{changes: cfg.Changes}
+    for _, w := range cfg.Webhooks {
+        n.channels = append(n.channels, &notify.Webhook{URL: w.URL.Reveal(), Secret: w.Secret.Reveal(), Retry: retry, Client: client})
+    }
+    for i, c := range cfg.Chat {
+        tmpl, err := notify.ParseTemplate(c.Template)
+        if err != nil {
+            return nil, fmt.Errorf("notify.chat[%d].template: %w", i, err)
+        }
+        format := c.Format
+        if format == "" {
+            format = notify.Slack
+        }
+        n.channels = append(n.channels, &notify.Chat{URL: c.URL.Reveal(), Format: format, Template: tmpl, Limit: cfg.ChangeLimit, Retry: retry, Client: client})
+    }
+    if e := cfg.Email; e.Addr != "" {
+        body, err := notify.ParseTemplate(e.Template)
+        if err != nil {
+            return nil, fmt.Errorf("notify.email.template: %w", err)
+        }
+        subject := e.Subject
+        if subject == "" {
+            subject = notify.DefaultSubject
+        }
+        subjectTmpl, err := template.New("subject").Parse(subject)
+        if err != nil {
+            return nil, fmt.Errorf("notify.email.subject: %w", err)
+        }
+        n.channels = append(n.channels, &notify.Email{
+            Addr: e.Addr, Username: e.Username, Password: e.Password.Reveal(), From: e.From, To: e.To,
+            Subject: subjectTmpl, Body: body, Limit: cfg.ChangeLimit, Retry: retry,
+        })
+    }
+    if len(n.channels) == 0 {
+        return nil, nil
+    }
+    return n, nil
+}
+
+// send delivers the changes and alerts of run to every channel, unless
+// there are none.
+func (n *crawlNotifier) send(ctx context.Context, run int64, query string, changes changeReport, alerts []watch.Alert) {
+    if n == nil {
+        return
+    }
+    d := notify.Digest{Run: run, Query: query, Time: time.Now().UTC(), Alerts: alerts}
+    if n.changes {
+        d.Since, d.Counts, d.Changes = changes.From.Run, changes.Counts, changes.Changes
+    }
+    if d.Alerts == nil {
+        d.Alerts = []watch.Alert{}
+    }
+    if d.Changes == nil {
+        d.Counts, d.Changes = map[twe.ChangeKind]int{}, []twe.ProductChange{}
+    }
+    if d.Empty() {
+        fmt.Fprintln(stdout, "Nothing to notify.")
+        return
+    }
+    n.deliver(ctx, d)
+}
+
+// deliver sends d to every channel and reports how many it failed.
+func (n *crawlNotifier) deliver(ctx context.Context, d notify.Digest) int {
+    failed := 0
+    for _, ch := range n.channels {
+        if err := ch.Send(ctx, d); err != nil {
+            log.Printf("Warning: cannot notify %s: %v", ch.Name(), err)
+            failed++
+            continue
+        }
+        fmt.Fprintf(stdout, "Notified %s: %s\n", ch.Name(), d.Title())
+    }
+    return failed
+}
+
+// runNotifyTest sends a made-up digest to every configured channel, to
+// check the configuration and templates without waiting for a crawl to
+// find something.
+func runNotifyTest(args []string) {
+    fs := flag.NewFlagSet("notify-test", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: theWhiskyExchangeCrawler notify-test [flags]")
+        fs.PrintDefaults()
+    }
+    configFlags := config.RegisterFlags(fs)
+    fs.Parse(args)
+
+    cfg := loadConfig(configFlags)
+    n, err := newCrawlNotifier(cfg.Notify)
+    if err != nil {
+        fmt.Fprintln(stderr, "Configuration error:", err)
+        os.Exit(exitUsage)
+    }
+    if n == nil {
+        fmt.Fprintln(stderr, "No notification channels configured (notify.webhooks, notify.chat, notify.email).")
+        os.Exit(exitUsage)
+    }
+
+    before := 54.95
+    percent := -18.2
+    sample := twe.Product{ProductID: "0", Name: "Test Distillery 12 Year Old (test notification)", SalesPrice: 44.95, StockLevel: 6}
+    d := notify.Digest{
+        Query:  "a test notification",
+        Time:   time.Now().UTC(),
+        Counts: map[twe.ChangeKind]int{twe.ChangePriceDown: 1, twe.ChangeRestocked: 1},
+        Changes: []twe.ProductChange{
+            {Kind: twe.ChangePriceDown, ProductID: sample.ProductID, Name: sample.Name, Old: before, New: sample.SalesPrice, Delta: -10, Percent: &percent, Product: sample},
+            {Kind: twe.ChangeRestocked, ProductID: sample.ProductID, Name: sample.Name, Old: 0.0, New: sample.StockLevel, Product: sample},
+        },
+        Alerts: []watch.Alert{
+            {Watch: "test", Condition: watch.PriceBelow, Threshold: 50, ProductID: sample.ProductID, Name: sample.Name, Before: &before, After: sample.SalesPrice, Product: sample},
+        },
+    }
+    if failed := n.deliver(context.Background(), d); failed > 0 {
+        os.Exit(exitFailed)
+    }
+}
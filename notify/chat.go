@@ -0,0 +1,96 @@
+package notify
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "text/template"
+    "unicode/utf8"
+)
+
+// Chat formats understood by Chat.
+const (
+    Slack   = "slack"
+    Discord = "discord"
+)
+
+// chatLimits is the longest message, in characters, each format shows in
+// full. Longer digests are split into several messages at line breaks.
+var chatLimits = map[string]int{Slack: 4000, Discord: 2000}
+
+// Chat posts every digest as text to a Slack- or Discord-style incoming
+// webhook.
+type Chat struct {
+    URL    string
+    Format string // Slack or Discord
+    // Template renders the text; Limit is the number of changes of each
+    // kind it lists.
+    Template *template.Template
+    Limit    int
+    Retry    Retry
+    Client   *http.Client
+}
+
+// Name is the format and host; incoming webhook URLs are credentials.
+func (c *Chat) Name() string {
+    return c.Format + " " + host(c.URL)
+}
+
+// Send posts d, in as many messages as the format's length limit needs.
+func (c *Chat) Send(ctx context.Context, d Digest) error {
+    limit, ok := chatLimits[c.Format]
+    if !ok {
+        return fmt.Errorf("unknown chat format %q", c.Format)
+    }
+    text, err := render(c.Template, d, c.Limit)
+    if err != nil {
+        return err
+    }
+    field := "text"
+    if c.Format == Discord {
+        field = "content"
+    }
+    for _, part := range splitMessage(text, limit) {
+        body, err := json.Marshal(map[string]string{field: part})
+        if err != nil {
+            return err
+        }
+        if err := c.Retry.do(ctx, func() error { return post(ctx, c.Client, c.URL, nil, body) }); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// splitMessage cuts text into parts of at most limit characters, at line
+// breaks where it can.
+func splitMessage(text string, limit int) []string {
+    text = strings.TrimSpace(text)
+    var parts []string
+    var part strings.Builder
+    flush := func() {
+        if s := strings.TrimSpace(part.String()); s != "" {
+            parts = append(parts, s)
+        }
+        part.Reset()
+    }
+    for _, line := range strings.Split(text, "\n") {
+        for utf8.RuneCountInString(line) > limit {
+            flush()
+            cut := len(string([]rune(line)[:limit]))
+            parts = append(parts, line[:cut])
+            line = line[cut:]
+        }
+        if utf8.RuneCountInString(part.String())+1+utf8.RuneCountInString(line) > limit {
+            flush()
+        }
+        if part.Len() > 0 {
+            part.WriteByte('\n')
+        }
+        part.WriteString(line)
+    }
+    flush()
+    return parts
+}
@@ -0,0 +1,110 @@
+package notify
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+    "unicode/utf8"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+func TestSplitMessage(t *testing.T) {
+    tests := []struct {
+        name  string
+        text  string
+        limit int
+        want  []string
+    }{
+        {name: "empty", text: "", limit: 10},
+        {name: "blank", text: " \n\n ", limit: 10},
+        {name: "fits", text: "one\ntwo\n", limit: 10, want: []string{"one\ntwo"}},
+        {name: "exactly the limit", text: "12345\n789", limit: 9, want: []string{"12345\n789"}},
+        {name: "at line breaks", text: "aaaa\nbbbb\ncccc", limit: 9, want: []string{"aaaa\nbbbb", "cccc"}},
+        {name: "blank line at a cut", text: "aaaa\n\nbbbb", limit: 5, want: []string{"aaaa", "bbbb"}},
+        {name: "long line cut", text: "ab\n0123456789ABC\ncd", limit: 6, want: []string{"ab", "012345", "6789AB", "C\ncd"}},
+        {name: "counts characters not bytes", text: "££££\n££££", limit: 9, want: []string{"££££\n££££"}},
+        {name: "cuts between characters", text: "£££££££", limit: 3, want: []string{"£££", "£££", "£"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := splitMessage(tt.text, tt.limit)
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("splitMessage(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+            }
+            for _, part := range got {
+                if n := utf8.RuneCountInString(part); n > tt.limit || !utf8.ValidString(part) {
+                    t.Errorf("part %q has %d characters or is not valid UTF-8", part, n)
+                }
+            }
+        })
+    }
+}
+
+func TestChatSplitsLongDigests(t *testing.T) {
+    tests := []struct {
+        format string
+        field  string
+        limit  int
+    }{
+        {Slack, "text", 4000},
+        {Discord, "content", 2000},
+    }
+    var changes []twe.ProductChange
+    for id := 1; id <= 150; id++ {
+        changes = append(changes, twe.ProductChange{Kind: twe.ChangeNew, ProductID: fmt.Sprint(id), Name: strings.Repeat("Whisky ", 5), New: 50.0})
+    }
+    digest := Digest{Query: "default", Changes: changes, Counts: map[twe.ChangeKind]int{twe.ChangeNew: len(changes)}}
+    tmpl, err := ParseTemplate("")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.format, func(t *testing.T) {
+            var parts []string
+            srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                var body map[string]string
+                data, _ := io.ReadAll(r.Body)
+                if err := json.Unmarshal(data, &body); err != nil || len(body) != 1 {
+                    t.Errorf("body %s is not a single %s field", data, tt.field)
+                }
+                parts = append(parts, body[tt.field])
+            }))
+            defer srv.Close()
+
+            chat := &Chat{URL: srv.URL, Format: tt.format, Template: tmpl, Limit: len(changes)}
+            if err := chat.Send(context.Background(), digest); err != nil {
+                t.Fatalf("Send: %v", err)
+            }
+            if len(parts) < 2 {
+                t.Fatalf("digest sent in %d messages, want it split", len(parts))
+            }
+            for _, part := range parts {
+                if utf8.RuneCountInString(part) > tt.limit {
+                    t.Errorf("message of %d characters, limit %d", utf8.RuneCountInString(part), tt.limit)
+                }
+            }
+            joined := strings.Join(parts, "\n")
+            for _, c := range changes {
+                if !strings.Contains(joined, "- "+c.ProductID+" ") {
+                    t.Errorf("product %s missing from the messages", c.ProductID)
+                    break
+                }
+            }
+        })
+    }
+}
+
+func TestChatUnknownFormat(t *testing.T) {
+    chat := &Chat{URL: "http://127.0.0.1:1", Format: "teams"}
+    if err := chat.Send(context.Background(), Digest{}); err == nil || !strings.Contains(err.Error(), "teams") {
+        t.Errorf("Send error = %v, want the format named", err)
+    }
+}
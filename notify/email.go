@@ -0,0 +1,133 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "fmt"
+    "mime"
+    "mime/quotedprintable"
+    "net"
+    "net/smtp"
+    "strings"
+    "text/template"
+    "time"
+)
+
+// Email sends every digest as one plain-text email through an SMTP server,
+// upgrading to TLS when the server offers STARTTLS.
+type Email struct {
+    Addr     string // host:port
+    Username string // empty sends without authenticating
+    Password string
+    From     string
+    To       []string
+    // Subject and Body render the message; Limit is the number of changes
+    // of each kind Body lists.
+    Subject *template.Template
+    Body    *template.Template
+    Limit   int
+    Retry   Retry
+}
+
+// Name is the SMTP server.
+func (e *Email) Name() string {
+    return "email via " + e.Addr
+}
+
+// Send mails d to every recipient.
+func (e *Email) Send(ctx context.Context, d Digest) error {
+    subject, err := render(e.Subject, d, e.Limit)
+    if err != nil {
+        return err
+    }
+    body, err := render(e.Body, d, e.Limit)
+    if err != nil {
+        return err
+    }
+    msg, err := e.message(strings.TrimSpace(subject), body, d.Time)
+    if err != nil {
+        return err
+    }
+    return e.Retry.do(ctx, func() error { return e.send(ctx, msg) })
+}
+
+// message builds the RFC 5322 message.
+func (e *Email) message(subject, body string, date time.Time) ([]byte, error) {
+    if date.IsZero() {
+        date = time.Now()
+    }
+    var msg bytes.Buffer
+    fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+    fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+    fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+    fmt.Fprintf(&msg, "Date: %s\r\n", date.Format(time.RFC1123Z))
+    msg.WriteString("MIME-Version: 1.0\r\n")
+    msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+    msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+    qp := quotedprintable.NewWriter(&msg)
+    if _, err := qp.Write([]byte(body)); err != nil {
+        return nil, err
+    }
+    if err := qp.Close(); err != nil {
+        return nil, err
+    }
+    return msg.Bytes(), nil
+}
+
+// send delivers msg in one SMTP session.
+func (e *Email) send(ctx context.Context, msg []byte) error {
+    host, _, err := net.SplitHostPort(e.Addr)
+    if err != nil {
+        return &permanentError{err}
+    }
+    dialer := net.Dialer{Timeout: 30 * time.Second}
+    conn, err := dialer.DialContext(ctx, "tcp", e.Addr)
+    if err != nil {
+        return err
+    }
+    deadline := time.Now().Add(time.Minute)
+    if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+        deadline = d
+    }
+    conn.SetDeadline(deadline)
+
+    c, err := smtp.NewClient(conn, host)
+    if err != nil {
+        conn.Close()
+        return err
+    }
+    defer c.Close()
+    if ok, _ := c.Extension("STARTTLS"); ok {
+        if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+            return err
+        }
+    }
+    if e.Username != "" {
+        if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+            return err
+        }
+    }
+    if err := c.Mail(e.From); err != nil {
+        return err
+    }
+    for _, to := range e.To {
+        if err := c.Rcpt(to); err != nil {
+            return err
+        }
+    }
+    w, err := c.Data()
+    if err != nil {
+        return err
+    }
+    if _, err := w.Write(msg); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return err
+    }
+    // The message is accepted once DATA is; a failed QUIT must not send
+    // it again.
+    c.Quit()
+    return nil
+}
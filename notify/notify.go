@@ -0,0 +1,152 @@
+// Package notify delivers what a crawl found, its changes and watchlist
+// alerts, as one digest per run to HTTP webhooks, Slack- or Discord-style
+// chat webhooks and SMTP email, retrying failed deliveries.
+package notify
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/textproto"
+    "strings"
+    "time"
+
+    "theWhiskyExchangeCrawler/twe"
+    "theWhiskyExchangeCrawler/watch"
+)
+
+// Digest is everything one crawl has to report.
+type Digest struct {
+    // Run is the history run of the crawl and Since the earlier run its
+    // changes are measured against. Both are 0 without a history.
+    Run   int64     `json:"run,omitempty"`
+    Since int64     `json:"since,omitempty"`
+    Query string    `json:"query"`
+    Time  time.Time `json:"time"`
+
+    Counts  map[twe.ChangeKind]int `json:"counts"`
+    Changes []twe.ProductChange    `json:"changes"`
+    Alerts  []watch.Alert          `json:"alerts"`
+}
+
+// Empty reports whether d has nothing to say.
+func (d Digest) Empty() bool {
+    return len(d.Changes) == 0 && len(d.Alerts) == 0
+}
+
+// Title sums d up in one line, e.g. "2 alerts and 14 changes in the
+// catalogue".
+func (d Digest) Title() string {
+    var parts []string
+    if len(d.Alerts) > 0 {
+        parts = append(parts, plural(len(d.Alerts), "alert"))
+    }
+    if len(d.Changes) > 0 || len(d.Alerts) == 0 {
+        parts = append(parts, plural(len(d.Changes), "change"))
+    }
+    query := d.Query
+    if query == "" {
+        query = "the catalogue"
+    }
+    return strings.Join(parts, " and ") + " in " + query
+}
+
+func plural(n int, noun string) string {
+    if n == 1 {
+        return "1 " + noun
+    }
+    return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// ChangeGroup is the changes of one kind, as a template lists them.
+type ChangeGroup struct {
+    Kind    twe.ChangeKind
+    Heading string
+    Count   int
+    Changes []twe.ProductChange
+    // More is how many changes were left out of Changes.
+    More int
+}
+
+// Groups returns the changes of d by kind in report order, each cut to its
+// first limit changes; limit 0 keeps them all.
+func (d Digest) Groups(limit int) []ChangeGroup {
+    var groups []ChangeGroup
+    for _, kind := range twe.ChangeKinds {
+        g := ChangeGroup{Kind: kind, Heading: kind.Heading()}
+        for _, c := range d.Changes {
+            if c.Kind != kind {
+                continue
+            }
+            g.Count++
+            if limit == 0 || len(g.Changes) < limit {
+                g.Changes = append(g.Changes, c)
+            }
+        }
+        if g.Count > 0 {
+            g.More = g.Count - len(g.Changes)
+            groups = append(groups, g)
+        }
+    }
+    return groups
+}
+
+// Channel delivers digests to one destination.
+type Channel interface {
+    // Name identifies the channel in logs without revealing credentials.
+    Name() string
+    Send(ctx context.Context, d Digest) error
+}
+
+// Retry is how often and how soon a failed delivery is tried again.
+type Retry struct {
+    MaxRetries int
+    // Delay is the wait before the first retry; it doubles for each one
+    // after. A Retry-After from the server takes precedence.
+    Delay time.Duration
+}
+
+// do calls send until it succeeds, fails for good or runs out of retries.
+func (r Retry) do(ctx context.Context, send func() error) error {
+    for attempt := 0; ; attempt++ {
+        err := send()
+        if err == nil || attempt >= r.MaxRetries || !temporary(err) || ctx.Err() != nil {
+            return err
+        }
+        delay := r.Delay << uint(attempt)
+        var statusErr *StatusError
+        if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+            delay = statusErr.RetryAfter
+        }
+        timer := time.NewTimer(delay)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return err
+        case <-timer.C:
+        }
+    }
+}
+
+// temporary reports whether retrying may help: network errors, 429s, 5xx
+// answers and 4xx SMTP replies do.
+func temporary(err error) bool {
+    var statusErr *StatusError
+    if errors.As(err, &statusErr) {
+        return statusErr.Temporary()
+    }
+    var smtpErr *textproto.Error
+    if errors.As(err, &smtpErr) {
+        return smtpErr.Code < 500
+    }
+    var permanent *permanentError
+    return !errors.As(err, &permanent)
+}
+
+// permanentError marks failures that retrying cannot fix.
+type permanentError struct {
+    err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
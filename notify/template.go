@@ -0,0 +1,61 @@
+package notify
+
+import (
+    "os"
+    "strings"
+    "text/template"
+)
+
+// DefaultMessage is the built-in template of chat messages and email
+// bodies. Templates are executed with a Message.
+const DefaultMessage = `{{.Title}}
+{{- if .Alerts}}
+
+Alerts:
+{{- range .Alerts}}
+- [{{.Watch}}] {{.ProductID}} {{.Name}}: {{.Describe}}{{if .URL}} {{.URL}}{{end}}
+{{- end}}
+{{- end}}
+{{- range .Groups .Limit}}
+
+{{.Heading}} ({{.Count}}):
+{{- range .Changes}}
+- {{.ProductID}} {{.Describe}}
+{{- end}}
+{{- if .More}}
+- ... and {{.More}} more
+{{- end}}
+{{- end}}
+`
+
+// DefaultSubject is the built-in template of email subjects.
+const DefaultSubject = `The Whisky Exchange: {{.Title}}`
+
+// Message is what message templates are executed with: the digest and
+// how many changes of each kind to list.
+type Message struct {
+    Digest
+    Limit int
+}
+
+// ParseTemplate parses a message template from the file at path, or
+// returns DefaultMessage parsed when path is empty.
+func ParseTemplate(path string) (*template.Template, error) {
+    if path == "" {
+        return template.New("message").Parse(DefaultMessage)
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    return template.New(path).Parse(string(data))
+}
+
+// render executes t for d.
+func render(t *template.Template, d Digest, limit int) (string, error) {
+    var b strings.Builder
+    if err := t.Execute(&b, Message{Digest: d, Limit: limit}); err != nil {
+        return "", &permanentError{err}
+    }
+    return b.String(), nil
+}
@@ -0,0 +1,134 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+)
+
+// Headers of a webhook delivery. The signature is "sha256=" and the hex
+// HMAC-SHA256, keyed with the shared secret, of the timestamp, a dot and
+// the body.
+const (
+    EventHeader     = "X-TWE-Event"
+    TimestampHeader = "X-TWE-Timestamp"
+    SignatureHeader = "X-TWE-Signature"
+)
+
+// StatusError is a non-2xx answer to a delivery.
+type StatusError struct {
+    StatusCode int
+    Body       string
+    RetryAfter time.Duration // from the Retry-After header, if any
+}
+
+func (e *StatusError) Error() string {
+    if e.Body == "" {
+        return fmt.Sprintf("returned status %d", e.StatusCode)
+    }
+    return fmt.Sprintf("returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the same delivery may succeed later.
+func (e *StatusError) Temporary() bool {
+    return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Webhook posts every digest as JSON to a URL, signed when it has a
+// secret.
+type Webhook struct {
+    URL    string
+    Secret string
+    Retry  Retry
+    Client *http.Client
+}
+
+// Name is the webhook's host; its URL may carry credentials.
+func (w *Webhook) Name() string {
+    return "webhook " + host(w.URL)
+}
+
+// Send posts d.
+func (w *Webhook) Send(ctx context.Context, d Digest) error {
+    body, err := json.Marshal(d)
+    if err != nil {
+        return err
+    }
+    return w.Retry.do(ctx, func() error {
+        header := make(http.Header)
+        header.Set(EventHeader, "digest")
+        if w.Secret != "" {
+            // Signed afresh for each attempt, so receivers can reject stale
+            // timestamps.
+            ts := time.Now().Unix()
+            header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+            header.Set(SignatureHeader, Sign(w.Secret, ts, body))
+        }
+        return post(ctx, w.Client, w.URL, header, body)
+    })
+}
+
+// Sign returns the signature header value of body sent at timestamp ts.
+func Sign(secret string, ts int64, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(strconv.FormatInt(ts, 10) + "."))
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of body sent at
+// timestamp ts, for receivers of webhook deliveries.
+func Verify(secret, signature string, ts int64, body []byte) bool {
+    return hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body)))
+}
+
+// post sends one JSON body.
+func post(ctx context.Context, client *http.Client, target string, header http.Header, body []byte) error {
+    if client == nil {
+        client = http.DefaultClient
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+    if err != nil {
+        return &permanentError{err}
+    }
+    for name, values := range header {
+        req.Header[name] = values
+    }
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := client.Do(req)
+    if err != nil {
+        // The error quotes the URL, which may carry credentials.
+        if urlErr, ok := err.(*url.Error); ok {
+            err = urlErr.Err
+        }
+        return err
+    }
+    defer resp.Body.Close()
+    respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+    if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+        return nil
+    }
+    statusErr := &StatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(respBody))}
+    if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+        statusErr.RetryAfter = time.Duration(seconds) * time.Second
+    }
+    return statusErr
+}
+
+func host(rawURL string) string {
+    u, err := url.Parse(rawURL)
+    if err != nil || u.Host == "" {
+        return "(invalid URL)"
+    }
+    return u.Host
+}
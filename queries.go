@@ -0,0 +1,217 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "strings"
+
+    "theWhiskyExchangeCrawler/config"
+    "theWhiskyExchangeCrawler/sink"
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// crawlQuery is one query of a crawl. In a multi-query crawl, products are
+// annotated with the Name of every query that listed them.
+type crawlQuery struct {
+    Name  string
+    Query twe.Query
+}
+
+// queryFlags collects repeated -query flags.
+type queryFlags []string
+
+func (f *queryFlags) String() string { return strings.Join(*f, " | ") }
+
+func (f *queryFlags) Set(expr string) error {
+    *f = append(*f, expr)
+    return nil
+}
+
+// buildQueries resolves the queries of a crawl: the -query flags if there
+// are any, otherwise crawl.queries, otherwise the catalogue-wide search.
+// -search, -page-size and -sort apply to every query.
+func buildQueries(exprs []string, configured []config.QueryConfig, search string, pageSize int, sortOrder string) ([]crawlQuery, error) {
+    if len(exprs) > 0 {
+        configured = nil
+        for _, expr := range exprs {
+            configured = append(configured, config.QueryConfig{Query: expr})
+        }
+    }
+    if len(configured) == 0 {
+        configured = []config.QueryConfig{{}}
+    }
+    queries := make([]crawlQuery, 0, len(configured))
+    names := make(map[string]bool)
+    for _, c := range configured {
+        query, err := buildQuery(c.Query, search, pageSize, sortOrder)
+        if err != nil {
+            if len(configured) == 1 {
+                return nil, err
+            }
+            return nil, fmt.Errorf("%q: %w", c.Query, err)
+        }
+        name := queryName(c.Name, query)
+        if names[name] {
+            return nil, fmt.Errorf("two queries are named %q", name)
+        }
+        names[name] = true
+        queries = append(queries, crawlQuery{Name: name, Query: query})
+    }
+    return queries, nil
+}
+
+// queryName is the configured name of query, or else its expression.
+func queryName(name string, query twe.Query) string {
+    if name != "" {
+        return name
+    }
+    if expr := query.String(); expr != "" {
+        return expr
+    }
+    return "catalogue"
+}
+
+// savedQueries returns the queries of the crawl a checkpoint belongs to.
+func savedQueries(state *checkpointState) ([]crawlQuery, error) {
+    if len(state.Queries) == 0 {
+        query, err := twe.ParseQuery(state.Query)
+        if err != nil {
+            return nil, err
+        }
+        return []crawlQuery{{Name: queryName("", query), Query: query}}, nil
+    }
+    queries := make([]crawlQuery, len(state.Queries))
+    for i, q := range state.Queries {
+        query, err := twe.ParseQuery(q.Query)
+        if err != nil {
+            return nil, fmt.Errorf("%q: %w", q.Query, err)
+        }
+        queries[i] = crawlQuery{Name: q.Name, Query: query}
+    }
+    return queries, nil
+}
+
+// describeQueries renders the queries of a crawl for messages and the
+// history database. A lone catalogue-wide search is "".
+func describeQueries(queries []crawlQuery) string {
+    if len(queries) == 1 {
+        return queries[0].Query.String()
+    }
+    parts := make([]string, len(queries))
+    for i, q := range queries {
+        parts[i] = q.String()
+    }
+    return strings.Join(parts, "; ")
+}
+
+// String is the query's name, followed by its expression if that differs.
+func (q crawlQuery) String() string {
+    if expr := q.Query.String(); expr != "" && expr != q.Name {
+        return q.Name + ": " + expr
+    }
+    return q.Name
+}
+
+// coversCatalogue reports whether any of queries lists the whole catalogue,
+// so that a product missing from the crawl has been delisted.
+func coversCatalogue(queries []crawlQuery) bool {
+    for _, q := range queries {
+        if q.Query.CoversCatalogue() {
+            return true
+        }
+    }
+    return false
+}
+
+// queryListings remembers which queries of a multi-query crawl listed each
+// product, so products can be written once with all of them. Products go
+// to a spool file while the queries run and are copied to the outputs, now
+// annotated, once the last query is done. A nil *queryListings stands for a
+// single-query crawl and keeps every product.
+type queryListings struct {
+    queries map[string][]string // ProductID -> query names, in crawl order
+}
+
+func newQueryListings(queries []crawlQuery) *queryListings {
+    if len(queries) < 2 {
+        return nil
+    }
+    return &queryListings{queries: make(map[string][]string)}
+}
+
+// note records that query name listed products. Each product is stamped
+// with name, so a checkpoint of them can be noted again on resume. The
+// products no earlier query listed are moved to the front, keeping their
+// order, and their number is returned.
+func (l *queryListings) note(name string, products []twe.Product) int {
+    if l == nil {
+        return len(products)
+    }
+    var fresh, repeated []twe.Product
+    for _, product := range products {
+        product.Queries = []string{name}
+        names, seen := l.queries[product.ProductID]
+        if seen {
+            repeated = append(repeated, product)
+        } else {
+            fresh = append(fresh, product)
+        }
+        if len(names) == 0 || names[len(names)-1] != name {
+            l.queries[product.ProductID] = append(names, name)
+        }
+    }
+    copy(products, fresh)
+    copy(products[len(fresh):], repeated)
+    return len(fresh)
+}
+
+// merge copies the products spooled at path to out, each annotated with
+// every query that listed it, and returns how many there were.
+func (l *queryListings) merge(path string, out sink.Sink) (int, error) {
+    count := 0
+    err := sink.ReadJSON(path, func(product twe.Product) error {
+        product.Queries = l.queries[product.ProductID]
+        count++
+        if err := out.Write(product); err != nil {
+            return err
+        }
+        if count%1000 == 0 {
+            return out.Flush()
+        }
+        return nil
+    })
+    if err != nil {
+        return count, err
+    }
+    return count, out.Flush()
+}
+
+// spoolPath is where a multi-query crawl keeps its products until every
+// query has run.
+func spoolPath(outputs []string) string {
+    return outputs[0] + ".spool.ndjson"
+}
+
+// write copies the spooled products to outputs, annotated, and removes the
+// spool once every output is finished. It returns how many products were
+// written.
+func (l *queryListings) write(outputs []string, table sink.Table) (int, error) {
+    out, err := sink.OpenAll(outputs, table)
+    if err != nil {
+        return 0, err
+    }
+    spool := spoolPath(outputs)
+    count, err := l.merge(spool, out)
+    if err != nil {
+        out.Abort()
+        return count, err
+    }
+    if err := out.Close(); err != nil {
+        return count, err
+    }
+    if err := os.Remove(spool); err != nil {
+        log.Printf("Warning: could not remove %s: %v", spool, err)
+    }
+    return count, nil
+}
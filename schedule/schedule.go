@@ -0,0 +1,185 @@
+// Package schedule parses cron expressions and works out when they fire
+// next.
+package schedule
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+    expr string
+
+    minute, hour, dom, month, dow bits
+    // A restricted day of month and day of week match either, as in cron.
+    domAny, dowAny bool
+
+    // every is set for "@every <duration>" schedules.
+    every time.Duration
+}
+
+// bits has bit n set when value n matches.
+type bits uint64
+
+func (b bits) has(n int) bool { return b&(1<<uint(n)) != 0 }
+
+var macros = map[string]string{
+    "@yearly":   "0 0 1 1 *",
+    "@annually": "0 0 1 1 *",
+    "@monthly":  "0 0 1 * *",
+    "@weekly":   "0 0 * * 0",
+    "@daily":    "0 0 * * *",
+    "@midnight": "0 0 * * *",
+    "@hourly":   "0 * * * *",
+}
+
+type field struct {
+    name     string
+    min, max int
+    names    []string // names of min, min+1, ...
+}
+
+var (
+    minuteField = field{name: "minute", min: 0, max: 59}
+    hourField   = field{name: "hour", min: 0, max: 23}
+    domField    = field{name: "day of month", min: 1, max: 31}
+    monthField  = field{name: "month", min: 1, max: 12,
+        names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+    dowField = field{name: "day of week", min: 0, max: 7,
+        names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// Parse parses a cron expression in local time: five fields (minute, hour,
+// day of month, month, day of week) each holding "*", values, ranges and
+// steps such as "1,15", "9-17" or "*/15", or one of @hourly, @daily,
+// @weekly, @monthly, @yearly and "@every <duration>".
+func Parse(expr string) (*Schedule, error) {
+    expr = strings.TrimSpace(expr)
+    s := &Schedule{expr: expr}
+    if rest, ok := strings.CutPrefix(expr, "@every "); ok {
+        d, err := time.ParseDuration(strings.TrimSpace(rest))
+        if err != nil {
+            return nil, fmt.Errorf("schedule %q: %w", expr, err)
+        }
+        if d < time.Second {
+            return nil, fmt.Errorf("schedule %q: the interval must be at least a second", expr)
+        }
+        s.every = d
+        return s, nil
+    }
+    if macro, ok := macros[expr]; ok {
+        expr = macro
+    }
+    fields := strings.Fields(expr)
+    if len(fields) != 5 {
+        return nil, fmt.Errorf("schedule %q: want 5 fields (minute hour day-of-month month day-of-week), got %d", s.expr, len(fields))
+    }
+    var err error
+    parsed := []*bits{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+    for i, f := range []field{minuteField, hourField, domField, monthField, dowField} {
+        if *parsed[i], err = f.parse(fields[i]); err != nil {
+            return nil, fmt.Errorf("schedule %q: %w", s.expr, err)
+        }
+    }
+    if s.dow.has(7) {
+        s.dow |= 1 // 7 is Sunday too
+    }
+    s.domAny, s.dowAny = fields[2] == "*", fields[4] == "*"
+    if s.Next(time.Now()).IsZero() {
+        return nil, fmt.Errorf("schedule %q never fires", s.expr)
+    }
+    return s, nil
+}
+
+func (s *Schedule) String() string {
+    return s.expr
+}
+
+// parse parses one comma-separated field.
+func (f field) parse(text string) (bits, error) {
+    var b bits
+    for _, part := range strings.Split(text, ",") {
+        lo, hi, step := f.min, f.max, 1
+        rangeText, stepText, hasStep := strings.Cut(part, "/")
+        if hasStep {
+            n, err := strconv.Atoi(stepText)
+            if err != nil || n < 1 {
+                return 0, fmt.Errorf("%s: invalid step %q", f.name, stepText)
+            }
+            step = n
+        }
+        if rangeText != "*" {
+            from, to, isRange := strings.Cut(rangeText, "-")
+            var err error
+            if lo, err = f.value(from); err != nil {
+                return 0, err
+            }
+            hi = lo
+            if isRange {
+                if hi, err = f.value(to); err != nil {
+                    return 0, err
+                }
+            } else if hasStep {
+                hi = f.max // "5/15" means from 5 on
+            }
+            if hi < lo {
+                return 0, fmt.Errorf("%s: range %q runs backwards", f.name, rangeText)
+            }
+        }
+        for v := lo; v <= hi; v += step {
+            b |= 1 << uint(v)
+        }
+    }
+    return b, nil
+}
+
+func (f field) value(text string) (int, error) {
+    for i, name := range f.names {
+        if strings.EqualFold(text, name) {
+            return f.min + i, nil
+        }
+    }
+    v, err := strconv.Atoi(text)
+    if err != nil || v < f.min || v > f.max {
+        return 0, fmt.Errorf("%s: %q is not between %d and %d", f.name, text, f.min, f.max)
+    }
+    return v, nil
+}
+
+// Next returns the first time after after that s fires, or the zero time
+// if it does not fire within five years.
+func (s *Schedule) Next(after time.Time) time.Time {
+    if s.every > 0 {
+        return after.Add(s.every)
+    }
+    loc := after.Location()
+    t := after.Truncate(time.Minute).Add(time.Minute)
+    limit := t.AddDate(5, 0, 0)
+    for t.Before(limit) {
+        y, m, d := t.Date()
+        switch {
+        case !s.month.has(int(m)):
+            t = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+        case !s.dayMatches(t):
+            t = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+        case !s.hour.has(t.Hour()):
+            t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, loc)
+        case !s.minute.has(t.Minute()):
+            t = t.Add(time.Minute)
+        default:
+            return t
+        }
+    }
+    return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+    dom, dow := s.dom.has(t.Day()), s.dow.has(int(t.Weekday()))
+    if s.domAny || s.dowAny {
+        return dom && dow
+    }
+    return dom || dow
+}
@@ -0,0 +1,123 @@
+package schedule
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParse(t *testing.T) {
+    tests := []struct {
+        expr    string
+        wantErr string
+    }{
+        {expr: "* * * * *"},
+        {expr: "  */15 9-17 * * mon-fri  "},
+        {expr: "0 6,18 1 JAN,jul *"},
+        {expr: "5/20 * * * 7"},
+        {expr: "@daily"},
+        {expr: "@every 90m"},
+        {expr: "0 0 * *", wantErr: "want 5 fields"},
+        {expr: "@fortnightly", wantErr: "want 5 fields"},
+        {expr: "60 * * * *", wantErr: `minute: "60" is not between 0 and 59`},
+        {expr: "0 24 * * *", wantErr: "hour"},
+        {expr: "0 0 0 * *", wantErr: "day of month"},
+        {expr: "0 0 * 13 *", wantErr: "month"},
+        {expr: "0 0 * * 8", wantErr: "day of week"},
+        {expr: "0 0 * * sun-sat-", wantErr: "day of week"},
+        {expr: "0 17-9 * * *", wantErr: `range "17-9" runs backwards`},
+        {expr: "*/0 * * * *", wantErr: `invalid step "0"`},
+        {expr: "*/x * * * *", wantErr: `invalid step "x"`},
+        {expr: "0 0 30 2 *", wantErr: "never fires"},
+        {expr: "@every soon", wantErr: "invalid duration"},
+        {expr: "@every 500ms", wantErr: "at least a second"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.expr, func(t *testing.T) {
+            s, err := Parse(tt.expr)
+            if tt.wantErr == "" {
+                if err != nil {
+                    t.Fatalf("Parse: %v", err)
+                }
+                if s.String() != strings.TrimSpace(tt.expr) {
+                    t.Errorf("String() = %q", s.String())
+                }
+                return
+            }
+            if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                t.Fatalf("Parse error = %v, want one containing %q", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestNext(t *testing.T) {
+    // 2026-10-16 is a Friday.
+    at := func(s string) time.Time {
+        t.Helper()
+        ts, err := time.Parse("2006-01-02 15:04:05", s)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return ts
+    }
+    tests := []struct {
+        expr  string
+        after string
+        want  []string // the next few firings
+    }{
+        {"*/15 * * * *", "2026-10-16 10:07:30", []string{"2026-10-16 10:15:00", "2026-10-16 10:30:00", "2026-10-16 10:45:00", "2026-10-16 11:00:00"}},
+        {"5/20 * * * *", "2026-10-16 10:00:00", []string{"2026-10-16 10:05:00", "2026-10-16 10:25:00", "2026-10-16 10:45:00", "2026-10-16 11:05:00"}},
+        {"30 10 * * *", "2026-10-16 10:30:00", []string{"2026-10-17 10:30:00"}},
+        {"30 10 * * *", "2026-10-16 10:29:59", []string{"2026-10-16 10:30:00"}},
+        {"0 9 * * mon-fri", "2026-10-16 10:00:00", []string{"2026-10-19 09:00:00", "2026-10-20 09:00:00"}},
+        {"0 0 * * 7", "2026-10-16 00:00:00", []string{"2026-10-18 00:00:00", "2026-10-25 00:00:00"}},
+        {"@hourly", "2026-10-16 23:59:00", []string{"2026-10-17 00:00:00"}},
+        {"@monthly", "2026-12-31 12:00:00", []string{"2027-01-01 00:00:00", "2027-02-01 00:00:00"}},
+        {"0 6 31 * *", "2026-10-16 00:00:00", []string{"2026-10-31 06:00:00", "2026-12-31 06:00:00"}},
+        {"0 12 29 2 *", "2026-03-01 00:00:00", []string{"2028-02-29 12:00:00"}},
+        {"0 9 1 jan,jul *", "2026-10-16 00:00:00", []string{"2027-01-01 09:00:00", "2027-07-01 09:00:00"}},
+        // A restricted day of month and day of week match either.
+        {"0 0 13 * fri", "2026-10-10 00:00:00", []string{"2026-10-13 00:00:00", "2026-10-16 00:00:00", "2026-10-23 00:00:00"}},
+        // A step on either keeps it restricted.
+        {"0 0 */10 * mon", "2026-10-16 00:00:00", []string{"2026-10-19 00:00:00", "2026-10-21 00:00:00", "2026-10-26 00:00:00"}},
+        {"@every 90m", "2026-10-16 10:07:30", []string{"2026-10-16 11:37:30", "2026-10-16 13:07:30"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.expr+" after "+tt.after, func(t *testing.T) {
+            s, err := Parse(tt.expr)
+            if err != nil {
+                t.Fatal(err)
+            }
+            next := at(tt.after)
+            for _, want := range tt.want {
+                next = s.Next(next)
+                if !next.Equal(at(want)) {
+                    t.Fatalf("Next = %s, want %s", next.Format(time.DateTime), want)
+                }
+            }
+        })
+    }
+}
+
+func TestNextKeepsLocation(t *testing.T) {
+    london, err := time.LoadLocation("Europe/London")
+    if err != nil {
+        t.Skip(err)
+    }
+    s, err := Parse("0 9 * * *")
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Clocks go back on 2026-10-25; 9:00 stays 9:00 local time.
+    next := time.Date(2026, 10, 24, 12, 0, 0, 0, london)
+    for _, want := range []time.Time{
+        time.Date(2026, 10, 25, 9, 0, 0, 0, london),
+        time.Date(2026, 10, 26, 9, 0, 0, 0, london),
+    } {
+        next = s.Next(next)
+        if !next.Equal(want) || next.Location() != london {
+            t.Fatalf("Next = %v, want %v", next, want)
+        }
+    }
+}
@@ -0,0 +1,63 @@
+package sink
+
+import (
+    "encoding/csv"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// CSV writes one row per product with a header row. Every flushed row of an
+// unfinished file is usable.
+type CSV struct {
+    file  *atomicFile
+    w     *csv.Writer
+    table Table
+}
+
+// NewCSV starts a CSV file at path with the columns of table.
+func NewCSV(path string, table Table) (*CSV, error) {
+    file, err := createAtomic(path)
+    if err != nil {
+        return nil, err
+    }
+    s := &CSV{file: file, w: csv.NewWriter(file.w), table: table}
+    header := make([]string, len(table.Columns))
+    for i, c := range table.Columns {
+        header[i] = c.Name
+    }
+    if err := s.w.Write(header); err != nil {
+        file.abort()
+        return nil, err
+    }
+    return s, nil
+}
+
+func (s *CSV) Write(p twe.Product) error {
+    values := s.table.values(p)
+    record := make([]string, len(values))
+    for i, value := range values {
+        record[i] = formatCell(value)
+    }
+    return s.w.Write(record)
+}
+
+func (s *CSV) Flush() error {
+    s.w.Flush()
+    if err := s.w.Error(); err != nil {
+        return err
+    }
+    return s.file.flush()
+}
+
+func (s *CSV) Close() error {
+    if err := s.Flush(); err != nil {
+        s.file.abort()
+        return err
+    }
+    return s.file.commit()
+}
+
+func (s *CSV) Abort() error {
+    s.w.Flush()
+    return s.file.abort()
+}
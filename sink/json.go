@@ -0,0 +1,147 @@
+package sink
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// JSONArray writes an indented JSON array, the same layout output.json has
+// always had. An unfinished file lacks only the closing bracket.
+type JSONArray struct {
+    file  *atomicFile
+    count int
+}
+
+// NewJSONArray starts a JSON array at path.
+func NewJSONArray(path string) (*JSONArray, error) {
+    file, err := createAtomic(path)
+    if err != nil {
+        return nil, err
+    }
+    if _, err := file.w.WriteString("["); err != nil {
+        file.abort()
+        return nil, err
+    }
+    return &JSONArray{file: file}, nil
+}
+
+func (s *JSONArray) Write(p twe.Product) error {
+    data, err := json.MarshalIndent(p, "  ", "  ")
+    if err != nil {
+        return fmt.Errorf("encoding product %s: %w", p.ProductID, err)
+    }
+    separator := "\n  "
+    if s.count > 0 {
+        separator = ",\n  "
+    }
+    s.file.w.WriteString(separator)
+    if _, err := s.file.w.Write(data); err != nil {
+        return err
+    }
+    s.count++
+    return nil
+}
+
+func (s *JSONArray) Flush() error { return s.file.flush() }
+
+func (s *JSONArray) Close() error {
+    end := "\n]"
+    if s.count == 0 {
+        end = "]"
+    }
+    if _, err := s.file.w.WriteString(end); err != nil {
+        s.file.abort()
+        return err
+    }
+    return s.file.commit()
+}
+
+func (s *JSONArray) Abort() error { return s.file.abort() }
+
+// NDJSON writes one product per line (JSON Lines). Every complete line of an
+// unfinished file is usable.
+type NDJSON struct {
+    file *atomicFile
+    enc  *json.Encoder
+}
+
+// NewNDJSON starts a JSON Lines file at path.
+func NewNDJSON(path string) (*NDJSON, error) {
+    file, err := createAtomic(path)
+    if err != nil {
+        return nil, err
+    }
+    return &NDJSON{file: file, enc: json.NewEncoder(file.w)}, nil
+}
+
+func (s *NDJSON) Write(p twe.Product) error {
+    if err := s.enc.Encode(p); err != nil {
+        return fmt.Errorf("encoding product %s: %w", p.ProductID, err)
+    }
+    return nil
+}
+
+func (s *NDJSON) Flush() error { return s.file.flush() }
+func (s *NDJSON) Close() error { return s.file.commit() }
+func (s *NDJSON) Abort() error { return s.file.abort() }
+
+// ReadJSON streams the products in a file written by JSONArray or NDJSON to
+// fn, one at a time, stopping at the first error.
+func ReadJSON(path string, fn func(twe.Product) error) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    r := bufio.NewReaderSize(f, 256*1024)
+    first, err := firstByte(r)
+    if err == io.EOF {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    dec := json.NewDecoder(r)
+    if first == '[' {
+        if _, err := dec.Token(); err != nil {
+            return fmt.Errorf("%s: %w", path, err)
+        }
+    }
+    for dec.More() {
+        var p twe.Product
+        if err := dec.Decode(&p); err != nil {
+            return fmt.Errorf("%s: %w", path, err)
+        }
+        if err := fn(p); err != nil {
+            return err
+        }
+    }
+    if first == '[' {
+        if _, err := dec.Token(); err != nil {
+            return fmt.Errorf("%s: %w", path, err)
+        }
+    }
+    return nil
+}
+
+// firstByte returns the first non-space byte of r without consuming it.
+func firstByte(r *bufio.Reader) (byte, error) {
+    for {
+        b, err := r.Peek(1)
+        if err != nil {
+            return 0, err
+        }
+        if !bytes.ContainsAny(b, " \t\r\n") {
+            return b[0], nil
+        }
+        r.ReadByte()
+    }
+}
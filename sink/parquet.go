@@ -0,0 +1,89 @@
+package sink
+
+import (
+    "github.com/parquet-go/parquet-go"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// Parquet writes one row per product, with an optional column per table
+// column. Each Flush closes a row group, so memory use is bounded by one
+// API page. Parquet needs its footer to be readable, so an unfinished file
+// is not usable.
+type Parquet struct {
+    file    *atomicFile
+    w       *parquet.Writer
+    table   Table
+    builder *parquet.RowBuilder
+    // index maps table column positions to schema leaf positions, since a
+    // parquet group orders its fields by name.
+    index []int
+}
+
+// NewParquet starts a Parquet file at path with the columns of table.
+func NewParquet(path string, table Table) (*Parquet, error) {
+    group := parquet.Group{}
+    for _, c := range table.Columns {
+        node := parquet.String()
+        if c.Kind == Number {
+            node = parquet.Leaf(parquet.DoubleType)
+        }
+        group[c.Name] = parquet.Optional(node)
+    }
+    schema := parquet.NewSchema("product", group)
+
+    leaves := make(map[string]int)
+    for i, path := range schema.Columns() {
+        leaves[path[0]] = i
+    }
+    index := make([]int, len(table.Columns))
+    for i, c := range table.Columns {
+        index[i] = leaves[c.Name]
+    }
+
+    file, err := createAtomic(path)
+    if err != nil {
+        return nil, err
+    }
+    return &Parquet{
+        file:    file,
+        w:       parquet.NewWriter(file.w, schema),
+        table:   table,
+        builder: parquet.NewRowBuilder(schema),
+        index:   index,
+    }, nil
+}
+
+func (s *Parquet) Write(p twe.Product) error {
+    s.builder.Reset()
+    for i, value := range s.table.values(p) {
+        switch v := value.(type) {
+        case float64:
+            s.builder.Add(s.index[i], parquet.DoubleValue(v))
+        case string:
+            s.builder.Add(s.index[i], parquet.ByteArrayValue([]byte(v)))
+        }
+    }
+    _, err := s.w.WriteRows([]parquet.Row{s.builder.Row()})
+    return err
+}
+
+func (s *Parquet) Flush() error {
+    if err := s.w.Flush(); err != nil {
+        return err
+    }
+    return s.file.flush()
+}
+
+func (s *Parquet) Close() error {
+    if err := s.w.Close(); err != nil {
+        s.file.abort()
+        return err
+    }
+    return s.file.commit()
+}
+
+func (s *Parquet) Abort() error {
+    s.w.Flush()
+    return s.file.abort()
+}
@@ -0,0 +1,187 @@
+// Package sink writes collected products to files as they arrive, so a crawl
+// never has to hold the whole catalogue in memory.
+//
+// Every sink writes to "<path>.partial" and only renames it to path once the
+// crawl has finished, so path always holds the output of a complete crawl.
+// If the crawl fails or the process dies, the .partial file keeps everything
+// written up to the last Flush.
+package sink
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// PartialSuffix is appended to the path of an unfinished output file.
+const PartialSuffix = ".partial"
+
+// Sink receives the products of a crawl one at a time.
+type Sink interface {
+    // Write adds one product.
+    Write(p twe.Product) error
+    // Flush pushes buffered products to the partial file.
+    Flush() error
+    // Close finishes the output and moves it into place.
+    Close() error
+    // Abort stops writing and leaves the partial file behind.
+    Abort() error
+}
+
+// formats maps file extensions to the sink that writes them.
+var formats = map[string]func(path string, table Table) (Sink, error){
+    ".json":    func(path string, _ Table) (Sink, error) { return NewJSONArray(path) },
+    ".ndjson":  func(path string, _ Table) (Sink, error) { return NewNDJSON(path) },
+    ".jsonl":   func(path string, _ Table) (Sink, error) { return NewNDJSON(path) },
+    ".csv":     func(path string, table Table) (Sink, error) { return NewCSV(path, table) },
+    ".parquet": func(path string, table Table) (Sink, error) { return NewParquet(path, table) },
+    ".xlsx":    func(path string, table Table) (Sink, error) { return NewXLSX(path, table) },
+}
+
+// ErrUnknownFormat is returned for an output path whose extension names no
+// known format.
+var ErrUnknownFormat = errors.New("unknown output format (want .json, .ndjson, .jsonl, .csv, .parquet or .xlsx)")
+
+// Open returns a sink for path, choosing the format from its extension:
+// .json for a JSON array, .ndjson or .jsonl for JSON Lines, and .csv,
+// .parquet or .xlsx for table's columns.
+func Open(path string, table Table) (Sink, error) {
+    open, ok := formats[strings.ToLower(filepath.Ext(path))]
+    if !ok {
+        return nil, fmt.Errorf("%s: %w", path, ErrUnknownFormat)
+    }
+    return open(path, table)
+}
+
+// Readable reports whether ReadJSON can read the output written to path.
+func Readable(path string) bool {
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".json", ".ndjson", ".jsonl":
+        return true
+    }
+    return false
+}
+
+// Check returns ErrUnknownFormat for the first path whose extension names
+// no known format.
+func Check(paths []string) error {
+    for _, path := range paths {
+        if _, ok := formats[strings.ToLower(filepath.Ext(path))]; !ok {
+            return fmt.Errorf("%s: %w", path, ErrUnknownFormat)
+        }
+    }
+    return nil
+}
+
+// OpenAll opens a sink for every path and returns them as one. Every format
+// is checked before any file is created, and if a sink fails to open, those
+// already opened are aborted.
+func OpenAll(paths []string, table Table) (Sink, error) {
+    if err := Check(paths); err != nil {
+        return nil, err
+    }
+    var sinks Multi
+    for _, path := range paths {
+        s, err := Open(path, table)
+        if err != nil {
+            sinks.Abort()
+            return nil, err
+        }
+        sinks = append(sinks, s)
+    }
+    if len(sinks) == 1 {
+        return sinks[0], nil
+    }
+    return sinks, nil
+}
+
+// Multi writes every product to each of its sinks.
+type Multi []Sink
+
+func (m Multi) Write(p twe.Product) error {
+    for _, s := range m {
+        if err := s.Write(p); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (m Multi) Flush() error {
+    for _, s := range m {
+        if err := s.Flush(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Close closes every sink, even after one fails, so that each output that
+// can be finished is.
+func (m Multi) Close() error {
+    var errs []error
+    for _, s := range m {
+        errs = append(errs, s.Close())
+    }
+    return errors.Join(errs...)
+}
+
+func (m Multi) Abort() error {
+    var errs []error
+    for _, s := range m {
+        errs = append(errs, s.Abort())
+    }
+    return errors.Join(errs...)
+}
+
+// atomicFile is a file written under a temporary name and renamed into place.
+type atomicFile struct {
+    path string
+    f    *os.File
+    w    *bufio.Writer
+}
+
+func createAtomic(path string) (*atomicFile, error) {
+    f, err := os.OpenFile(path+PartialSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+    if err != nil {
+        return nil, err
+    }
+    return &atomicFile{path: path, f: f, w: bufio.NewWriterSize(f, 256*1024)}, nil
+}
+
+func (a *atomicFile) flush() error {
+    if err := a.w.Flush(); err != nil {
+        return fmt.Errorf("writing %s: %w", a.f.Name(), err)
+    }
+    return nil
+}
+
+// commit syncs the partial file and renames it to the final path.
+func (a *atomicFile) commit() error {
+    if err := a.flush(); err != nil {
+        a.f.Close()
+        return err
+    }
+    if err := a.f.Sync(); err != nil {
+        a.f.Close()
+        return err
+    }
+    if err := a.f.Close(); err != nil {
+        return err
+    }
+    return os.Rename(a.f.Name(), a.path)
+}
+
+// abort flushes what was written and leaves it in the partial file.
+func (a *atomicFile) abort() error {
+    err := a.flush()
+    if closeErr := a.f.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
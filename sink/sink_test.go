@@ -0,0 +1,245 @@
+package sink
+
+import (
+    "encoding/csv"
+    "errors"
+    "io"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strconv"
+    "testing"
+
+    "github.com/parquet-go/parquet-go"
+    "github.com/xuri/excelize/v2"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+var testTable = Table{
+    Columns: []Column{{"SKU", String}, {"Name", String}, {"Price", Number}, {"Stock", Number}, {"Volume", Number}},
+    Row: func(p twe.Product) map[string]interface{} {
+        row := map[string]interface{}{"SKU": p.ProductID, "Name": p.Name}
+        switch p.ProductID {
+        case "1":
+            row["Price"], row["Stock"], row["Volume"] = 49.95, 12, "70"
+        case "2":
+            row["Price"], row["Volume"] = 1250.0, "not a number"
+        }
+        return row
+    },
+}
+
+var testProducts = []twe.Product{
+    {ProductID: "1", Name: "Port Ellen 40 Year Old"},
+    {ProductID: "2", Name: `Lagavulin "Distillers Edition", 2008`},
+    {ProductID: "3"},
+}
+
+// wantRows are testProducts as text, empty where a cell has no value.
+var wantRows = [][]string{
+    {"1", "Port Ellen 40 Year Old", "49.95", "12", "70"},
+    {"2", `Lagavulin "Distillers Edition", 2008`, "1250", "", ""},
+    {"3", "", "", "", ""},
+}
+
+func TestTableSinks(t *testing.T) {
+    tests := []struct {
+        ext  string
+        read func(t *testing.T, path string) [][]string
+    }{
+        {".csv", readCSV},
+        {".parquet", readParquet},
+        {".xlsx", readXLSX},
+    }
+    for _, tt := range tests {
+        t.Run(tt.ext, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), "output"+tt.ext)
+            s, err := Open(path, testTable)
+            if err != nil {
+                t.Fatalf("Open: %v", err)
+            }
+            for _, p := range testProducts {
+                if err := s.Write(p); err != nil {
+                    t.Fatalf("Write: %v", err)
+                }
+            }
+            if err := s.Flush(); err != nil {
+                t.Fatalf("Flush: %v", err)
+            }
+            if _, err := os.Stat(path); !os.IsNotExist(err) {
+                t.Errorf("%s exists before Close (%v)", path, err)
+            }
+            if err := s.Close(); err != nil {
+                t.Fatalf("Close: %v", err)
+            }
+            if _, err := os.Stat(path + PartialSuffix); !os.IsNotExist(err) {
+                t.Errorf("partial file left after Close (%v)", err)
+            }
+
+            rows := tt.read(t, path)
+            if len(rows) == 0 || !reflect.DeepEqual(rows[0], []string{"SKU", "Name", "Price", "Stock", "Volume"}) {
+                t.Fatalf("header = %q", rows)
+            }
+            if !reflect.DeepEqual(rows[1:], wantRows) {
+                t.Errorf("rows = %q, want %q", rows[1:], wantRows)
+            }
+        })
+    }
+}
+
+func TestTableSinksAbort(t *testing.T) {
+    tests := []struct {
+        ext string
+        // readable is whether the partial file of a failed crawl can be read.
+        readable bool
+        read     func(t *testing.T, path string) [][]string
+    }{
+        {".csv", true, readCSV},
+        {".parquet", false, nil},
+        {".xlsx", true, readXLSX},
+    }
+    for _, tt := range tests {
+        t.Run(tt.ext, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), "output"+tt.ext)
+            s, err := Open(path, testTable)
+            if err != nil {
+                t.Fatalf("Open: %v", err)
+            }
+            s.Write(testProducts[0])
+            s.Flush()
+            if err := s.Abort(); err != nil {
+                t.Fatalf("Abort: %v", err)
+            }
+            if _, err := os.Stat(path); !os.IsNotExist(err) {
+                t.Errorf("%s written by an aborted crawl (%v)", path, err)
+            }
+            if _, err := os.Stat(path + PartialSuffix); err != nil {
+                t.Fatalf("partial file: %v", err)
+            }
+            if tt.readable {
+                rows := tt.read(t, path+PartialSuffix)
+                if len(rows) != 2 || !reflect.DeepEqual(rows[1], wantRows[0]) {
+                    t.Errorf("partial rows = %q, want the header and %q", rows, wantRows[0])
+                }
+            }
+        })
+    }
+}
+
+func TestSelect(t *testing.T) {
+    tests := []struct {
+        names   []string
+        want    []Column
+        wantErr bool
+    }{
+        {names: []string{"Price", "SKU"}, want: []Column{{"Price", Number}, {"SKU", String}}},
+        {names: []string{}, want: []Column{}},
+        {names: []string{"SKU", "Colour"}, wantErr: true},
+    }
+    for _, tt := range tests {
+        got, err := testTable.Select(tt.names)
+        if (err != nil) != tt.wantErr {
+            t.Errorf("Select(%q) error = %v", tt.names, err)
+            continue
+        }
+        if !tt.wantErr && !reflect.DeepEqual(got.Columns, tt.want) {
+            t.Errorf("Select(%q) = %v, want %v", tt.names, got.Columns, tt.want)
+        }
+    }
+}
+
+func TestOpenAllChecksEveryFormatFirst(t *testing.T) {
+    dir := t.TempDir()
+    csvPath := filepath.Join(dir, "output.csv")
+    _, err := OpenAll([]string{csvPath, filepath.Join(dir, "output.txt")}, testTable)
+    if !errors.Is(err, ErrUnknownFormat) {
+        t.Fatalf("OpenAll error = %v, want ErrUnknownFormat", err)
+    }
+    if _, err := os.Stat(csvPath + PartialSuffix); !os.IsNotExist(err) {
+        t.Errorf("CSV file created before the unknown format was noticed (%v)", err)
+    }
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+    t.Helper()
+    f, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    rows, err := csv.NewReader(f).ReadAll()
+    if err != nil {
+        t.Fatalf("reading %s: %v", path, err)
+    }
+    return rows
+}
+
+func readParquet(t *testing.T, path string) [][]string {
+    t.Helper()
+    f, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    r := parquet.NewReader(f)
+    defer r.Close()
+
+    // The schema orders columns by name; put them back in table order.
+    position := make(map[string]int)
+    for i, c := range testTable.Columns {
+        position[c.Name] = i
+    }
+    columns := r.Schema().Columns()
+    header := make([]string, len(columns))
+    for _, path := range columns {
+        header[position[path[0]]] = path[0]
+    }
+    out := [][]string{header}
+
+    rows := make([]parquet.Row, 1)
+    for {
+        n, err := r.ReadRows(rows)
+        if n == 1 {
+            cells := make([]string, len(columns))
+            for _, v := range rows[0] {
+                if v.IsNull() {
+                    continue
+                }
+                i := position[columns[v.Column()][0]]
+                if v.Kind() == parquet.Double {
+                    cells[i] = strconv.FormatFloat(v.Double(), 'f', -1, 64)
+                } else {
+                    cells[i] = string(v.ByteArray())
+                }
+            }
+            out = append(out, cells)
+        }
+        if err == io.EOF {
+            return out
+        }
+        if err != nil {
+            t.Fatalf("reading %s: %v", path, err)
+        }
+    }
+}
+
+func readXLSX(t *testing.T, path string) [][]string {
+    t.Helper()
+    book, err := excelize.OpenFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer book.Close()
+    rows, err := book.GetRows(xlsxSheet)
+    if err != nil {
+        t.Fatalf("reading %s: %v", path, err)
+    }
+    // GetRows drops trailing empty cells.
+    for i := range rows {
+        for len(rows[i]) < len(testTable.Columns) {
+            rows[i] = append(rows[i], "")
+        }
+    }
+    return rows
+}
@@ -0,0 +1,95 @@
+package sink
+
+import (
+    "fmt"
+    "strconv"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// Kind is the type of a table column.
+type Kind int
+
+const (
+    String Kind = iota
+    Number
+)
+
+// Column is one column of tabular output.
+type Column struct {
+    Name string
+    Kind Kind
+}
+
+// Table describes tabular output (CSV, Parquet, XLSX): its columns, in
+// order, and how a product maps onto them.
+type Table struct {
+    Columns []Column
+    // Row returns a product's values keyed by column name. Missing keys
+    // become empty cells.
+    Row func(twe.Product) map[string]interface{}
+}
+
+// Select returns a copy of t with only the named columns, in that order.
+func (t Table) Select(names []string) (Table, error) {
+    byName := make(map[string]Column, len(t.Columns))
+    for _, c := range t.Columns {
+        byName[c.Name] = c
+    }
+    selected := make([]Column, 0, len(names))
+    for _, name := range names {
+        c, ok := byName[name]
+        if !ok {
+            return t, fmt.Errorf("unknown column %q", name)
+        }
+        selected = append(selected, c)
+    }
+    t.Columns = selected
+    return t, nil
+}
+
+// values returns p's cells in column order: float64 for numbers, string for
+// text, nil for empty cells.
+func (t Table) values(p twe.Product) []interface{} {
+    row := t.Row(p)
+    values := make([]interface{}, len(t.Columns))
+    for i, c := range t.Columns {
+        value, ok := row[c.Name]
+        if !ok || value == nil {
+            continue
+        }
+        switch c.Kind {
+        case Number:
+            switch v := value.(type) {
+            case float64:
+                values[i] = v
+            case int:
+                values[i] = float64(v)
+            case string:
+                if f, err := strconv.ParseFloat(v, 64); err == nil {
+                    values[i] = f
+                }
+            }
+        default:
+            if s, ok := value.(string); ok {
+                values[i] = s
+            } else {
+                values[i] = fmt.Sprint(value)
+            }
+        }
+    }
+    return values
+}
+
+// formatCell renders a cell as text for CSV.
+func formatCell(value interface{}) string {
+    switch v := value.(type) {
+    case nil:
+        return ""
+    case float64:
+        return strconv.FormatFloat(v, 'f', -1, 64)
+    case string:
+        return v
+    }
+    return fmt.Sprint(value)
+}
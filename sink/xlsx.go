@@ -0,0 +1,89 @@
+package sink
+
+import (
+    "github.com/xuri/excelize/v2"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// xlsxSheet is the name of the worksheet products are written to.
+const xlsxSheet = "Products"
+
+// XLSX writes one row per product to a single worksheet with a header row.
+// Rows are streamed to temporary files rather than held in memory, and the
+// workbook is assembled when the sink is closed or aborted, so a crawl that
+// fails still leaves a readable partial workbook but one that is killed does
+// not.
+type XLSX struct {
+    file  *atomicFile
+    book  *excelize.File
+    sheet *excelize.StreamWriter
+    table Table
+    row   int
+}
+
+// NewXLSX starts an XLSX workbook at path with the columns of table.
+func NewXLSX(path string, table Table) (*XLSX, error) {
+    book := excelize.NewFile()
+    if err := book.SetSheetName("Sheet1", xlsxSheet); err != nil {
+        book.Close()
+        return nil, err
+    }
+    sheet, err := book.NewStreamWriter(xlsxSheet)
+    if err != nil {
+        book.Close()
+        return nil, err
+    }
+    header := make([]interface{}, len(table.Columns))
+    for i, c := range table.Columns {
+        header[i] = c.Name
+    }
+    if err := sheet.SetRow("A1", header); err != nil {
+        book.Close()
+        return nil, err
+    }
+    file, err := createAtomic(path)
+    if err != nil {
+        book.Close()
+        return nil, err
+    }
+    return &XLSX{file: file, book: book, sheet: sheet, table: table, row: 1}, nil
+}
+
+func (s *XLSX) Write(p twe.Product) error {
+    s.row++
+    cell, err := excelize.CoordinatesToCellName(1, s.row)
+    if err != nil {
+        return err
+    }
+    return s.sheet.SetRow(cell, s.table.values(p))
+}
+
+// Flush is a no-op: the workbook is only written out on Close or Abort.
+func (s *XLSX) Flush() error { return nil }
+
+func (s *XLSX) Close() error {
+    if err := s.save(); err != nil {
+        s.file.abort()
+        return err
+    }
+    return s.file.commit()
+}
+
+func (s *XLSX) Abort() error {
+    err := s.save()
+    if abortErr := s.file.abort(); err == nil {
+        err = abortErr
+    }
+    return err
+}
+
+// save assembles the workbook into the partial file.
+func (s *XLSX) save() error {
+    defer s.book.Close()
+    if err := s.sheet.Flush(); err != nil {
+        return err
+    }
+    _, err := s.book.WriteTo(s.file.w)
+    return err
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+    "context"
+    "fmt"
+)
+
+// migrations are applied in order and never edited once released; append a
+// new entry to change the schema. The index+1 is the schema version.
+var migrations = []string{
+    // 1: products, runs and the append-only observation log
+    `CREATE TABLE products (
+        product_id           TEXT PRIMARY KEY,
+        name                 TEXT NOT NULL,
+        description          TEXT NOT NULL DEFAULT '',
+        brand                TEXT NOT NULL DEFAULT '',
+        manufacturer         TEXT NOT NULL DEFAULT '',
+        master_category_name TEXT NOT NULL DEFAULT '',
+        category_name        TEXT NOT NULL DEFAULT '',
+        strength_pc          REAL,
+        size_cl              REAL,
+        url                  TEXT NOT NULL DEFAULT '',
+        image_url            TEXT NOT NULL DEFAULT '',
+        raw_json             TEXT NOT NULL DEFAULT '{}',
+        first_seen           TEXT NOT NULL,
+        last_seen            TEXT NOT NULL
+    );
+
+    CREATE TABLE crawl_runs (
+        id            INTEGER PRIMARY KEY AUTOINCREMENT,
+        started_at    TEXT NOT NULL,
+        finished_at   TEXT,
+        query         TEXT NOT NULL DEFAULT '',
+        product_count INTEGER
+    );
+
+    CREATE TABLE observations (
+        id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+        run_id             INTEGER NOT NULL REFERENCES crawl_runs (id),
+        product_id         TEXT NOT NULL REFERENCES products (product_id),
+        scraped_at         TEXT NOT NULL,
+        sales_price        REAL,
+        sales_price_ex_vat REAL,
+        stock_level        REAL,
+        stock_control      REAL,
+        is_out_of_stock    INTEGER NOT NULL,
+        is_active          INTEGER NOT NULL
+    );
+
+    CREATE INDEX observations_product_time ON observations (product_id, scraped_at);
+    CREATE INDEX observations_run ON observations (run_id);
+
+    CREATE TRIGGER observations_no_update BEFORE UPDATE ON observations
+    BEGIN SELECT RAISE(ABORT, 'observations are append-only'); END;
+
+    CREATE TRIGGER observations_no_delete BEFORE DELETE ON observations
+    BEGIN SELECT RAISE(ABORT, 'observations are append-only'); END;`,
+
+    // 2: versioned snapshots of the site's filter taxonomy
+    `CREATE TABLE taxonomy_snapshots (
+        id       INTEGER PRIMARY KEY AUTOINCREMENT,
+        taken_at TEXT NOT NULL,
+        query    TEXT NOT NULL DEFAULT ''
+    );
+
+    CREATE TABLE taxonomy_facets (
+        snapshot_id INTEGER NOT NULL REFERENCES taxonomy_snapshots (id),
+        position    INTEGER NOT NULL,
+        facet       TEXT NOT NULL,
+        label       TEXT NOT NULL DEFAULT '',
+        PRIMARY KEY (snapshot_id, facet)
+    );
+
+    CREATE TABLE taxonomy_values (
+        snapshot_id   INTEGER NOT NULL REFERENCES taxonomy_snapshots (id),
+        facet         TEXT NOT NULL,
+        position      INTEGER NOT NULL,
+        value         TEXT NOT NULL,
+        label         TEXT NOT NULL DEFAULT '',
+        product_count INTEGER NOT NULL,
+        PRIMARY KEY (snapshot_id, facet, value)
+    );`,
+
+    // 3: the name and description as observed, so edits show up between
+    // runs; NULL in older observations
+    `ALTER TABLE observations ADD COLUMN name TEXT;
+    ALTER TABLE observations ADD COLUMN description TEXT;`,
+}
+
+// migrate applies any migrations newer than the database's user_version.
+func (s *Store) migrate(ctx context.Context) error {
+    var version int
+    if err := s.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+        return err
+    }
+    if version > len(migrations) {
+        return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", version, len(migrations))
+    }
+
+    for i := version; i < len(migrations); i++ {
+        tx, err := s.db.BeginTx(ctx, nil)
+        if err != nil {
+            return err
+        }
+        if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+            tx.Rollback()
+            return fmt.Errorf("migration %d: %w", i+1, err)
+        }
+        // PRAGMA does not accept bound parameters.
+        if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+            tx.Rollback()
+            return fmt.Errorf("migration %d: %w", i+1, err)
+        }
+        if err := tx.Commit(); err != nil {
+            return fmt.Errorf("migration %d: %w", i+1, err)
+        }
+    }
+    return nil
+}
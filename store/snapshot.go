@@ -0,0 +1,115 @@
+package store
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "time"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// RunInfo describes a recorded crawl.
+type RunInfo struct {
+    ID           int64     `json:"id"`
+    StartedAt    time.Time `json:"startedAt"`
+    FinishedAt   time.Time `json:"finishedAt,omitempty"`
+    Query        string    `json:"query"`
+    ProductCount int       `json:"productCount"`
+}
+
+// Finished reports whether the crawl completed.
+func (r RunInfo) Finished() bool {
+    return !r.FinishedAt.IsZero()
+}
+
+const runColumns = `id, started_at, finished_at, query, COALESCE(product_count, 0)`
+
+func scanRun(row interface{ Scan(...interface{}) error }) (RunInfo, error) {
+    var info RunInfo
+    var started string
+    var finished sql.NullString
+    if err := row.Scan(&info.ID, &started, &finished, &info.Query, &info.ProductCount); err != nil {
+        return info, err
+    }
+    var err error
+    if info.StartedAt, err = time.Parse(timeFormat, started); err != nil {
+        return info, fmt.Errorf("run %d: %w", info.ID, err)
+    }
+    if finished.Valid {
+        if info.FinishedAt, err = time.Parse(timeFormat, finished.String); err != nil {
+            return info, fmt.Errorf("run %d: %w", info.ID, err)
+        }
+    }
+    return info, nil
+}
+
+// RunInfo returns run id.
+func (s *Store) RunInfo(ctx context.Context, id int64) (RunInfo, error) {
+    info, err := scanRun(s.db.QueryRowContext(ctx,
+        `SELECT `+runColumns+` FROM crawl_runs WHERE id = ?`, id))
+    if err == sql.ErrNoRows {
+        return info, fmt.Errorf("run %d does not exist", id)
+    }
+    return info, err
+}
+
+// LatestRun returns the most recent finished run, or ok false if no crawl
+// has finished yet.
+func (s *Store) LatestRun(ctx context.Context) (info RunInfo, ok bool, err error) {
+    info, err = scanRun(s.db.QueryRowContext(ctx,
+        `SELECT `+runColumns+` FROM crawl_runs WHERE finished_at IS NOT NULL ORDER BY id DESC LIMIT 1`))
+    if err == sql.ErrNoRows {
+        return info, false, nil
+    }
+    return info, err == nil, err
+}
+
+// PreviousRun returns the last finished run before run with the same query,
+// the one run's changes are measured against, or ok false if there is none.
+func (s *Store) PreviousRun(ctx context.Context, run RunInfo) (info RunInfo, ok bool, err error) {
+    info, err = scanRun(s.db.QueryRowContext(ctx, `
+        SELECT `+runColumns+` FROM crawl_runs
+        WHERE id < ? AND query = ? AND finished_at IS NOT NULL
+        ORDER BY id DESC LIMIT 1`, run.ID, run.Query))
+    if err == sql.ErrNoRows {
+        return info, false, nil
+    }
+    return info, err == nil, err
+}
+
+// Snapshot returns the products observed in run, keyed by ProductID, with
+// their price, stock, name and description as they were then. Observations
+// recorded before names were kept per run fall back to the current name and
+// description.
+func (s *Store) Snapshot(ctx context.Context, runID int64) (map[string]twe.Product, error) {
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT o.product_id, o.scraped_at, o.sales_price, o.sales_price_ex_vat,
+               o.stock_level, o.stock_control, o.is_out_of_stock, o.is_active,
+               COALESCE(o.name, p.name), COALESCE(o.description, p.description)
+        FROM observations o JOIN products p ON p.product_id = o.product_id
+        WHERE o.run_id = ?
+        ORDER BY o.id`, runID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    products := make(map[string]twe.Product)
+    for rows.Next() {
+        var p twe.Product
+        var scraped string
+        var price, exVat, stockLevel, stockControl sql.NullFloat64
+        if err := rows.Scan(&p.ProductID, &scraped, &price, &exVat, &stockLevel, &stockControl,
+            &p.IsOutOfStock, &p.IsActive, &p.Name, &p.Description); err != nil {
+            return nil, err
+        }
+        if p.ScrapedDate, err = time.Parse(timeFormat, scraped); err != nil {
+            return nil, fmt.Errorf("observation for %s has bad timestamp %q: %w", p.ProductID, scraped, err)
+        }
+        p.SalesPrice, p.SalesPriceExVat = price.Float64, exVat.Float64
+        p.StockLevel, p.StockControl = stockLevel.Float64, stockControl.Float64
+        products[p.ProductID] = p
+    }
+    return products, rows.Err()
+}
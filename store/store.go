@@ -0,0 +1,231 @@
+// Package store keeps a local SQLite history of every crawl: one row per
+// product plus an append-only observation of its price and stock per run,
+// and versioned snapshots of the site's filter taxonomy.
+package store
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "time"
+
+    _ "modernc.org/sqlite" // pure Go driver, registers "sqlite"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// timeFormat is used for every timestamp column so they sort as text.
+const timeFormat = time.RFC3339Nano
+
+// Store is an open history database.
+type Store struct {
+    db *sql.DB
+}
+
+// Open opens (creating if needed) the database at path and brings its schema
+// up to date.
+func Open(path string) (*Store, error) {
+    db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+    if err != nil {
+        return nil, err
+    }
+    // SQLite allows a single writer; one connection avoids SQLITE_BUSY
+    // between our own goroutines.
+    db.SetMaxOpenConns(1)
+
+    s := &Store{db: db}
+    if err := s.migrate(context.Background()); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("migrating %s: %w", path, err)
+    }
+    return s, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+    return s.db.Close()
+}
+
+// Run is one crawl being recorded.
+type Run struct {
+    ID        int64
+    StartedAt time.Time
+    store     *Store
+}
+
+// BeginRun records the start of a crawl. query is stored for reference.
+func (s *Store) BeginRun(ctx context.Context, query string) (*Run, error) {
+    started := time.Now().UTC()
+    res, err := s.db.ExecContext(ctx,
+        `INSERT INTO crawl_runs (started_at, query) VALUES (?, ?)`,
+        started.Format(timeFormat), query)
+    if err != nil {
+        return nil, err
+    }
+    id, err := res.LastInsertId()
+    if err != nil {
+        return nil, err
+    }
+    return &Run{ID: id, StartedAt: started, store: s}, nil
+}
+
+// ResumeRun reopens an unfinished run, so a resumed crawl keeps recording
+// into the run it started.
+func (s *Store) ResumeRun(ctx context.Context, id int64) (*Run, error) {
+    var started string
+    var finished sql.NullString
+    err := s.db.QueryRowContext(ctx,
+        `SELECT started_at, finished_at FROM crawl_runs WHERE id = ?`, id).Scan(&started, &finished)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("run %d does not exist", id)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if finished.Valid {
+        return nil, fmt.Errorf("run %d already finished at %s", id, finished.String)
+    }
+    startedAt, err := time.Parse(timeFormat, started)
+    if err != nil {
+        return nil, fmt.Errorf("run %d: %w", id, err)
+    }
+    return &Run{ID: id, StartedAt: startedAt, store: s}, nil
+}
+
+// Record upserts the products and appends one observation per product, all
+// in a single transaction.
+func (r *Run) Record(ctx context.Context, products []twe.Product) error {
+    tx, err := r.store.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    upsert, err := tx.PrepareContext(ctx, `
+        INSERT INTO products (
+            product_id, name, description, brand, manufacturer, master_category_name,
+            category_name, strength_pc, size_cl, url, image_url, raw_json, first_seen, last_seen
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (product_id) DO UPDATE SET
+            name = excluded.name,
+            description = excluded.description,
+            brand = excluded.brand,
+            manufacturer = excluded.manufacturer,
+            master_category_name = excluded.master_category_name,
+            category_name = excluded.category_name,
+            strength_pc = excluded.strength_pc,
+            size_cl = excluded.size_cl,
+            url = excluded.url,
+            image_url = excluded.image_url,
+            raw_json = excluded.raw_json,
+            last_seen = excluded.last_seen`)
+    if err != nil {
+        return err
+    }
+    defer upsert.Close()
+
+    observe, err := tx.PrepareContext(ctx, `
+        INSERT INTO observations (
+            run_id, product_id, scraped_at, sales_price, sales_price_ex_vat,
+            stock_level, stock_control, is_out_of_stock, is_active, name, description
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+    if err != nil {
+        return err
+    }
+    defer observe.Close()
+
+    for _, p := range products {
+        scraped := p.ScrapedDate
+        if scraped.IsZero() {
+            scraped = time.Now().UTC()
+        }
+        seen := scraped.Format(timeFormat)
+        raw, err := json.Marshal(p)
+        if err != nil {
+            return fmt.Errorf("encoding product %s: %w", p.ProductID, err)
+        }
+
+        if _, err := upsert.ExecContext(ctx,
+            p.ProductID, p.Name, p.Description, p.Brand, p.Manufacturer, p.MasterCategoryName,
+            p.CategoryName, p.StrengthInPC, p.SizeInCL, p.URL, p.ProductImageUrl, string(raw), seen, seen,
+        ); err != nil {
+            return fmt.Errorf("saving product %s: %w", p.ProductID, err)
+        }
+        if _, err := observe.ExecContext(ctx,
+            r.ID, p.ProductID, seen, p.SalesPrice, p.SalesPriceExVat,
+            p.StockLevel, p.StockControl, p.IsOutOfStock, p.IsActive, p.Name, p.Description,
+        ); err != nil {
+            return fmt.Errorf("saving observation for %s: %w", p.ProductID, err)
+        }
+    }
+    return tx.Commit()
+}
+
+// Observed returns the IDs of the products the run has an observation for.
+func (r *Run) Observed(ctx context.Context) (map[string]bool, error) {
+    rows, err := r.store.db.QueryContext(ctx, `SELECT DISTINCT product_id FROM observations WHERE run_id = ?`, r.ID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    seen := make(map[string]bool)
+    for rows.Next() {
+        var id string
+        if err := rows.Scan(&id); err != nil {
+            return nil, err
+        }
+        seen[id] = true
+    }
+    return seen, rows.Err()
+}
+
+// Finish marks the run as complete with the number of products collected.
+func (r *Run) Finish(ctx context.Context, productCount int) error {
+    _, err := r.store.db.ExecContext(ctx,
+        `UPDATE crawl_runs SET finished_at = ?, product_count = ? WHERE id = ?`,
+        time.Now().UTC().Format(timeFormat), productCount, r.ID)
+    return err
+}
+
+// Observation is one recorded price and stock reading for a product.
+type Observation struct {
+    RunID           int64
+    ProductID       string
+    ScrapedAt       time.Time
+    SalesPrice      float64
+    SalesPriceExVat float64
+    StockLevel      float64
+    StockControl    float64
+    IsOutOfStock    bool
+    IsActive        bool
+}
+
+// History returns every observation for productID, oldest first.
+func (s *Store) History(ctx context.Context, productID string) ([]Observation, error) {
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT run_id, product_id, scraped_at, sales_price, sales_price_ex_vat,
+               stock_level, stock_control, is_out_of_stock, is_active
+        FROM observations
+        WHERE product_id = ?
+        ORDER BY scraped_at, id`, productID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var history []Observation
+    for rows.Next() {
+        var o Observation
+        var scraped string
+        if err := rows.Scan(&o.RunID, &o.ProductID, &scraped, &o.SalesPrice, &o.SalesPriceExVat,
+            &o.StockLevel, &o.StockControl, &o.IsOutOfStock, &o.IsActive); err != nil {
+            return nil, err
+        }
+        if o.ScrapedAt, err = time.Parse(timeFormat, scraped); err != nil {
+            return nil, fmt.Errorf("observation for %s has bad timestamp %q: %w", productID, scraped, err)
+        }
+        history = append(history, o)
+    }
+    return history, rows.Err()
+}
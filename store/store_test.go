@@ -0,0 +1,274 @@
+package store
+
+import (
+    "context"
+    "database/sql"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+func openStore(t *testing.T) *Store {
+    t.Helper()
+    s, err := Open(filepath.Join(t.TempDir(), "history.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { s.Close() })
+    return s
+}
+
+func userVersion(t *testing.T, db *sql.DB) int {
+    t.Helper()
+    var version int
+    if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+        t.Fatal(err)
+    }
+    return version
+}
+
+func TestOpenMigrates(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "history.db")
+    for i := 0; i < 2; i++ {
+        s, err := Open(path)
+        if err != nil {
+            t.Fatalf("Open %d: %v", i+1, err)
+        }
+        if v := userVersion(t, s.db); v != len(migrations) {
+            t.Errorf("Open %d: user_version = %d, want %d", i+1, v, len(migrations))
+        }
+        for _, table := range []string{"products", "crawl_runs", "observations", "taxonomy_snapshots", "taxonomy_facets", "taxonomy_values"} {
+            var n int
+            if err := s.db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+                t.Errorf("Open %d: table %s: %v", i+1, table, err)
+            }
+        }
+        s.Close()
+    }
+}
+
+func TestOpenRejectsNewerSchema(t *testing.T) {
+    s := openStore(t)
+    if _, err := s.db.Exec(`PRAGMA user_version = 99`); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.migrate(context.Background()); err == nil || !strings.Contains(err.Error(), "schema version 99 is newer") {
+        t.Errorf("migrate error = %v, want the newer schema refused", err)
+    }
+}
+
+// TestOpenMigratesV1Database upgrades a database written before observations
+// kept the name and description, whose snapshots then fall back to the
+// product's current name and description.
+func TestOpenMigratesV1Database(t *testing.T) {
+    ctx := context.Background()
+    path := filepath.Join(t.TempDir(), "history.db")
+    db, err := sql.Open("sqlite", "file:"+path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, stmt := range []string{
+        migrations[0],
+        `PRAGMA user_version = 1`,
+        `INSERT INTO crawl_runs (started_at, finished_at, query, product_count)
+            VALUES ('2026-10-01T10:00:00Z', '2026-10-01T10:05:00Z', 'default', 1)`,
+        `INSERT INTO products (product_id, name, description, first_seen, last_seen)
+            VALUES ('7', 'Ardbeg 10', 'Smoky', '2026-10-01T10:01:00Z', '2026-10-01T10:01:00Z')`,
+        `INSERT INTO observations (run_id, product_id, scraped_at, sales_price, stock_level, is_out_of_stock, is_active)
+            VALUES (1, '7', '2026-10-01T10:01:00Z', 49.5, 12, 0, 1)`,
+    } {
+        if _, err := db.Exec(stmt); err != nil {
+            t.Fatalf("%s: %v", stmt, err)
+        }
+    }
+    db.Close()
+
+    s, err := Open(path)
+    if err != nil {
+        t.Fatalf("Open: %v", err)
+    }
+    defer s.Close()
+    if v := userVersion(t, s.db); v != len(migrations) {
+        t.Errorf("user_version = %d, want %d", v, len(migrations))
+    }
+
+    old, err := s.Snapshot(ctx, 1)
+    if err != nil {
+        t.Fatalf("Snapshot: %v", err)
+    }
+    if p := old["7"]; p.Name != "Ardbeg 10" || p.Description != "Smoky" || p.SalesPrice != 49.5 || p.StockLevel != 12 || !p.IsActive {
+        t.Errorf("migrated snapshot = %+v, want Ardbeg 10 at £49.50", p)
+    }
+
+    // Later runs rename the product: the migrated run shows the current
+    // name, the others the name they observed.
+    var runs []int64
+    for _, p := range []twe.Product{
+        {ProductID: "7", Name: "Ardbeg Ten", Description: "Peaty", SalesPrice: 45},
+        {ProductID: "7", Name: "Ardbeg 10 Year Old", Description: "Smoky and peaty", SalesPrice: 45},
+    } {
+        run, err := s.BeginRun(ctx, "default")
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := run.Record(ctx, []twe.Product{p}); err != nil {
+            t.Fatalf("Record: %v", err)
+        }
+        runs = append(runs, run.ID)
+    }
+    for _, tt := range []struct {
+        runID             int64
+        name, description string
+    }{
+        {1, "Ardbeg 10 Year Old", "Smoky and peaty"},
+        {runs[0], "Ardbeg Ten", "Peaty"},
+        {runs[1], "Ardbeg 10 Year Old", "Smoky and peaty"},
+    } {
+        snapshot, err := s.Snapshot(ctx, tt.runID)
+        if err != nil {
+            t.Fatalf("Snapshot(%d): %v", tt.runID, err)
+        }
+        if p := snapshot["7"]; p.Name != tt.name || p.Description != tt.description {
+            t.Errorf("run %d: product 7 = %q, %q; want %q, %q", tt.runID, p.Name, p.Description, tt.name, tt.description)
+        }
+    }
+}
+
+func TestObservationsAreAppendOnly(t *testing.T) {
+    ctx := context.Background()
+    s := openStore(t)
+    run, err := s.BeginRun(ctx, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := run.Record(ctx, []twe.Product{{ProductID: "7", Name: "Ardbeg 10", SalesPrice: 49.5}}); err != nil {
+        t.Fatal(err)
+    }
+    for _, stmt := range []string{
+        `UPDATE observations SET sales_price = 1`,
+        `DELETE FROM observations`,
+    } {
+        if _, err := s.db.Exec(stmt); err == nil || !strings.Contains(err.Error(), "append-only") {
+            t.Errorf("%s: error = %v, want it refused", stmt, err)
+        }
+    }
+    history, err := s.History(ctx, "7")
+    if err != nil || len(history) != 1 || history[0].SalesPrice != 49.5 {
+        t.Errorf("History = %+v, %v; want the observation untouched", history, err)
+    }
+}
+
+func TestRecordAndHistory(t *testing.T) {
+    ctx := context.Background()
+    s := openStore(t)
+    day := func(d int) time.Time { return time.Date(2026, 10, d, 10, 0, 0, 0, time.UTC) }
+
+    first, err := s.BeginRun(ctx, "default")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := first.Record(ctx, []twe.Product{
+        {ProductID: "7", Name: "Ardbeg 10", SalesPrice: 49.5, SalesPriceExVat: 41.25, StockLevel: 12, IsActive: true, ScrapedDate: day(1)},
+        {ProductID: "8", Name: "Laphroaig 10", SalesPrice: 39, ScrapedDate: day(1)},
+    }); err != nil {
+        t.Fatalf("Record: %v", err)
+    }
+    if err := first.Finish(ctx, 2); err != nil {
+        t.Fatal(err)
+    }
+
+    second, err := s.BeginRun(ctx, "default")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := second.Record(ctx, []twe.Product{{ProductID: "7", Name: "Ardbeg 10", SalesPrice: 45, IsOutOfStock: true, ScrapedDate: day(2)}}); err != nil {
+        t.Fatalf("Record: %v", err)
+    }
+
+    history, err := s.History(ctx, "7")
+    if err != nil {
+        t.Fatalf("History: %v", err)
+    }
+    want := []Observation{
+        {RunID: first.ID, ProductID: "7", ScrapedAt: day(1), SalesPrice: 49.5, SalesPriceExVat: 41.25, StockLevel: 12, IsActive: true},
+        {RunID: second.ID, ProductID: "7", ScrapedAt: day(2), SalesPrice: 45, IsOutOfStock: true},
+    }
+    if !reflect.DeepEqual(history, want) {
+        t.Errorf("History =\n%+v\nwant\n%+v", history, want)
+    }
+    if history, err := s.History(ctx, "404"); err != nil || len(history) != 0 {
+        t.Errorf("History of an unknown product = %+v, %v", history, err)
+    }
+
+    var firstSeen, lastSeen string
+    if err := s.db.QueryRow(`SELECT first_seen, last_seen FROM products WHERE product_id = '7'`).Scan(&firstSeen, &lastSeen); err != nil {
+        t.Fatal(err)
+    }
+    if firstSeen != day(1).Format(timeFormat) || lastSeen != day(2).Format(timeFormat) {
+        t.Errorf("product 7 seen %s to %s, want %s to %s", firstSeen, lastSeen, day(1), day(2))
+    }
+
+    // The unfinished second run can be resumed, the finished first cannot.
+    resumed, err := s.ResumeRun(ctx, second.ID)
+    if err != nil {
+        t.Fatalf("ResumeRun: %v", err)
+    }
+    if resumed.ID != second.ID || !resumed.StartedAt.Equal(second.StartedAt) {
+        t.Errorf("resumed run %d started %s, want run %d started %s", resumed.ID, resumed.StartedAt, second.ID, second.StartedAt)
+    }
+    if err := resumed.Record(ctx, []twe.Product{{ProductID: "8", Name: "Laphroaig 10", SalesPrice: 39, ScrapedDate: day(2)}}); err != nil {
+        t.Fatalf("Record after resuming: %v", err)
+    }
+    observed, err := resumed.Observed(ctx)
+    if err != nil || !reflect.DeepEqual(observed, map[string]bool{"7": true, "8": true}) {
+        t.Errorf("Observed = %v, %v; want both products", observed, err)
+    }
+    if _, err := s.ResumeRun(ctx, first.ID); err == nil || !strings.Contains(err.Error(), "already finished") {
+        t.Errorf("ResumeRun of a finished run: error = %v", err)
+    }
+    if _, err := s.ResumeRun(ctx, 99); err == nil || !strings.Contains(err.Error(), "does not exist") {
+        t.Errorf("ResumeRun of a missing run: error = %v", err)
+    }
+}
+
+func TestRunQueries(t *testing.T) {
+    ctx := context.Background()
+    s := openStore(t)
+    if _, ok, err := s.LatestRun(ctx); ok || err != nil {
+        t.Fatalf("LatestRun of an empty database = %v, %v", ok, err)
+    }
+    var ids []int64
+    for _, query := range []string{"islay", "default", "islay", "islay"} {
+        run, err := s.BeginRun(ctx, query)
+        if err != nil {
+            t.Fatal(err)
+        }
+        ids = append(ids, run.ID)
+        // The last run is left unfinished.
+        if len(ids) < 4 {
+            if err := run.Finish(ctx, len(ids)); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+
+    latest, ok, err := s.LatestRun(ctx)
+    if err != nil || !ok || latest.ID != ids[2] || latest.ProductCount != 3 || !latest.Finished() {
+        t.Errorf("LatestRun = %+v, %v, %v; want run %d", latest, ok, err, ids[2])
+    }
+    current, err := s.RunInfo(ctx, ids[3])
+    if err != nil || current.Finished() || current.Query != "islay" {
+        t.Fatalf("RunInfo = %+v, %v", current, err)
+    }
+    previous, ok, err := s.PreviousRun(ctx, current)
+    if err != nil || !ok || previous.ID != ids[2] {
+        t.Errorf("PreviousRun = %+v, %v, %v; want run %d", previous, ok, err, ids[2])
+    }
+    if _, ok, err := s.PreviousRun(ctx, RunInfo{ID: ids[1], Query: "default"}); ok || err != nil {
+        t.Errorf("PreviousRun of the first default run = %v, %v; want none", ok, err)
+    }
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "time"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// TaxonomySnapshot is one harvest of the filters the site offers.
+type TaxonomySnapshot struct {
+    ID      int64
+    TakenAt time.Time
+    Query   string
+    Facets  []twe.Facet
+}
+
+// SaveTaxonomy stores facets as a new snapshot taken for query.
+func (s *Store) SaveTaxonomy(ctx context.Context, query string, facets []twe.Facet) (*TaxonomySnapshot, error) {
+    snapshot := &TaxonomySnapshot{TakenAt: time.Now().UTC(), Query: query, Facets: facets}
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    res, err := tx.ExecContext(ctx,
+        `INSERT INTO taxonomy_snapshots (taken_at, query) VALUES (?, ?)`,
+        snapshot.TakenAt.Format(timeFormat), query)
+    if err != nil {
+        return nil, err
+    }
+    if snapshot.ID, err = res.LastInsertId(); err != nil {
+        return nil, err
+    }
+
+    facetStmt, err := tx.PrepareContext(ctx,
+        `INSERT INTO taxonomy_facets (snapshot_id, position, facet, label) VALUES (?, ?, ?, ?)`)
+    if err != nil {
+        return nil, err
+    }
+    defer facetStmt.Close()
+    valueStmt, err := tx.PrepareContext(ctx,
+        `INSERT INTO taxonomy_values (snapshot_id, facet, position, value, label, product_count) VALUES (?, ?, ?, ?, ?, ?)`)
+    if err != nil {
+        return nil, err
+    }
+    defer valueStmt.Close()
+
+    for i, facet := range facets {
+        if _, err := facetStmt.ExecContext(ctx, snapshot.ID, i, facet.Name, facet.Label); err != nil {
+            return nil, fmt.Errorf("facet %s: %w", facet.Name, err)
+        }
+        for j, value := range facet.Values {
+            if _, err := valueStmt.ExecContext(ctx, snapshot.ID, facet.Name, j, value.Value, value.Label, value.Count); err != nil {
+                return nil, fmt.Errorf("facet %s value %s: %w", facet.Name, value.Value, err)
+            }
+        }
+    }
+    return snapshot, tx.Commit()
+}
+
+// LatestTaxonomy returns the newest snapshot taken for query, or nil when
+// there is none.
+func (s *Store) LatestTaxonomy(ctx context.Context, query string) (*TaxonomySnapshot, error) {
+    var id int64
+    err := s.db.QueryRowContext(ctx,
+        `SELECT id FROM taxonomy_snapshots WHERE query = ? ORDER BY id DESC LIMIT 1`, query).Scan(&id)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return s.Taxonomy(ctx, id)
+}
+
+// Taxonomy returns snapshot id with all its facets and values.
+func (s *Store) Taxonomy(ctx context.Context, id int64) (*TaxonomySnapshot, error) {
+    snapshot := &TaxonomySnapshot{ID: id}
+    var taken string
+    err := s.db.QueryRowContext(ctx,
+        `SELECT taken_at, query FROM taxonomy_snapshots WHERE id = ?`, id).Scan(&taken, &snapshot.Query)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, fmt.Errorf("no taxonomy snapshot %d", id)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if snapshot.TakenAt, err = time.Parse(timeFormat, taken); err != nil {
+        return nil, fmt.Errorf("taxonomy snapshot %d has bad timestamp %q: %w", id, taken, err)
+    }
+
+    rows, err := s.db.QueryContext(ctx, `
+        SELECT f.facet, f.label, v.value, v.label, v.product_count
+        FROM taxonomy_facets f
+        LEFT JOIN taxonomy_values v ON v.snapshot_id = f.snapshot_id AND v.facet = f.facet
+        WHERE f.snapshot_id = ?
+        ORDER BY f.position, v.position`, id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var facet, facetLabel string
+        var value, label sql.NullString
+        var count sql.NullInt64
+        if err := rows.Scan(&facet, &facetLabel, &value, &label, &count); err != nil {
+            return nil, err
+        }
+        if n := len(snapshot.Facets); n == 0 || snapshot.Facets[n-1].Name != facet {
+            snapshot.Facets = append(snapshot.Facets, twe.Facet{Name: facet, Label: facetLabel})
+        }
+        if value.Valid {
+            last := &snapshot.Facets[len(snapshot.Facets)-1]
+            last.Values = append(last.Values, twe.FacetValue{Value: value.String, Label: label.String, Count: int(count.Int64)})
+        }
+    }
+    return snapshot, rows.Err()
+}
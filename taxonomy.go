@@ -0,0 +1,129 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "time"
+
+    "theWhiskyExchangeCrawler/config"
+    "theWhiskyExchangeCrawler/store"
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// runTaxonomy harvests the filters the site offers (categories, brands,
+// countries, regions, cask types, ... with their IDs and product counts),
+// stores them as a new snapshot in the history database and reports what
+// was added or removed since the previous snapshot for the same query.
+func runTaxonomy(args []string) {
+    fs := flag.NewFlagSet("taxonomy", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: theWhiskyExchangeCrawler taxonomy [flags]")
+        fs.PrintDefaults()
+    }
+    queryExpr := fs.String("query", "", "filters whose facets are harvested (default the whole catalogue)")
+    jsonPath := fs.String("json", "", "also write the harvested taxonomy to this JSON file")
+    dryRun := fs.Bool("dry-run", false, "compare with the latest snapshot without storing a new one")
+    configFlags := config.RegisterFlags(fs)
+    fs.Parse(args)
+
+    cfg := loadConfig(configFlags)
+    if err := cfg.RequireAPIToken(); err != nil {
+        fmt.Fprintln(stderr, "Configuration error:", err)
+        os.Exit(exitUsage)
+    }
+    if cfg.Database.Path == "" {
+        fmt.Fprintln(stderr, "No history database configured (database.path / -db).")
+        os.Exit(exitUsage)
+    }
+    query, err := buildQuery(*queryExpr, "", 0, "")
+    if err != nil {
+        fmt.Fprintln(stderr, "Invalid query:", err)
+        os.Exit(exitUsage)
+    }
+
+    db, err := store.Open(cfg.Database.Path)
+    if err != nil {
+        log.Fatalf("Error opening history database: %v", err)
+    }
+    defer db.Close()
+
+    ctx := context.Background()
+    facets, err := newTWEClient(cfg, nil).Facets(ctx, query)
+    if err != nil {
+        log.Printf("Harvesting the taxonomy failed: %v", err)
+        db.Close()
+        os.Exit(failureStatus(err))
+    }
+    values := 0
+    for _, facet := range facets {
+        values += len(facet.Values)
+    }
+    fmt.Fprintf(stdout, "Harvested %d facets with %d values\n", len(facets), values)
+
+    if *jsonPath != "" {
+        data, err := json.MarshalIndent(facets, "", "  ")
+        if err == nil {
+            err = os.WriteFile(*jsonPath, append(data, '\n'), 0o644)
+        }
+        if err != nil {
+            log.Fatalf("Error writing %s: %v", *jsonPath, err)
+        }
+        fmt.Fprintf(stdout, "Wrote %s\n", *jsonPath)
+    }
+
+    previous, err := db.LatestTaxonomy(ctx, query.String())
+    if err != nil {
+        log.Fatalf("Error reading the previous taxonomy: %v", err)
+    }
+    if previous == nil {
+        fmt.Fprintln(stdout, "No earlier snapshot for this query to compare with.")
+    } else {
+        printTaxonomyDiff(previous, twe.DiffTaxonomy(previous.Facets, facets))
+    }
+
+    if *dryRun {
+        return
+    }
+    snapshot, err := db.SaveTaxonomy(ctx, query.String(), facets)
+    if err != nil {
+        log.Fatalf("Error saving the taxonomy: %v", err)
+    }
+    fmt.Fprintf(stdout, "Saved as snapshot %d in %s\n", snapshot.ID, cfg.Database.Path)
+}
+
+func printTaxonomyDiff(previous *store.TaxonomySnapshot, diff twe.TaxonomyDiff) {
+    since := fmt.Sprintf("snapshot %d (%s)", previous.ID, previous.TakenAt.Local().Format(time.RFC822))
+    if diff.Empty() {
+        fmt.Fprintf(stdout, "No facets or values added or removed since %s\n", since)
+        return
+    }
+    fmt.Fprintf(stdout, "Changes since %s:\n", since)
+    for _, c := range diff.AddedFacets {
+        fmt.Fprintf(stdout, "  + facet %s\n", c.Facet)
+    }
+    for _, c := range diff.RemovedFacets {
+        fmt.Fprintf(stdout, "  - facet %s\n", c.Facet)
+    }
+    for _, c := range diff.AddedValues {
+        fmt.Fprintf(stdout, "  + %s: %s, %d products\n", c.Facet, describeFacetValue(c.Value), c.Value.Count)
+    }
+    for _, c := range diff.RemovedValues {
+        fmt.Fprintf(stdout, "  - %s: %s\n", c.Facet, describeFacetValue(c.Value))
+    }
+    for _, c := range diff.Relabelled {
+        fmt.Fprintf(stdout, "  ~ %s: %s renamed %q -> %q\n", c.Facet, c.Value.Value, c.Was, c.Value.Label)
+    }
+}
+
+// describeFacetValue names a value by its label, adding the filter value
+// (usually an ID) when that differs.
+func describeFacetValue(v twe.FacetValue) string {
+    if v.Label != "" && v.Label != v.Value {
+        return fmt.Sprintf("%s (%s)", v.Label, v.Value)
+    }
+    return v.Value
+}
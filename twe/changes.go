@@ -0,0 +1,195 @@
+package twe
+
+import (
+    "fmt"
+    "math"
+    "sort"
+)
+
+// ChangeKind classifies a ProductChange.
+type ChangeKind string
+
+const (
+    ChangeNew         ChangeKind = "new"
+    ChangeRemoved     ChangeKind = "removed"
+    ChangePriceDown   ChangeKind = "price_down"
+    ChangePriceUp     ChangeKind = "price_up"
+    ChangeExVat       ChangeKind = "ex_vat_changed"
+    ChangeOutOfStock  ChangeKind = "out_of_stock"
+    ChangeRestocked   ChangeKind = "restocked"
+    ChangeStockLevel  ChangeKind = "stock_level"
+    ChangeRenamed     ChangeKind = "renamed"
+    ChangeDescription ChangeKind = "description_changed"
+)
+
+// ChangeKinds lists every kind in report order.
+var ChangeKinds = []ChangeKind{
+    ChangeNew, ChangeRemoved, ChangePriceDown, ChangePriceUp, ChangeExVat,
+    ChangeOutOfStock, ChangeRestocked, ChangeStockLevel, ChangeRenamed, ChangeDescription,
+}
+
+var changeHeadings = map[ChangeKind]string{
+    ChangeNew:         "new products",
+    ChangeRemoved:     "removed",
+    ChangePriceDown:   "price drops",
+    ChangePriceUp:     "price rises",
+    ChangeExVat:       "ex-VAT price changes",
+    ChangeOutOfStock:  "went out of stock",
+    ChangeRestocked:   "restocked",
+    ChangeStockLevel:  "stock level changes",
+    ChangeRenamed:     "renamed",
+    ChangeDescription: "description edits",
+}
+
+// Heading introduces the changes of kind k in a summary, e.g. "price
+// drops".
+func (k ChangeKind) Heading() string {
+    if heading, ok := changeHeadings[k]; ok {
+        return heading
+    }
+    return string(k)
+}
+
+// ProductChange is one difference in a product between two crawls. A
+// product can have several, e.g. a price drop and a restock.
+type ProductChange struct {
+    Kind      ChangeKind `json:"kind"`
+    ProductID string     `json:"productId"`
+    Name      string     `json:"name"`
+    // Old and New hold the changed value: a price, stock level or text.
+    // New products have no Old and removed products no New.
+    Old interface{} `json:"old,omitempty"`
+    New interface{} `json:"new,omitempty"`
+    // Delta and Percent are set for price and stock level changes. Percent
+    // is relative to Old and left out when Old is zero.
+    Delta   float64  `json:"delta,omitempty"`
+    Percent *float64 `json:"percent,omitempty"`
+    // Product is the product as the later crawl saw it, or as the earlier
+    // one did for a removal.
+    Product Product `json:"-"`
+}
+
+// Describe renders c on one line for a summary, without its ProductID.
+func (c ProductChange) Describe() string {
+    switch c.Kind {
+    case ChangeNew:
+        return fmt.Sprintf("%s  £%.2f", c.Name, c.New)
+    case ChangeRemoved:
+        return c.Name
+    case ChangePriceDown, ChangePriceUp, ChangeExVat:
+        s := fmt.Sprintf("%s  £%.2f -> £%.2f (%+.2f", c.Name, c.Old, c.New, c.Delta)
+        if c.Percent != nil {
+            s += fmt.Sprintf(", %+.1f%%", *c.Percent)
+        }
+        return s + ")"
+    case ChangeOutOfStock, ChangeRestocked, ChangeStockLevel:
+        return fmt.Sprintf("%s  stock %v -> %v", c.Name, c.Old, c.New)
+    case ChangeRenamed:
+        return fmt.Sprintf("%q -> %q", c.Old, c.New)
+    case ChangeDescription:
+        return c.Name + "  description changed"
+    }
+    return c.Name
+}
+
+// priceEpsilon absorbs float noise in prices, which have two decimals.
+const priceEpsilon = 0.005
+
+// DiffProducts compares two crawls keyed by ProductID. Changes are ordered
+// by ChangeKinds, then by ProductID. An ex-VAT change is only reported when
+// the price itself did not change, and a stock level change only when the
+// product did not go out of stock or come back.
+func DiffProducts(before, after map[string]Product) []ProductChange {
+    var changes []ProductChange
+    for id, now := range after {
+        was, ok := before[id]
+        if !ok {
+            changes = append(changes, ProductChange{Kind: ChangeNew, ProductID: id, Name: now.Name, New: now.SalesPrice, Product: now})
+            continue
+        }
+        changes = append(changes, diffProduct(was, now)...)
+    }
+    for id, was := range before {
+        if _, ok := after[id]; !ok {
+            changes = append(changes, ProductChange{Kind: ChangeRemoved, ProductID: id, Name: was.Name, Old: was.SalesPrice, Product: was})
+        }
+    }
+
+    rank := make(map[ChangeKind]int, len(ChangeKinds))
+    for i, kind := range ChangeKinds {
+        rank[kind] = i
+    }
+    sort.Slice(changes, func(i, j int) bool {
+        if changes[i].Kind != changes[j].Kind {
+            return rank[changes[i].Kind] < rank[changes[j].Kind]
+        }
+        return LessProductID(changes[i].ProductID, changes[j].ProductID)
+    })
+    return changes
+}
+
+func diffProduct(was, now Product) []ProductChange {
+    var changes []ProductChange
+    change := func(kind ChangeKind, old, new interface{}) *ProductChange {
+        changes = append(changes, ProductChange{Kind: kind, ProductID: now.ProductID, Name: now.Name, Old: old, New: new, Product: now})
+        return &changes[len(changes)-1]
+    }
+    numeric := func(kind ChangeKind, old, new float64) {
+        c := change(kind, old, new)
+        c.Delta = round(new-old, 2)
+        if old != 0 {
+            percent := round((new-old)/old*100, 1)
+            c.Percent = &percent
+        }
+    }
+
+    priceChanged := math.Abs(now.SalesPrice-was.SalesPrice) >= priceEpsilon
+    switch {
+    case priceChanged && now.SalesPrice < was.SalesPrice:
+        numeric(ChangePriceDown, was.SalesPrice, now.SalesPrice)
+    case priceChanged:
+        numeric(ChangePriceUp, was.SalesPrice, now.SalesPrice)
+    case math.Abs(now.SalesPriceExVat-was.SalesPriceExVat) >= priceEpsilon:
+        numeric(ChangeExVat, was.SalesPriceExVat, now.SalesPriceExVat)
+    }
+
+    switch {
+    case now.IsOutOfStock && !was.IsOutOfStock:
+        change(ChangeOutOfStock, was.StockLevel, now.StockLevel)
+    case !now.IsOutOfStock && was.IsOutOfStock:
+        change(ChangeRestocked, was.StockLevel, now.StockLevel)
+    case now.StockLevel != was.StockLevel:
+        numeric(ChangeStockLevel, was.StockLevel, now.StockLevel)
+    }
+
+    if now.Name != was.Name {
+        change(ChangeRenamed, was.Name, now.Name)
+    }
+    if now.Description != was.Description {
+        change(ChangeDescription, was.Description, now.Description)
+    }
+    return changes
+}
+
+func round(x float64, places int) float64 {
+    scale := math.Pow(10, float64(places))
+    return math.Round(x*scale) / scale
+}
+
+// LessProductID orders ProductIDs numerically when both are numbers, and
+// as text otherwise.
+func LessProductID(a, b string) bool {
+    if len(a) != len(b) && isDigits(a) && isDigits(b) {
+        return len(a) < len(b)
+    }
+    return a < b
+}
+
+func isDigits(s string) bool {
+    for _, r := range s {
+        if r < '0' || r > '9' {
+            return false
+        }
+    }
+    return s != ""
+}
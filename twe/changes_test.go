@@ -0,0 +1,149 @@
+package twe
+
+import (
+    "reflect"
+    "sort"
+    "testing"
+)
+
+func TestDiffProducts(t *testing.T) {
+    base := Product{ProductID: "1", Name: "Port Ellen", Description: "Smoky", SalesPrice: 100, SalesPriceExVat: 83.33, StockLevel: 10}
+    with := func(edit func(p *Product)) Product {
+        p := base
+        edit(&p)
+        return p
+    }
+    percent := func(p float64) *float64 { return &p }
+
+    tests := []struct {
+        name   string
+        before Product
+        after  Product
+        want   []ProductChange // Product left out
+    }{
+        {name: "unchanged", before: base, after: base},
+        {name: "price noise", before: base, after: with(func(p *Product) { p.SalesPrice = 100.004 })},
+        {
+            name: "price drop", before: base,
+            after: with(func(p *Product) { p.SalesPrice, p.SalesPriceExVat = 79.99, 66.66 }),
+            want:  []ProductChange{{Kind: ChangePriceDown, Old: 100.0, New: 79.99, Delta: -20.01, Percent: percent(-20)}},
+        },
+        {
+            name: "price rise", before: base,
+            after: with(func(p *Product) { p.SalesPrice = 125 }),
+            want:  []ProductChange{{Kind: ChangePriceUp, Old: 100.0, New: 125.0, Delta: 25, Percent: percent(25)}},
+        },
+        {
+            name: "price rise from zero", before: with(func(p *Product) { p.SalesPrice = 0 }),
+            after: base,
+            want:  []ProductChange{{Kind: ChangePriceUp, Old: 0.0, New: 100.0, Delta: 100}},
+        },
+        {
+            name: "ex-VAT only", before: base,
+            after: with(func(p *Product) { p.SalesPriceExVat = 80 }),
+            want:  []ProductChange{{Kind: ChangeExVat, Old: 83.33, New: 80.0, Delta: -3.33, Percent: percent(-4)}},
+        },
+        {
+            name: "out of stock", before: base,
+            after: with(func(p *Product) { p.IsOutOfStock, p.StockLevel = true, 0 }),
+            want:  []ProductChange{{Kind: ChangeOutOfStock, Old: 10.0, New: 0.0}},
+        },
+        {
+            name: "restocked", before: with(func(p *Product) { p.IsOutOfStock, p.StockLevel = true, 0 }),
+            after: base,
+            want:  []ProductChange{{Kind: ChangeRestocked, Old: 0.0, New: 10.0}},
+        },
+        {
+            name: "stock level", before: base,
+            after: with(func(p *Product) { p.StockLevel = 4 }),
+            want:  []ProductChange{{Kind: ChangeStockLevel, Old: 10.0, New: 4.0, Delta: -6, Percent: percent(-60)}},
+        },
+        {
+            name: "several changes in report order", before: base,
+            after: with(func(p *Product) { p.Name, p.Description, p.SalesPrice, p.StockLevel = "Port Ellen 40", "Peaty", 90, 9 }),
+            want: []ProductChange{
+                {Kind: ChangePriceDown, Old: 100.0, New: 90.0, Delta: -10, Percent: percent(-10)},
+                {Kind: ChangeStockLevel, Old: 10.0, New: 9.0, Delta: -1, Percent: percent(-10)},
+                {Kind: ChangeRenamed, Old: "Port Ellen", New: "Port Ellen 40"},
+                {Kind: ChangeDescription, Old: "Smoky", New: "Peaty"},
+            },
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := DiffProducts(map[string]Product{"1": tt.before}, map[string]Product{"1": tt.after})
+            for i := range got {
+                if got[i].Product.ProductID != "1" {
+                    t.Errorf("change %d carries product %+v", i, got[i].Product)
+                }
+                got[i].Product = Product{}
+            }
+            for i := range tt.want {
+                tt.want[i].ProductID, tt.want[i].Name = "1", tt.after.Name
+            }
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("DiffProducts =\n%s\nwant\n%s", describeChanges(got), describeChanges(tt.want))
+            }
+        })
+    }
+}
+
+func TestDiffProductsNewAndRemoved(t *testing.T) {
+    before := map[string]Product{
+        "2":  {ProductID: "2", Name: "Two", SalesPrice: 20},
+        "10": {ProductID: "10", Name: "Ten", SalesPrice: 10},
+        "3":  {ProductID: "3", Name: "Three", SalesPrice: 30},
+    }
+    after := map[string]Product{
+        "3":  {ProductID: "3", Name: "Three", SalesPrice: 25},
+        "11": {ProductID: "11", Name: "Eleven", SalesPrice: 11},
+        "9":  {ProductID: "9", Name: "Nine", SalesPrice: 9},
+    }
+    var got []string
+    for _, c := range DiffProducts(before, after) {
+        got = append(got, string(c.Kind)+" "+c.ProductID+" "+c.Product.Name)
+    }
+    // Kinds in report order, ProductIDs numerically within a kind.
+    want := []string{"new 9 Nine", "new 11 Eleven", "removed 2 Two", "removed 10 Ten", "price_down 3 Three"}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("DiffProducts = %q, want %q", got, want)
+    }
+}
+
+func TestLessProductID(t *testing.T) {
+    ids := []string{"100", "9", "abc", "10", "", "9a", "1"}
+    sort.Slice(ids, func(i, j int) bool { return LessProductID(ids[i], ids[j]) })
+    want := []string{"", "1", "9", "10", "100", "9a", "abc"}
+    if !reflect.DeepEqual(ids, want) {
+        t.Errorf("sorted = %q, want %q", ids, want)
+    }
+}
+
+func TestDescribe(t *testing.T) {
+    percent := -20.0
+    tests := []struct {
+        change ProductChange
+        want   string
+    }{
+        {ProductChange{Kind: ChangeNew, Name: "Ardbeg 10", New: 49.5}, "Ardbeg 10  £49.50"},
+        {ProductChange{Kind: ChangeRemoved, Name: "Ardbeg 10", Old: 49.5}, "Ardbeg 10"},
+        {ProductChange{Kind: ChangePriceDown, Name: "Ardbeg 10", Old: 50.0, New: 40.0, Delta: -10, Percent: &percent}, "Ardbeg 10  £50.00 -> £40.00 (-10.00, -20.0%)"},
+        {ProductChange{Kind: ChangePriceUp, Name: "Ardbeg 10", Old: 0.0, New: 40.0, Delta: 40}, "Ardbeg 10  £0.00 -> £40.00 (+40.00)"},
+        {ProductChange{Kind: ChangeRestocked, Name: "Ardbeg 10", Old: 0.0, New: 12.0}, "Ardbeg 10  stock 0 -> 12"},
+        {ProductChange{Kind: ChangeRenamed, Name: "Ardbeg Ten", Old: "Ardbeg 10", New: "Ardbeg Ten"}, `"Ardbeg 10" -> "Ardbeg Ten"`},
+        {ProductChange{Kind: ChangeDescription, Name: "Ardbeg 10", Old: "Smoky", New: "Peaty"}, "Ardbeg 10  description changed"},
+    }
+    for _, tt := range tests {
+        if got := tt.change.Describe(); got != tt.want {
+            t.Errorf("Describe(%s) = %q, want %q", tt.change.Kind, got, tt.want)
+        }
+    }
+}
+
+func describeChanges(changes []ProductChange) string {
+    s := ""
+    for _, c := range changes {
+        s += "  " + string(c.Kind) + ": " + c.Describe() + "\n"
+    }
+    return s
+}
@@ -0,0 +1,160 @@
+package twe
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "math/rand"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ResponseClass is the broad kind of answer the endpoint gave.
+type ResponseClass int
+
+const (
+    ClassOK           ResponseClass = iota // a JSON product page
+    ClassChallenge                         // a Cloudflare bot challenge or block page
+    ClassRateLimited                       // 429 or Cloudflare rate limiting
+    ClassAuthRejected                      // token or session refused
+    ClassServerError                       // 5xx from the origin
+    ClassUnexpected                        // anything else
+)
+
+func (c ResponseClass) String() string {
+    switch c {
+    case ClassOK:
+        return "ok"
+    case ClassChallenge:
+        return "cloudflare challenge"
+    case ClassRateLimited:
+        return "rate limited"
+    case ClassAuthRejected:
+        return "auth rejected"
+    case ClassServerError:
+        return "server error"
+    }
+    return "unexpected response"
+}
+
+// Retryable reports whether the same request may succeed after waiting.
+func (c ResponseClass) Retryable() bool {
+    return c == ClassRateLimited || c == ClassServerError || c == ClassChallenge
+}
+
+// SessionExpired reports whether the operator has to refresh the session
+// (cookies, customer settings or API token) before crawling again.
+func (c ResponseClass) SessionExpired() bool {
+    return c == ClassChallenge || c == ClassAuthRejected
+}
+
+const (
+    // challengeRetries is how often a Cloudflare challenge is retried before
+    // giving up: it sometimes clears, but usually needs fresh cookies.
+    challengeRetries = 1
+
+    baseBackoff = 2 * time.Second
+    maxBackoff  = 2 * time.Minute
+)
+
+// challengeMarkers appear in Cloudflare's interstitial and block pages.
+// Some also appear in the script Cloudflare injects into ordinary pages, so
+// they only count on the 403 and 503 answers that challenges come with.
+var challengeMarkers = [][]byte{
+    []byte("Just a moment..."),
+    []byte("cf-chl-"),
+    []byte("challenge-platform"),
+    []byte("cf_chl_opt"),
+    []byte("Attention Required! | Cloudflare"),
+    []byte("cf-error-details"),
+}
+
+// Classify decides what kind of response this is. body must already have
+// its Content-Encoding removed. A successful answer is never a challenge
+// unless Cloudflare says so in Cf-Mitigated.
+func Classify(status int, header http.Header, body []byte) ResponseClass {
+    if header.Get("Cf-Mitigated") == "challenge" {
+        return ClassChallenge
+    }
+    blocked := status == http.StatusForbidden || status == http.StatusServiceUnavailable
+    isChallengePage := false
+    for _, marker := range challengeMarkers {
+        if blocked && bytes.Contains(body, marker) {
+            isChallengePage = true
+            break
+        }
+    }
+    // Cloudflare error 1015 is its own rate limiting, not a challenge.
+    if status >= 400 && (bytes.Contains(body, []byte("error code: 1015")) || bytes.Contains(body, []byte("You are being rate limited"))) {
+        return ClassRateLimited
+    }
+
+    switch {
+    case status == http.StatusTooManyRequests:
+        return ClassRateLimited
+    case isChallengePage:
+        return ClassChallenge
+    case status == http.StatusUnauthorized || status == http.StatusForbidden:
+        return ClassAuthRejected
+    case status >= 500:
+        return ClassServerError
+    case status >= 200 && status < 300:
+        trimmed := bytes.TrimSpace(body)
+        if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+            return ClassOK
+        }
+    }
+    return ClassUnexpected
+}
+
+// ResponseError is returned when the endpoint answers with anything other
+// than a JSON product page.
+type ResponseError struct {
+    Class      ResponseClass
+    StatusCode int
+    URL        string
+    RetryAfter time.Duration // from the Retry-After header, if any
+    Snippet    string        // start of the decoded body, for diagnosis
+}
+
+func (e *ResponseError) Error() string {
+    return fmt.Sprintf("%s returned status %d (%s), body starts %q", e.URL, e.StatusCode, e.Class, e.Snippet)
+}
+
+// retryAfter parses a Retry-After header given in seconds or as a date.
+func retryAfter(header http.Header) time.Duration {
+    value := strings.TrimSpace(header.Get("Retry-After"))
+    if value == "" {
+        return 0
+    }
+    if seconds, err := strconv.Atoi(value); err == nil {
+        return time.Duration(seconds) * time.Second
+    }
+    if at, err := http.ParseTime(value); err == nil {
+        return time.Until(at)
+    }
+    return 0
+}
+
+// networkError marks a failure to get any response at all.
+type networkError struct{ err error }
+
+func (e *networkError) Error() string { return e.err.Error() }
+func (e *networkError) Unwrap() error { return e.err }
+
+func isNetworkError(err error) bool {
+    var netErr *networkError
+    return errors.As(err, &netErr)
+}
+
+// backoff returns the delay before retry number attempt+1: exponential with
+// jitter, capped at maxBackoff.
+func backoff(attempt int) time.Duration {
+    d := baseBackoff << uint(attempt)
+    if d <= 0 || d > maxBackoff {
+        d = maxBackoff
+    }
+    return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
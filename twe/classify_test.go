@@ -0,0 +1,60 @@
+package twe
+
+import (
+    "net/http"
+    "testing"
+)
+
+// productPageWithCloudflareScript is shaped like an ordinary product page:
+// Cloudflare injects its challenge-platform script into pages it lets
+// through.
+const productPageWithCloudflareScript = `<!DOCTYPE html>
+<html lang="en-GB"><head><title>Port Ellen 40 Year Old | The Whisky Exchange</title></head>
+<body>
+<h1 class="product-main__name">Port Ellen 40 Year Old</h1>
+<dl><dt>Distillery</dt><dd>Port Ellen</dd><dt>Region</dt><dd>Islay</dd></dl>
+<script>(function(){window.__CF$cv$params={r:'8f1c2d3e4a5b6c7d',t:'MTcyOTI0ODAwMA=='};var a=document.createElement('script');a.src='/cdn-cgi/challenge-platform/scripts/jsd/main.js';document.getElementsByTagName('head')[0].appendChild(a);})();</script>
+<script src="/cdn-cgi/challenge-platform/h/b/scripts/cf-chl-bypass/v1"></script>
+</body></html>`
+
+const challengePage = `<!DOCTYPE html><html lang="en-US"><head><title>Just a moment...</title></head>
+<body><div id="challenge-running">Checking if the site connection is secure</div>
+<script src="/cdn-cgi/challenge-platform/h/b/orchestrate/chl_page/v1"></script></body></html>`
+
+func TestClassify(t *testing.T) {
+    tests := []struct {
+        name   string
+        status int
+        header http.Header
+        body   string
+        want   ResponseClass
+    }{
+        {"json page", 200, nil, `{"products":[]}`, ClassOK},
+        {"json array", 200, nil, ` [1]`, ClassOK},
+        {"product page with injected script", 200, nil, productPageWithCloudflareScript, ClassUnexpected},
+        {"200 html", 200, nil, "<html></html>", ClassUnexpected},
+        {"challenge header", 403, http.Header{"Cf-Mitigated": {"challenge"}}, challengePage, ClassChallenge},
+        {"challenge header on 200", 200, http.Header{"Cf-Mitigated": {"challenge"}}, challengePage, ClassChallenge},
+        {"403 challenge page", 403, nil, challengePage, ClassChallenge},
+        {"503 challenge page", 503, nil, challengePage, ClassChallenge},
+        {"403 block page", 403, nil, "<title>Attention Required! | Cloudflare</title>", ClassChallenge},
+        {"403 without markers", 403, nil, "Forbidden", ClassAuthRejected},
+        {"401", 401, nil, "", ClassAuthRejected},
+        {"429", 429, nil, "", ClassRateLimited},
+        {"cloudflare 1015", 403, nil, "error code: 1015", ClassRateLimited},
+        {"503 without markers", 503, nil, "Service Unavailable", ClassServerError},
+        {"500", 500, nil, "", ClassServerError},
+        {"404", 404, nil, "Not Found", ClassUnexpected},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            header := tt.header
+            if header == nil {
+                header = http.Header{}
+            }
+            if got := Classify(tt.status, header, []byte(tt.body)); got != tt.want {
+                t.Errorf("Classify(%d) = %v, want %v", tt.status, got, tt.want)
+            }
+        })
+    }
+}
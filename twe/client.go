@@ -0,0 +1,476 @@
+// Package twe is a client for the product listing API behind
+// www.thewhiskyexchange.com (the productlistdata endpoint).
+package twe
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const (
+    // DefaultBaseURL is the live site.
+    DefaultBaseURL = "https://www.thewhiskyexchange.com"
+    // DefaultUserAgent mimics the desktop browser the session cookies came from.
+    DefaultUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/136.0.0.0 Safari/537.36"
+    // DefaultMaxRetries is how often a rate-limited or failed page is retried.
+    DefaultMaxRetries = 4
+
+    productListPath = "/api/product/productlistdata"
+)
+
+// Config holds everything needed to talk to the API. Only APIToken is
+// mandatory for the live site; everything else has a usable default.
+type Config struct {
+    BaseURL          string
+    APIToken         string
+    Cookies          string // raw Cookie header value
+    CustomerSettings string // CurrentCustomerSettings blob sent in the payload
+    UserAgent        string
+    HTTPClient       *http.Client // set Transport here to stub or proxy requests
+
+    // MaxRetries bounds the retries of one page after rate limiting, server
+    // errors or network failures. Zero means DefaultMaxRetries; negative
+    // disables retrying.
+    MaxRetries int
+    // OnRetry, if set, is called before each retry so callers can log it.
+    OnRetry func(pageNum, attempt int, delay time.Duration, err error)
+
+    // Parallelism is how many pages ListProducts fetches at once after the
+    // first page has revealed TotalPages. Zero or one fetches sequentially.
+    Parallelism int
+    // RequestDelay is the minimum gap between the starts of two requests,
+    // shared by all concurrent fetches.
+    RequestDelay time.Duration
+}
+
+// Client fetches product listing pages. It is safe for concurrent use.
+type Client struct {
+    baseURL          string
+    apiToken         string
+    cookies          string
+    customerSettings string
+    userAgent        string
+    httpClient       *http.Client
+    maxRetries       int
+    onRetry          func(pageNum, attempt int, delay time.Duration, err error)
+    parallelism      int
+    pacer            *pacer
+}
+
+// NewClient builds a Client from cfg, filling in defaults for empty fields.
+func NewClient(cfg Config) *Client {
+    c := &Client{
+        baseURL:          strings.TrimRight(cfg.BaseURL, "/"),
+        apiToken:         cfg.APIToken,
+        cookies:          cfg.Cookies,
+        customerSettings: cfg.CustomerSettings,
+        userAgent:        cfg.UserAgent,
+        httpClient:       cfg.HTTPClient,
+        maxRetries:       cfg.MaxRetries,
+        onRetry:          cfg.OnRetry,
+        parallelism:      cfg.Parallelism,
+        pacer:            &pacer{interval: cfg.RequestDelay},
+    }
+    if c.baseURL == "" {
+        c.baseURL = DefaultBaseURL
+    }
+    if c.userAgent == "" {
+        c.userAgent = DefaultUserAgent
+    }
+    if c.httpClient == nil {
+        c.httpClient = &http.Client{Timeout: 60 * time.Second}
+    }
+    if c.maxRetries == 0 {
+        c.maxRetries = DefaultMaxRetries
+    } else if c.maxRetries < 0 {
+        c.maxRetries = 0
+    }
+    if c.parallelism < 1 {
+        c.parallelism = 1
+    }
+    return c
+}
+
+// BaseURL returns the site root the client talks to.
+func (c *Client) BaseURL() string {
+    return c.baseURL
+}
+
+// ProductURL returns the public product page for a ProductID.
+func (c *Client) ProductURL(productID string) string {
+    return c.baseURL + "/p/" + productID
+}
+
+// Page is one decoded productlistdata response.
+type Page struct {
+    Number     int
+    TotalPages int
+    Products   []Product
+    // Facets are the filters the endpoint offered for the query (its
+    // FiltersData), when it sent any. FacetsErr is set instead when the
+    // filters data was there but could not be read.
+    Facets    []Facet
+    FacetsErr error
+
+    // Transfer statistics for the response that carried this page.
+    ContentEncoding string
+    WireBytes       int // body size as received
+    DecodedBytes    int // body size after undoing Content-Encoding
+    // DecodeErrors lists products that were skipped or only partially
+    // decoded. Partially decoded products are still present in Products.
+    DecodeErrors []DecodeError
+}
+
+// DecodeError reports the problems found while decoding one product.
+type DecodeError struct {
+    Index     int // position in the page's Products array
+    ProductID string
+    Name      string
+    Skipped   bool
+    Err       error        // set when the product was skipped
+    Fields    []FieldError // set when individual fields failed
+}
+
+func (e DecodeError) Error() string {
+    if e.Skipped {
+        return fmt.Sprintf("product #%d (%q) skipped: %v", e.Index, e.Name, e.Err)
+    }
+    msgs := make([]string, 0, len(e.Fields))
+    for _, f := range e.Fields {
+        msgs = append(msgs, f.Error())
+    }
+    return fmt.Sprintf("product %s (%q): %s", e.ProductID, e.Name, strings.Join(msgs, "; "))
+}
+
+// Payload builds the JSON request body for the given query and page.
+func (c *Client) Payload(q Query, pageNum int) ([]byte, error) {
+    display := q.Display
+    display.PageNumber = pageNum
+
+    requestPayload := RequestPayload{
+        Model: Model{
+            FilteringCriterias:      q.Filters,
+            DisplaySettings:         display,
+            CurrentCustomerSettings: c.customerSettings,
+            ApiToken:                c.apiToken,
+            DataReturnedSettings: DataReturnedSettings{
+                RemoveSelectedFiltersFromFiltersData: false,
+                ReturnArrayOfProductDataForGA4:       false,
+                ReturnArrayOfProducts:                true,
+                ReturnProductListHtml:                false,
+            },
+        },
+    }
+    return json.Marshal(requestPayload)
+}
+
+// FetchPage requests a single page of results for q. Rate limiting, server
+// errors and network failures are retried with backoff; a Cloudflare
+// challenge is retried once, since it occasionally clears by itself. Any
+// other failure, including a rejected token, is returned straight away.
+func (c *Client) FetchPage(ctx context.Context, q Query, pageNum int) (*Page, error) {
+    payload, err := c.Payload(q, pageNum)
+    if err != nil {
+        return nil, fmt.Errorf("marshalling payload: %w", err)
+    }
+
+    for attempt := 0; ; attempt++ {
+        page, err := c.fetchPage(ctx, payload, pageNum)
+        if err == nil || ctx.Err() != nil || attempt >= c.maxRetries {
+            return page, err
+        }
+
+        delay := backoff(attempt)
+        var respErr *ResponseError
+        if errors.As(err, &respErr) {
+            if !respErr.Class.Retryable() || (respErr.Class == ClassChallenge && attempt >= challengeRetries) {
+                return nil, err
+            }
+            if respErr.RetryAfter > delay {
+                delay = respErr.RetryAfter
+            }
+            if respErr.Class == ClassRateLimited {
+                c.pacer.pause(delay)
+            }
+        } else if !isNetworkError(err) {
+            return nil, err
+        }
+
+        if c.onRetry != nil {
+            c.onRetry(pageNum, attempt+1, delay, err)
+        }
+        timer := time.NewTimer(delay)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return nil, err
+        case <-timer.C:
+        }
+    }
+}
+
+// fetchPage sends one request for a page and classifies the answer.
+func (c *Client) fetchPage(ctx context.Context, payload []byte, pageNum int) (*Page, error) {
+    if err := c.pacer.wait(ctx); err != nil {
+        return nil, err
+    }
+    url := c.baseURL + productListPath
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+    if err != nil {
+        return nil, err
+    }
+    c.setHeaders(req)
+
+    resp, err := c.httpClient.Do(req)
+    if err != nil {
+        return nil, &networkError{err}
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, &networkError{fmt.Errorf("reading %s: %w", url, err)}
+    }
+
+    contentEncoding := resp.Header.Get("Content-Encoding")
+    decoded, decodeErr := decodeContent(contentEncoding, body)
+    if decodeErr != nil {
+        // Error pages are sometimes sent with a bogus Content-Encoding;
+        // classify whatever arrived.
+        decoded = body
+    }
+
+    class := Classify(resp.StatusCode, resp.Header, decoded)
+    success := resp.StatusCode >= 200 && resp.StatusCode <= 299
+    if class != ClassOK && (!success || class != ClassUnexpected) {
+        return nil, &ResponseError{
+            Class:      class,
+            StatusCode: resp.StatusCode,
+            URL:        url,
+            RetryAfter: retryAfter(resp.Header),
+            Snippet:    snippet(decoded, 120),
+        }
+    }
+    if decodeErr != nil {
+        return nil, fmt.Errorf("decoding page %d from %s: %w", pageNum, url, decodeErr)
+    }
+
+    page, err := c.decodePage(decoded)
+    if err != nil {
+        var syntaxErr *json.SyntaxError
+        if errors.As(err, &syntaxErr) || !json.Valid(decoded) {
+            err = &NotJSONError{ContentType: resp.Header.Get("Content-Type"), Snippet: snippet(decoded, 120), Err: err}
+        }
+        return nil, fmt.Errorf("decoding page %d from %s: %w", pageNum, url, err)
+    }
+    if page.Number == 0 {
+        page.Number = pageNum
+    }
+    page.ContentEncoding = contentEncoding
+    page.WireBytes = len(body)
+    page.DecodedBytes = len(decoded)
+    return page, nil
+}
+
+func (c *Client) setHeaders(req *http.Request) {
+    req.Header.Set("User-Agent", c.userAgent)
+    req.Header.Set("Accept", "*/*")
+    req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+    req.Header.Set("Accept-Encoding", acceptEncoding)
+    req.Header.Set("Connection", "keep-alive")
+    req.Header.Set("Apitoken", `"`+c.apiToken+`"`)
+    req.Header.Set("Origin", c.baseURL)
+    req.Header.Set("Referer", c.baseURL)
+    if c.cookies != "" {
+        req.Header.Set("Cookie", c.cookies)
+    }
+}
+
+// decodePage turns a (decompressed) response body into a Page, stamping each
+// product with its public URL and the time it was scraped.
+func (c *Client) decodePage(body []byte) (*Page, error) {
+    var raw map[string]json.RawMessage
+    if err := json.Unmarshal(body, &raw); err != nil {
+        return nil, err
+    }
+
+    d := fieldDecoder{raw: raw}
+    page := &Page{
+        Number:     int(d.num("CurrentPage")),
+        TotalPages: int(d.num("TotalPages")),
+    }
+    if value := d.lookup("FiltersData"); value != nil {
+        page.Facets, page.FacetsErr = decodeFacets(value)
+    }
+
+    var rawProducts []json.RawMessage
+    if value := d.lookup("Products"); value != nil {
+        if err := json.Unmarshal(value, &rawProducts); err != nil {
+            return nil, fmt.Errorf("reading Products array: %w", err)
+        }
+    }
+
+    scrapedAt := time.Now().UTC()
+    for i, rawProduct := range rawProducts {
+        product, fieldErrs, err := DecodeProduct(rawProduct)
+        if err != nil {
+            page.DecodeErrors = append(page.DecodeErrors, DecodeError{Index: i, Skipped: true, Err: err})
+            continue
+        }
+        if product.ProductID == "" {
+            page.DecodeErrors = append(page.DecodeErrors, DecodeError{
+                Index: i, Name: product.Name, Skipped: true, Err: fmt.Errorf("missing ProductID"),
+            })
+            continue
+        }
+        if len(fieldErrs) > 0 {
+            page.DecodeErrors = append(page.DecodeErrors, DecodeError{
+                Index: i, ProductID: product.ProductID, Name: product.Name, Fields: fieldErrs,
+            })
+        }
+
+        product.URL = c.ProductURL(product.ProductID)
+        product.ScrapedDate = scrapedAt
+        page.Products = append(page.Products, product)
+    }
+    return page, nil
+}
+
+// ProductIterator walks every page of a query in order.
+//
+//	it := client.ListProducts(ctx, q)
+//	defer it.Close()
+//	for it.Next() {
+//	    page := it.Page()
+//	}
+//	if err := it.Err(); err != nil { ... }
+//
+// The first page is fetched on its own. Once it has revealed TotalPages, the
+// remaining pages are fetched by up to Config.Parallelism workers, but Next
+// still returns them strictly in page order, one at a time, so the caller
+// needs no locking. At most Parallelism pages are fetched ahead of the one
+// the caller is processing.
+type ProductIterator struct {
+    ctx      context.Context
+    cancel   context.CancelFunc
+    client   *Client
+    query    Query
+    nextPage int
+    page     *Page
+    err      error
+    done     bool
+
+    // Set up once TotalPages is known.
+    lastPage int
+    results  map[int]chan pageResult
+    slots    chan struct{}
+}
+
+type pageResult struct {
+    page *Page
+    err  error
+}
+
+// ListProducts returns an iterator over all pages of q, starting at page 1.
+func (c *Client) ListProducts(ctx context.Context, q Query) *ProductIterator {
+    return c.ListProductsFrom(ctx, q, 1)
+}
+
+// ListProductsFrom returns an iterator over the pages of q from page start
+// on, for continuing an interrupted crawl.
+func (c *Client) ListProductsFrom(ctx context.Context, q Query, start int) *ProductIterator {
+    ctx, cancel := context.WithCancel(ctx)
+    return &ProductIterator{ctx: ctx, cancel: cancel, client: c, query: q, nextPage: start}
+}
+
+// Next fetches the next page. It returns false once the last page has been
+// returned or an error occurred; check Err to tell the two apart.
+func (it *ProductIterator) Next() bool {
+    if it.done {
+        return false
+    }
+
+    var page *Page
+    var err error
+    if it.results == nil {
+        page, err = it.client.FetchPage(it.ctx, it.query, it.nextPage)
+    } else {
+        select {
+        case result := <-it.results[it.nextPage]:
+            <-it.slots // let the workers fetch one page further ahead
+            page, err = result.page, result.err
+        case <-it.ctx.Done():
+            err = it.ctx.Err()
+        }
+    }
+    if err != nil {
+        it.err = err
+        it.Close()
+        return false
+    }
+
+    it.page = page
+    switch {
+    case it.results != nil:
+        it.nextPage++
+        if it.nextPage > it.lastPage {
+            it.Close()
+        }
+    case page.TotalPages > page.Number:
+        it.nextPage = page.Number + 1
+        it.fetchAhead(it.nextPage, page.TotalPages)
+    default:
+        it.Close()
+    }
+    return true
+}
+
+// fetchAhead starts fetching pages first..last concurrently. Each page has a
+// buffered channel of its own, so a worker never blocks on a result nobody
+// reads any more.
+func (it *ProductIterator) fetchAhead(first, last int) {
+    it.lastPage = last
+    it.results = make(map[int]chan pageResult, last-first+1)
+    for p := first; p <= last; p++ {
+        it.results[p] = make(chan pageResult, 1)
+    }
+    it.slots = make(chan struct{}, it.client.parallelism)
+
+    go func() {
+        for p := first; p <= last; p++ {
+            select {
+            case it.slots <- struct{}{}:
+            case <-it.ctx.Done():
+                return
+            }
+            go func(p int) {
+                page, err := it.client.FetchPage(it.ctx, it.query, p)
+                it.results[p] <- pageResult{page, err}
+            }(p)
+        }
+    }()
+}
+
+// Page returns the page fetched by the last successful call to Next.
+func (it *ProductIterator) Page() *Page {
+    return it.page
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *ProductIterator) Err() error {
+    return it.err
+}
+
+// Close stops any fetches still running. Calling it after the iteration has
+// finished is harmless.
+func (it *ProductIterator) Close() {
+    it.done = true
+    it.cancel()
+}
@@ -0,0 +1,209 @@
+package twe
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "sync"
+    "testing"
+    "time"
+)
+
+// listServer answers productlistdata with totalPages pages of two products
+// each. fault, if set, may answer a request itself instead; attempt counts
+// the requests for that page so far. The server records when each request
+// started and the most requests it saw at once.
+type listServer struct {
+    totalPages int
+    fault      func(w http.ResponseWriter, page, attempt int) bool
+
+    mu       sync.Mutex
+    attempts map[int]int
+    starts   []time.Time
+    inFlight int
+    peak     int
+}
+
+func (s *listServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    started := time.Now()
+    var payload RequestPayload
+    body, _ := io.ReadAll(r.Body)
+    if err := json.Unmarshal(body, &payload); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    page := payload.Model.DisplaySettings.PageNumber
+
+    s.mu.Lock()
+    s.attempts[page]++
+    attempt := s.attempts[page]
+    s.starts = append(s.starts, started)
+    s.inFlight++
+    if s.inFlight > s.peak {
+        s.peak = s.inFlight
+    }
+    s.mu.Unlock()
+    defer func() {
+        s.mu.Lock()
+        s.inFlight--
+        s.mu.Unlock()
+    }()
+
+    // Answer early pages last, so concurrent fetches finish out of order.
+    time.Sleep(time.Duration(s.totalPages-page+2) * 5 * time.Millisecond)
+    if s.fault != nil && s.fault(w, page, attempt) {
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    fmt.Fprintf(w, `{"CurrentPage":%d,"TotalPages":%d,"Products":[{"ProductID":"%d-1","Name":"A"},{"ProductID":"%d-2","Name":"B"}]}`,
+        page, s.totalPages, page, page)
+}
+
+func TestListProducts(t *testing.T) {
+    tests := []struct {
+        name        string
+        totalPages  int
+        parallelism int
+        start       int
+        fault       func(w http.ResponseWriter, page, attempt int) bool
+        wantPages   []int
+        wantErr     int // status of the error that ends the iteration
+        wantPeak    int // most concurrent requests allowed
+    }{
+        {name: "one page", totalPages: 1, parallelism: 4, wantPages: []int{1}, wantPeak: 1},
+        {name: "sequential", totalPages: 4, wantPages: []int{1, 2, 3, 4}, wantPeak: 1},
+        {name: "parallel", totalPages: 8, parallelism: 3, wantPages: []int{1, 2, 3, 4, 5, 6, 7, 8}, wantPeak: 3},
+        {name: "more workers than pages", totalPages: 3, parallelism: 8, wantPages: []int{1, 2, 3}, wantPeak: 2},
+        {name: "resumed", totalPages: 6, parallelism: 2, start: 4, wantPages: []int{4, 5, 6}, wantPeak: 2},
+        {
+            name: "server error retried", totalPages: 5, parallelism: 3,
+            fault: func(w http.ResponseWriter, page, attempt int) bool {
+                if page == 3 && attempt == 1 {
+                    http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+                    return true
+                }
+                return false
+            },
+            wantPages: []int{1, 2, 3, 4, 5}, wantPeak: 3,
+        },
+        {
+            name: "failing page stops the iteration in order", totalPages: 6, parallelism: 3,
+            fault: func(w http.ResponseWriter, page, attempt int) bool {
+                if page == 4 {
+                    http.NotFound(w, nil)
+                    return true
+                }
+                return false
+            },
+            wantPages: []int{1, 2, 3}, wantErr: http.StatusNotFound, wantPeak: 3,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            s := &listServer{totalPages: tt.totalPages, fault: tt.fault, attempts: make(map[int]int)}
+            srv := httptest.NewServer(s)
+            defer srv.Close()
+            client := NewClient(Config{BaseURL: srv.URL, Parallelism: tt.parallelism, MaxRetries: 1})
+
+            start := tt.start
+            if start == 0 {
+                start = 1
+            }
+            it := client.ListProductsFrom(context.Background(), DefaultQuery(), start)
+            defer it.Close()
+            var pages []int
+            for it.Next() {
+                page := it.Page()
+                pages = append(pages, page.Number)
+                want := fmt.Sprintf("%d-1", page.Number)
+                if len(page.Products) != 2 || page.Products[0].ProductID != want {
+                    t.Errorf("page %d products = %+v", page.Number, page.Products)
+                }
+            }
+
+            if !reflect.DeepEqual(pages, tt.wantPages) {
+                t.Errorf("pages = %v, want %v", pages, tt.wantPages)
+            }
+            var respErr *ResponseError
+            switch {
+            case tt.wantErr == 0 && it.Err() != nil:
+                t.Errorf("Err = %v", it.Err())
+            case tt.wantErr != 0 && (!errors.As(it.Err(), &respErr) || respErr.StatusCode != tt.wantErr):
+                t.Errorf("Err = %v, want status %d", it.Err(), tt.wantErr)
+            }
+            s.mu.Lock()
+            defer s.mu.Unlock()
+            if s.peak > tt.wantPeak {
+                t.Errorf("%d requests at once, want at most %d", s.peak, tt.wantPeak)
+            }
+            if tt.parallelism > 1 && tt.totalPages-start > 1 && s.peak < 2 {
+                t.Errorf("pages were never fetched concurrently")
+            }
+        })
+    }
+}
+
+func TestListProductsSharesRequestDelay(t *testing.T) {
+    const delay = 30 * time.Millisecond
+    s := &listServer{totalPages: 6, attempts: make(map[int]int)}
+    srv := httptest.NewServer(s)
+    defer srv.Close()
+    client := NewClient(Config{BaseURL: srv.URL, Parallelism: 4, RequestDelay: delay})
+
+    it := client.ListProducts(context.Background(), DefaultQuery())
+    defer it.Close()
+    for it.Next() {
+    }
+    if err := it.Err(); err != nil {
+        t.Fatal(err)
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if len(s.starts) != 6 {
+        t.Fatalf("%d requests, want 6", len(s.starts))
+    }
+    // Single gaps jitter with scheduling, so check the requests were spread
+    // over at least five delays.
+    first, last := s.starts[0], s.starts[0]
+    for _, start := range s.starts {
+        if start.Before(first) {
+            first = start
+        }
+        if start.After(last) {
+            last = start
+        }
+    }
+    if span, want := last.Sub(first), 5*delay; span < want-5*time.Millisecond {
+        t.Errorf("6 requests started within %v, want at least %v", span, want)
+    }
+}
+
+func TestListProductsStopsFetchingOnClose(t *testing.T) {
+    s := &listServer{totalPages: 20, attempts: make(map[int]int)}
+    srv := httptest.NewServer(s)
+    defer srv.Close()
+    client := NewClient(Config{BaseURL: srv.URL, Parallelism: 2})
+
+    it := client.ListProducts(context.Background(), DefaultQuery())
+    if !it.Next() || !it.Next() {
+        t.Fatalf("first pages failed: %v", it.Err())
+    }
+    it.Close()
+    if it.Next() {
+        t.Error("Next returned a page after Close")
+    }
+    time.Sleep(150 * time.Millisecond)
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    // Page 1, page 2 and at most two pages ahead of it.
+    if len(s.starts) > 4 {
+        t.Errorf("%d pages requested after Close at page 2, want at most 4", len(s.starts))
+    }
+}
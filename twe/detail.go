@@ -0,0 +1,361 @@
+package twe
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/PuerkitoBio/goquery"
+    "github.com/gocolly/colly/v2"
+)
+
+// Details is what a product page (/p/{id}) adds to the listing data. Every
+// field is optional: pages differ, and anything not found stays empty.
+type Details struct {
+    Nose   string `json:"nose,omitempty"`
+    Palate string `json:"palate,omitempty"`
+    Finish string `json:"finish,omitempty"`
+
+    Distillery string `json:"distillery,omitempty"`
+    Region     string `json:"region,omitempty"`
+    CaskType   string `json:"caskType,omitempty"`
+    Bottler    string `json:"bottler,omitempty"`
+    Vintage    string `json:"vintage,omitempty"`
+
+    Rating      float64 `json:"rating,omitempty"`
+    ReviewCount int     `json:"reviewCount,omitempty"`
+
+    // GTIN and MPN come from the page's schema.org Product JSON-LD.
+    GTIN string `json:"gtin,omitempty"`
+    MPN  string `json:"mpn,omitempty"`
+}
+
+// DetailError reports a product page that could not be enriched.
+type DetailError struct {
+    ProductID string
+    URL       string
+    Err       error
+}
+
+func (e *DetailError) Error() string {
+    return fmt.Sprintf("product %s: %v", e.ProductID, e.Err)
+}
+
+func (e *DetailError) Unwrap() error { return e.Err }
+
+// DetailFetcher visits product pages with colly and fills in Product.Details.
+// It sends the Client's cookies and User-Agent, and its requests share the
+// Client's pacing, so listing and product pages together never exceed the
+// configured request rate.
+type DetailFetcher struct {
+    client      *Client
+    parallelism int
+    transport   http.RoundTripper
+}
+
+// Details returns a DetailFetcher that visits up to parallelism product pages
+// at once. A nil transport uses the default one; the Client's own transport
+// is not used because it may record or replay listing responses only.
+func (c *Client) Details(parallelism int, transport http.RoundTripper) *DetailFetcher {
+    if parallelism < 1 {
+        parallelism = 1
+    }
+    return &DetailFetcher{client: c, parallelism: parallelism, transport: transport}
+}
+
+// Enrich visits the page of every product and sets its Details. Pages that
+// fail are left without Details and reported as DetailErrors in the returned
+// slice. Rate limiting, server errors and network errors are retried like
+// listing pages. A Cloudflare challenge or rejected session stops the
+// remaining visits and is returned as a *ResponseError, since every further
+// page would fail too.
+func (f *DetailFetcher) Enrich(ctx context.Context, products []Product) ([]*DetailError, error) {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    collector := colly.NewCollector(
+        colly.Async(),
+        colly.AllowURLRevisit(),
+        colly.StdlibContext(ctx),
+        colly.UserAgent(f.client.userAgent),
+    )
+    collector.DisableCookies()
+    collector.SetRequestTimeout(60 * time.Second)
+    if f.transport != nil {
+        collector.WithTransport(f.transport)
+    }
+    if err := collector.Limit(&colly.LimitRule{DomainGlob: "*", Parallelism: f.parallelism}); err != nil {
+        return nil, err
+    }
+
+    var (
+        mu         sync.Mutex
+        failed     []*DetailError
+        sessionErr error
+    )
+    fail := func(r *colly.Request, err error) {
+        i := r.Ctx.GetAny("index").(int)
+        mu.Lock()
+        defer mu.Unlock()
+        failed = append(failed, &DetailError{ProductID: products[i].ProductID, URL: r.URL.String(), Err: err})
+    }
+    stop := func(err error) {
+        mu.Lock()
+        defer mu.Unlock()
+        if sessionErr == nil {
+            sessionErr = err
+            cancel()
+        }
+    }
+
+    collector.OnRequest(func(r *colly.Request) {
+        if err := f.client.pacer.wait(ctx); err != nil {
+            r.Abort()
+            return
+        }
+        r.Headers.Set("Accept", "text/html,application/xhtml+xml")
+        r.Headers.Set("Referer", f.client.baseURL)
+        if f.client.cookies != "" {
+            r.Headers.Set("Cookie", f.client.cookies)
+        }
+    })
+    collector.OnResponse(func(r *colly.Response) {
+        if class := Classify(r.StatusCode, *r.Headers, r.Body); class.SessionExpired() {
+            stop(f.responseError(r, class))
+        }
+    })
+    collector.OnHTML("html", func(e *colly.HTMLElement) {
+        if ctx.Err() != nil {
+            return
+        }
+        i := e.Request.Ctx.GetAny("index").(int)
+        details := ParseDetails(e.DOM)
+        products[i].Details = &details
+    })
+    collector.OnScraped(func(r *colly.Response) {
+        i := r.Ctx.GetAny("index").(int)
+        if products[i].Details == nil && ctx.Err() == nil {
+            fail(r.Request, fmt.Errorf("no HTML in response (Content-Type %q)", r.Headers.Get("Content-Type")))
+        }
+    })
+    collector.OnError(func(r *colly.Response, err error) {
+        if ctx.Err() != nil {
+            return
+        }
+        // Without a status there was no response at all: a timeout, reset
+        // or refused connection, retried like a server error.
+        retryable, rateLimited, wait := true, false, time.Duration(0)
+        if r.StatusCode != 0 {
+            class := Classify(r.StatusCode, *r.Headers, r.Body)
+            respErr := f.responseError(r, class)
+            if class.SessionExpired() {
+                stop(respErr)
+                return
+            }
+            err, retryable, wait = respErr, class.Retryable(), respErr.RetryAfter
+            rateLimited = class == ClassRateLimited
+        }
+        attempt, _ := r.Ctx.GetAny("attempt").(int)
+        if !retryable || attempt >= f.client.maxRetries {
+            fail(r.Request, err)
+            return
+        }
+        delay := backoff(attempt)
+        if wait > delay {
+            delay = wait
+        }
+        // Only rate limiting holds back every request; a page that failed
+        // otherwise waits on its own.
+        if rateLimited {
+            f.client.pacer.pause(delay)
+        }
+        timer := time.NewTimer(delay)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case <-timer.C:
+        }
+        r.Ctx.Put("attempt", attempt+1)
+        if err := r.Request.Retry(); err != nil {
+            fail(r.Request, err)
+        }
+    })
+
+    for i, product := range products {
+        if product.ProductID == "" {
+            continue
+        }
+        products[i].Details = nil
+        reqCtx := colly.NewContext()
+        reqCtx.Put("index", i)
+        url := f.client.ProductURL(product.ProductID)
+        if err := collector.Request(http.MethodGet, url, nil, reqCtx, nil); err != nil {
+            mu.Lock()
+            failed = append(failed, &DetailError{ProductID: product.ProductID, URL: url, Err: err})
+            mu.Unlock()
+        }
+    }
+    collector.Wait()
+
+    if sessionErr != nil {
+        return failed, sessionErr
+    }
+    if err := ctx.Err(); err != nil {
+        return failed, err
+    }
+    return failed, nil
+}
+
+func (f *DetailFetcher) responseError(r *colly.Response, class ResponseClass) *ResponseError {
+    return &ResponseError{
+        Class:      class,
+        StatusCode: r.StatusCode,
+        URL:        r.Request.URL.String(),
+        RetryAfter: retryAfter(*r.Headers),
+        Snippet:    snippet(r.Body, 120),
+    }
+}
+
+// detailLabels maps the labels a product page uses for its facts and tasting
+// notes to the Details field they fill.
+var detailLabels = map[string]func(*Details) *string{
+    "nose":       func(d *Details) *string { return &d.Nose },
+    "palate":     func(d *Details) *string { return &d.Palate },
+    "finish":     func(d *Details) *string { return &d.Finish },
+    "distillery": func(d *Details) *string { return &d.Distillery },
+    "region":     func(d *Details) *string { return &d.Region },
+    "cask type":  func(d *Details) *string { return &d.CaskType },
+    "cask":       func(d *Details) *string { return &d.CaskType },
+    "bottler":    func(d *Details) *string { return &d.Bottler },
+    "vintage":    func(d *Details) *string { return &d.Vintage },
+}
+
+// ParseDetails extracts Details from a product page. Facts and tasting notes
+// are found by their labels ("Distillery", "Nose", ...) rather than by class
+// names, so small markup changes don't lose them: a label is any dt, th,
+// heading or strong element, or an element whose class ends in "__type" or
+// "__title", and its value is the next element. Rating, review count, GTIN
+// and MPN come from schema.org JSON-LD, falling back to microdata.
+func ParseDetails(doc *goquery.Selection) Details {
+    var d Details
+
+    doc.Find(`dt, th, h2, h3, h4, h5, strong, [class$="__type"], [class$="__title"]`).Each(func(_ int, label *goquery.Selection) {
+        key := strings.ToLower(strings.TrimSuffix(cleanText(label.Text()), ":"))
+        field, ok := detailLabels[key]
+        if !ok || *field(&d) != "" {
+            return
+        }
+        value := cleanText(label.Next().Text())
+        if value == "" {
+            // "<p><strong>Nose:</strong> Peat smoke and brine.</p>"
+            value = strings.TrimPrefix(cleanText(label.Parent().Text()), cleanText(label.Text()))
+            value = strings.TrimSpace(value)
+        }
+        *field(&d) = value
+    })
+
+    doc.Find(`script[type="application/ld+json"]`).Each(func(_ int, script *goquery.Selection) {
+        for _, product := range jsonLDProducts([]byte(script.Text())) {
+            applyJSONLD(&d, product)
+        }
+    })
+
+    if d.Rating == 0 {
+        d.Rating, _ = strconv.ParseFloat(microdata(doc, "ratingValue"), 64)
+    }
+    if d.ReviewCount == 0 {
+        d.ReviewCount, _ = strconv.Atoi(microdata(doc, "reviewCount"))
+    }
+    return d
+}
+
+// jsonLDProducts returns the schema.org Product objects in a JSON-LD block,
+// which may be a single object, an array or an @graph.
+func jsonLDProducts(data []byte) []map[string]json.RawMessage {
+    var products []map[string]json.RawMessage
+    var walk func(raw json.RawMessage)
+    walk = func(raw json.RawMessage) {
+        var list []json.RawMessage
+        if json.Unmarshal(raw, &list) == nil {
+            for _, item := range list {
+                walk(item)
+            }
+            return
+        }
+        var object map[string]json.RawMessage
+        if json.Unmarshal(raw, &object) != nil {
+            return
+        }
+        if graph, ok := object["@graph"]; ok {
+            walk(graph)
+        }
+        if hasType(object["@type"], "Product") {
+            products = append(products, object)
+        }
+    }
+    walk(data)
+    return products
+}
+
+func hasType(raw json.RawMessage, want string) bool {
+    var one string
+    if json.Unmarshal(raw, &one) == nil {
+        return one == want
+    }
+    var many []string
+    json.Unmarshal(raw, &many)
+    for _, t := range many {
+        if t == want {
+            return true
+        }
+    }
+    return false
+}
+
+// applyJSONLD copies the fields of a schema.org Product into d.
+func applyJSONLD(d *Details, product map[string]json.RawMessage) {
+    fields := fieldDecoder{raw: product}
+    for _, key := range []string{"gtin13", "gtin", "gtin14", "gtin12", "gtin8"} {
+        if d.GTIN == "" {
+            d.GTIN = fields.str(key)
+        }
+    }
+    if d.MPN == "" {
+        d.MPN = fields.str("mpn")
+    }
+    if raw := fields.lookup("aggregateRating"); raw != nil {
+        var ratingFields map[string]json.RawMessage
+        if json.Unmarshal(raw, &ratingFields) == nil {
+            rating := fieldDecoder{raw: ratingFields}
+            if d.Rating == 0 {
+                d.Rating = rating.num("ratingValue")
+            }
+            if d.ReviewCount == 0 {
+                d.ReviewCount = int(rating.num("reviewCount"))
+            }
+            if d.ReviewCount == 0 {
+                d.ReviewCount = int(rating.num("ratingCount"))
+            }
+        }
+    }
+}
+
+// microdata returns the value of the first itemprop element named prop.
+func microdata(doc *goquery.Selection, prop string) string {
+    s := doc.Find(`[itemprop="` + prop + `"]`).First()
+    if content, ok := s.Attr("content"); ok {
+        return strings.TrimSpace(content)
+    }
+    return cleanText(s.Text())
+}
+
+// cleanText collapses the whitespace of text scraped from HTML.
+func cleanText(s string) string {
+    return strings.Join(strings.Fields(s), " ")
+}
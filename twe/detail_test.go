@@ -0,0 +1,139 @@
+package twe
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// detailServer answers product pages through handle, counting the requests
+// per path.
+func detailServer(t *testing.T, handle func(w http.ResponseWriter, r *http.Request, n int)) (*Client, map[string]int) {
+    t.Helper()
+    var mu sync.Mutex
+    seen := make(map[string]int)
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        mu.Lock()
+        seen[r.URL.Path]++
+        n := seen[r.URL.Path]
+        mu.Unlock()
+        handle(w, r, n)
+    }))
+    t.Cleanup(srv.Close)
+    return NewClient(Config{BaseURL: srv.URL, MaxRetries: 2}), seen
+}
+
+func TestEnrichOrdinaryPageWithCloudflareScript(t *testing.T) {
+    client, _ := detailServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        io.WriteString(w, productPageWithCloudflareScript)
+    })
+    products := []Product{{ProductID: "1"}, {ProductID: "2"}}
+    failed, err := client.Details(2, nil).Enrich(context.Background(), products)
+    if err != nil {
+        t.Fatalf("Enrich: %v", err)
+    }
+    if len(failed) > 0 {
+        t.Fatalf("Enrich failed %d pages: %v", len(failed), failed[0])
+    }
+    for _, p := range products {
+        if p.Details == nil || p.Details.Distillery != "Port Ellen" || p.Details.Region != "Islay" {
+            t.Errorf("product %s details = %+v, want Port Ellen, Islay", p.ProductID, p.Details)
+        }
+    }
+}
+
+func TestEnrichRetriesNetworkErrors(t *testing.T) {
+    client, seen := detailServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+        if n == 1 {
+            // Drop the connection without an answer.
+            conn, _, err := w.(http.Hijacker).Hijack()
+            if err == nil {
+                conn.Close()
+            }
+            return
+        }
+        w.Header().Set("Content-Type", "text/html")
+        io.WriteString(w, productPageWithCloudflareScript)
+    })
+    products := []Product{{ProductID: "7"}}
+    failed, err := client.Details(1, nil).Enrich(context.Background(), products)
+    if err != nil || len(failed) > 0 {
+        t.Fatalf("Enrich = %v, %v; want the dropped connection retried", failed, err)
+    }
+    if seen["/p/7"] != 2 {
+        t.Errorf("product page requested %d times, want 2", seen["/p/7"])
+    }
+    if products[0].Details == nil || products[0].Details.Distillery != "Port Ellen" {
+        t.Errorf("details = %+v", products[0].Details)
+    }
+}
+
+func TestEnrichServerErrorDoesNotPauseTheClient(t *testing.T) {
+    var client *Client
+    var held time.Duration
+    client, seen := detailServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+        switch {
+        case r.URL.Path == "/p/1" && n == 1:
+            w.WriteHeader(http.StatusServiceUnavailable)
+            return
+        case r.URL.Path == "/p/2":
+            // Page 1 is backing off by now; a listing page must not wait
+            // for it.
+            time.Sleep(100 * time.Millisecond)
+            start := time.Now()
+            client.pacer.wait(r.Context())
+            held = time.Since(start)
+        }
+        w.Header().Set("Content-Type", "text/html")
+        io.WriteString(w, productPageWithCloudflareScript)
+    })
+    products := []Product{{ProductID: "1"}, {ProductID: "2"}}
+    failed, err := client.Details(2, nil).Enrich(context.Background(), products)
+    if err != nil || len(failed) > 0 {
+        t.Fatalf("Enrich = %v, %v; want the 503 retried", failed, err)
+    }
+    if seen["/p/1"] != 2 {
+        t.Errorf("failing page requested %d times, want 2", seen["/p/1"])
+    }
+    if held > 100*time.Millisecond {
+        t.Errorf("the client was held back %s by a product page's backoff", held)
+    }
+}
+
+func TestEnrichStopsOnChallenge(t *testing.T) {
+    client, _ := detailServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+        w.Header().Set("Content-Type", "text/html")
+        w.WriteHeader(http.StatusForbidden)
+        io.WriteString(w, challengePage)
+    })
+    products := []Product{{ProductID: "1"}}
+    _, err := client.Details(1, nil).Enrich(context.Background(), products)
+    var respErr *ResponseError
+    if !errors.As(err, &respErr) || respErr.Class != ClassChallenge {
+        t.Fatalf("Enrich error = %v, want a challenge", err)
+    }
+}
+
+func TestEnrichGivesUpOnMissingPage(t *testing.T) {
+    client, seen := detailServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+        http.NotFound(w, r)
+    })
+    products := []Product{{ProductID: "3"}}
+    failed, err := client.Details(1, nil).Enrich(context.Background(), products)
+    if err != nil {
+        t.Fatalf("Enrich: %v", err)
+    }
+    if len(failed) != 1 || failed[0].ProductID != "3" || !strings.Contains(failed[0].Error(), "404") {
+        t.Fatalf("failed = %v, want product 3 with a 404", failed)
+    }
+    if seen["/p/3"] != 1 {
+        t.Errorf("missing page requested %d times, want 1", seen["/p/3"])
+    }
+}
@@ -0,0 +1,104 @@
+package twe
+
+import (
+    "bytes"
+    "compress/flate"
+    "compress/gzip"
+    "compress/zlib"
+    "fmt"
+    "io"
+    "strings"
+
+    "github.com/andybalholm/brotli"
+    "github.com/klauspost/compress/zstd"
+)
+
+// acceptEncoding lists every Content-Encoding decodeContent understands.
+const acceptEncoding = "gzip, deflate, br, zstd"
+
+// decodeContent undoes the Content-Encoding header value, which may list
+// several codings applied in order (they are removed in reverse).
+func decodeContent(contentEncoding string, body []byte) ([]byte, error) {
+    codings := strings.Split(contentEncoding, ",")
+    for i := len(codings) - 1; i >= 0; i-- {
+        coding := strings.ToLower(strings.TrimSpace(codings[i]))
+        encoded := body
+        var err error
+        switch coding {
+        case "", "identity":
+            continue
+        case "br":
+            body, err = io.ReadAll(brotli.NewReader(bytes.NewReader(body)))
+        case "gzip", "x-gzip":
+            body, err = gunzip(body)
+        case "deflate":
+            body, err = inflate(body)
+        case "zstd":
+            body, err = unzstd(body)
+        default:
+            return nil, fmt.Errorf("unsupported Content-Encoding %q", coding)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("body is not valid %s data (starts %q): %w", coding, snippet(encoded, 60), err)
+        }
+    }
+    return body, nil
+}
+
+func gunzip(body []byte) ([]byte, error) {
+    r, err := gzip.NewReader(bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    defer r.Close()
+    return io.ReadAll(r)
+}
+
+// inflate handles "deflate", which is meant to be zlib-wrapped but is sent as
+// raw DEFLATE by some servers.
+func inflate(body []byte) ([]byte, error) {
+    if r, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+        defer r.Close()
+        if out, err := io.ReadAll(r); err == nil {
+            return out, nil
+        }
+    }
+    r := flate.NewReader(bytes.NewReader(body))
+    defer r.Close()
+    return io.ReadAll(r)
+}
+
+func unzstd(body []byte) ([]byte, error) {
+    d, err := zstd.NewReader(nil)
+    if err != nil {
+        return nil, err
+    }
+    defer d.Close()
+    return d.DecodeAll(body, nil)
+}
+
+// NotJSONError is returned when a response decodes cleanly but is not the
+// JSON the API normally returns, typically an HTML page from a proxy or bot
+// challenge in front of the site.
+type NotJSONError struct {
+    ContentType string
+    Snippet     string // start of the body, for diagnosis
+    Err         error
+}
+
+func (e *NotJSONError) Error() string {
+    return fmt.Sprintf("response is not JSON (Content-Type %q, body starts %q): %v", e.ContentType, e.Snippet, e.Err)
+}
+
+func (e *NotJSONError) Unwrap() error { return e.Err }
+
+// snippet returns up to n printable bytes from the start of body.
+func snippet(body []byte, n int) string {
+    if len(body) > n {
+        body = body[:n]
+    }
+    if bytes.IndexByte(body, 0) >= 0 {
+        return fmt.Sprintf("%d bytes of binary data", len(body))
+    }
+    return strings.Join(strings.Fields(string(bytes.ToValidUTF8(body, nil))), " ")
+}
@@ -0,0 +1,119 @@
+package twe
+
+import (
+    "bytes"
+    "compress/flate"
+    "compress/gzip"
+    "compress/zlib"
+    "io"
+    "strings"
+    "testing"
+
+    "github.com/andybalholm/brotli"
+    "github.com/klauspost/compress/zstd"
+)
+
+// encoders apply each Content-Encoding decodeContent understands.
+var encoders = map[string]func(t *testing.T, body []byte) []byte{
+    "gzip": func(t *testing.T, body []byte) []byte {
+        return compress(t, body, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+    },
+    "zlib": func(t *testing.T, body []byte) []byte {
+        return compress(t, body, func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })
+    },
+    "flate": func(t *testing.T, body []byte) []byte {
+        return compress(t, body, func(w io.Writer) io.WriteCloser {
+            fw, err := flate.NewWriter(w, flate.DefaultCompression)
+            if err != nil {
+                t.Fatal(err)
+            }
+            return fw
+        })
+    },
+    "br": func(t *testing.T, body []byte) []byte {
+        return compress(t, body, func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) })
+    },
+    "zstd": func(t *testing.T, body []byte) []byte {
+        enc, err := zstd.NewWriter(nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer enc.Close()
+        return enc.EncodeAll(body, nil)
+    },
+}
+
+func compress(t *testing.T, body []byte, newWriter func(io.Writer) io.WriteCloser) []byte {
+    t.Helper()
+    var buf bytes.Buffer
+    w := newWriter(&buf)
+    if _, err := w.Write(body); err != nil {
+        t.Fatal(err)
+    }
+    if err := w.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+func TestDecodeContent(t *testing.T) {
+    body := []byte(strings.Repeat(`{"Products":[{"ProductID":7,"Name":"Ardbeg 10"}]}`, 20))
+    tests := []struct {
+        name     string
+        header   string
+        encoders []string // applied in order
+    }{
+        {name: "none", header: ""},
+        {name: "identity", header: "identity"},
+        {name: "br", header: "br", encoders: []string{"br"}},
+        {name: "gzip", header: "gzip", encoders: []string{"gzip"}},
+        {name: "x-gzip", header: "x-gzip", encoders: []string{"gzip"}},
+        {name: "upper case", header: "GZIP", encoders: []string{"gzip"}},
+        {name: "zlib deflate", header: "deflate", encoders: []string{"zlib"}},
+        {name: "raw deflate", header: "deflate", encoders: []string{"flate"}},
+        {name: "zstd", header: "zstd", encoders: []string{"zstd"}},
+        {name: "stacked", header: "gzip, br", encoders: []string{"gzip", "br"}},
+        {name: "stacked with identity", header: "zstd, identity,deflate", encoders: []string{"zstd", "flate"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            encoded := body
+            for _, name := range tt.encoders {
+                encoded = encoders[name](t, encoded)
+            }
+            got, err := decodeContent(tt.header, encoded)
+            if err != nil {
+                t.Fatalf("decodeContent: %v", err)
+            }
+            if !bytes.Equal(got, body) {
+                t.Errorf("decodeContent = %q, want the original body", snippet(got, 60))
+            }
+        })
+    }
+}
+
+func TestDecodeContentErrors(t *testing.T) {
+    gzipped := encoders["gzip"](t, []byte(`{"Products":[]}`))
+    tests := []struct {
+        name    string
+        header  string
+        body    []byte
+        wantErr string
+    }{
+        {name: "unknown coding", header: "compress", body: []byte("x"), wantErr: `unsupported Content-Encoding "compress"`},
+        {name: "unknown coding under a known one", header: "compress, gzip", body: gzipped, wantErr: `unsupported Content-Encoding "compress"`},
+        {name: "plain body labelled gzip", header: "gzip", body: []byte(`{"Products":[]}`), wantErr: `body is not valid gzip data (starts "{\"Products\":[]}")`},
+        {name: "truncated gzip", header: "gzip", body: gzipped[:len(gzipped)-6], wantErr: "body is not valid gzip data"},
+        {name: "corrupt br", header: "br", body: []byte("\xff\xff\xff\xff"), wantErr: "body is not valid br data"},
+        {name: "corrupt deflate", header: "deflate", body: []byte("\xff\xff\xff\xff"), wantErr: "body is not valid deflate data"},
+        {name: "corrupt zstd", header: "zstd", body: []byte("not zstd"), wantErr: "body is not valid zstd data"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := decodeContent(tt.header, tt.body)
+            if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                t.Fatalf("decodeContent error = %v, want one containing %q", err, tt.wantErr)
+            }
+        })
+    }
+}
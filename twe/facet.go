@@ -0,0 +1,277 @@
+package twe
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "reflect"
+    "sort"
+    "strings"
+)
+
+// Facet is one filter the listing endpoint offers next to its results,
+// together with the values that narrow the current result set down.
+type Facet struct {
+    // Name is the FilteringCriterias field the facet sets, e.g. "Country".
+    Name   string
+    Label  string
+    Values []FacetValue
+}
+
+// FacetValue is one selectable value of a Facet.
+type FacetValue struct {
+    Value string // sent as the filter value
+    Label string // shown on the site
+    Count int    // products with this value, as reported by the endpoint
+}
+
+// String returns the label, or the value when the endpoint sent no label.
+func (v FacetValue) String() string {
+    if v.Label != "" {
+        return v.Label
+    }
+    return v.Value
+}
+
+// The keys decodeFacets looks each filter's and value's fields up under.
+var (
+    facetNameKeys   = []string{"FilterName", "Key", "Name"}
+    facetLabelKeys  = []string{"DisplayName", "Title", "Label", "Name"}
+    facetValuesKeys = []string{"Options", "Values", "Items"}
+    valueKeys       = []string{"Value", "Id", "Name"}
+    valueLabelKeys  = []string{"Name", "Label", "DisplayName", "Text"}
+    valueCountKeys  = []string{"Count", "ProductCount", "NumberOfProducts"}
+)
+
+// decodeFacets reads the FiltersData of a response. Its layout is not
+// documented, so both an array of filters and an object keyed by filter name
+// are accepted, and each filter's name, values and counts are looked up
+// under the keys the site has been seen to use. Repeated filters and values
+// keep their first occurrence. Filters data that holds filters but none of
+// those keys is an error, so a change of layout is noticed rather than read
+// as a site without filters.
+func decodeFacets(raw json.RawMessage) ([]Facet, error) {
+    if len(raw) == 0 || string(raw) == "null" {
+        return nil, nil
+    }
+    var list []map[string]json.RawMessage
+    if err := json.Unmarshal(raw, &list); err != nil {
+        var byName map[string]json.RawMessage
+        if json.Unmarshal(raw, &byName) != nil {
+            return nil, fmt.Errorf("FiltersData is neither a list nor an object of filters: %s", snippet(raw, 80))
+        }
+        for name, options := range byName {
+            list = append(list, map[string]json.RawMessage{"Name": mustJSON(name), "Options": options})
+        }
+        sort.Slice(list, func(i, j int) bool { return string(list[i]["Name"]) < string(list[j]["Name"]) })
+    }
+
+    var facets []Facet
+    seenFacets := make(map[string]bool)
+    for _, rawFacet := range list {
+        d := fieldDecoder{raw: rawFacet}
+        facet := Facet{
+            Name:  firstString(&d, facetNameKeys...),
+            Label: firstString(&d, facetLabelKeys...),
+        }
+        if facet.Name == "" {
+            return nil, fmt.Errorf("FiltersData layout not recognised: a filter has none of %s (it has %s)",
+                strings.Join(facetNameKeys, ", "), strings.Join(sortedKeys(rawFacet), ", "))
+        }
+        var options []map[string]json.RawMessage
+        found := false
+        for _, key := range facetValuesKeys {
+            if value := d.lookup(key); value != nil && json.Unmarshal(value, &options) == nil {
+                found = true
+                break
+            }
+        }
+        if !found {
+            return nil, fmt.Errorf("FiltersData layout not recognised: filter %s has no list under %s (it has %s)",
+                facet.Name, strings.Join(facetValuesKeys, ", "), strings.Join(sortedKeys(rawFacet), ", "))
+        }
+        seenValues := make(map[string]bool)
+        for _, rawOption := range options {
+            o := fieldDecoder{raw: rawOption}
+            value := FacetValue{
+                Value: firstString(&o, valueKeys...),
+                Label: firstString(&o, valueLabelKeys...),
+                Count: int(firstNum(&o, valueCountKeys...)),
+            }
+            if value.Value == "" {
+                return nil, fmt.Errorf("FiltersData layout not recognised: a value of filter %s has none of %s (it has %s)",
+                    facet.Name, strings.Join(valueKeys, ", "), strings.Join(sortedKeys(rawOption), ", "))
+            }
+            if !seenValues[value.Value] {
+                seenValues[value.Value] = true
+                facet.Values = append(facet.Values, value)
+            }
+        }
+        if !seenFacets[facet.Name] {
+            seenFacets[facet.Name] = true
+            facets = append(facets, facet)
+        }
+    }
+    return facets, nil
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func firstString(d *fieldDecoder, keys ...string) string {
+    for _, key := range keys {
+        if s := d.str(key); s != "" {
+            return s
+        }
+    }
+    return ""
+}
+
+func firstNum(d *fieldDecoder, keys ...string) float64 {
+    for _, key := range keys {
+        if n := d.num(key); n != 0 {
+            return n
+        }
+    }
+    return 0
+}
+
+func mustJSON(v interface{}) json.RawMessage {
+    data, _ := json.Marshal(v)
+    return data
+}
+
+// FindFacet returns the facet called name, ignoring case and spaces, so
+// "cask type" finds CaskType.
+func FindFacet(facets []Facet, name string) (Facet, bool) {
+    want := facetKey(name)
+    for _, facet := range facets {
+        if facetKey(facet.Name) == want || facetKey(facet.Label) == want {
+            return facet, true
+        }
+    }
+    return Facet{}, false
+}
+
+// FilterName returns the FilteringCriterias field a facet name refers to,
+// ignoring case and spaces, so "cask type" gives "CaskType". Only string
+// filters qualify.
+func FilterName(name string) (string, bool) {
+    field, ok := filterField(facetKey(name))
+    if !ok || field.Type.Kind() != reflect.String {
+        return "", false
+    }
+    return field.Name, true
+}
+
+func facetKey(name string) string {
+    return strings.ToLower(strings.ReplaceAll(name, " ", ""))
+}
+
+// facetPageSize is the page size Facets asks for: it only needs the filters
+// data, not the products.
+const facetPageSize = "1"
+
+// Facets fetches the filters the endpoint offers for q, from the first page
+// of its results.
+func (c *Client) Facets(ctx context.Context, q Query) ([]Facet, error) {
+    q.Display.PageSize = facetPageSize
+    page, err := c.FetchPage(ctx, q, 1)
+    if err != nil {
+        return nil, err
+    }
+    if page.FacetsErr != nil {
+        return nil, page.FacetsErr
+    }
+    if len(page.Facets) == 0 {
+        return nil, fmt.Errorf("the endpoint returned no filters data")
+    }
+    return page.Facets, nil
+}
+
+// ErrFacetFiltered is returned by TagFacet for a facet the query already
+// filters on.
+var ErrFacetFiltered = errors.New("the query already filters on this facet")
+
+// FacetTags records the facet values each product was listed under:
+// tags[ProductID][facet name] holds the values in the order they were found.
+type FacetTags map[string]map[string][]string
+
+// Apply sets p.Attributes from the tags for its ProductID. A product listed
+// under several values of one facet gets them joined with "; ".
+func (t FacetTags) Apply(p *Product) {
+    for facet, values := range t[p.ProductID] {
+        if p.Attributes == nil {
+            p.Attributes = make(map[string]string)
+        }
+        p.Attributes[facet] = strings.Join(values, "; ")
+    }
+}
+
+// Merge adds the tags of other to t, for tagging the products of several
+// queries.
+func (t FacetTags) Merge(other FacetTags) {
+    for id, facets := range other {
+        for facet, values := range facets {
+            for _, value := range values {
+                t.add(id, facet, value)
+            }
+        }
+    }
+}
+
+// add tags product id with value of facet, once.
+func (t FacetTags) add(id, facet, value string) {
+    if t[id] == nil {
+        t[id] = make(map[string][]string)
+    }
+    for _, v := range t[id][facet] {
+        if v == value {
+            return
+        }
+    }
+    t[id][facet] = append(t[id][facet], value)
+}
+
+// TagFacet crawls q once per value of facet, with the facet's filter set to
+// that value, and adds every product returned to tags. onValue, if set, is
+// called after each value with the number of products it returned. Facets
+// that q already filters on are rejected, since every product would share
+// the one value.
+func (c *Client) TagFacet(ctx context.Context, q Query, facet Facet, tags FacetTags, onValue func(value FacetValue, found int)) error {
+    field, ok := filterField(facetKey(facet.Name))
+    if !ok || field.Type.Kind() != reflect.String {
+        return fmt.Errorf("facet %q is not a filter the crawler can set", facet.Name)
+    }
+    if q.Filter(field.Name) != "" {
+        return ErrFacetFiltered
+    }
+
+    for _, value := range facet.Values {
+        filtered := q
+        reflect.ValueOf(&filtered.Filters).Elem().FieldByIndex(field.Index).SetString(value.Value)
+
+        found := 0
+        it := c.ListProducts(ctx, filtered)
+        for it.Next() {
+            for _, product := range it.Page().Products {
+                tags.add(product.ProductID, facet.Name, value.String())
+                found++
+            }
+        }
+        if err := it.Err(); err != nil {
+            return fmt.Errorf("listing %s=%s: %w", facet.Name, value.Value, err)
+        }
+        if onValue != nil {
+            onValue(value, found)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,129 @@
+package twe
+
+import (
+    "context"
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestDecodeFacets(t *testing.T) {
+    tests := []struct {
+        name    string
+        raw     string
+        want    []Facet
+        wantErr string
+    }{
+        {name: "missing", raw: ``},
+        {name: "null", raw: `null`},
+        {name: "empty list", raw: `[]`},
+        {
+            name: "list of filters",
+            raw: `[{"FilterName":"Country","DisplayName":"Country","Options":[
+                {"Value":"Scotland","Name":"Scotland","Count":12},
+                {"Value":"Japan","Name":"Japan","Count":3},
+                {"Value":"Scotland","Name":"Scotland again","Count":1}]},
+                {"Key":"CaskType","Title":"Cask type","Values":[{"Id":"7","Label":"Sherry","ProductCount":4}]},
+                {"FilterName":"Country","Options":[]}]`,
+            want: []Facet{
+                {Name: "Country", Label: "Country", Values: []FacetValue{{"Scotland", "Scotland", 12}, {"Japan", "Japan", 3}}},
+                {Name: "CaskType", Label: "Cask type", Values: []FacetValue{{"7", "Sherry", 4}}},
+            },
+        },
+        {
+            name: "object keyed by filter",
+            raw:  `{"Region":[{"Value":"Islay","Count":2}],"Age":[{"Value":"12","Text":"12 Years","NumberOfProducts":5}]}`,
+            want: []Facet{
+                {Name: "Age", Label: "Age", Values: []FacetValue{{"12", "12 Years", 5}}},
+                {Name: "Region", Label: "Region", Values: []FacetValue{{"Islay", "", 2}}},
+            },
+        },
+        {name: "filter without a name", raw: `[{"Heading":"Country","Options":[]}]`, wantErr: "none of FilterName, Key, Name (it has Heading, Options)"},
+        {name: "filter without values", raw: `[{"FilterName":"Country","Choices":[]}]`, wantErr: "filter Country has no list"},
+        {name: "value without a value", raw: `[{"FilterName":"Country","Options":[{"Caption":"Scotland"}]}]`, wantErr: "a value of filter Country has none of Value, Id, Name"},
+        {name: "not filters", raw: `"Country"`, wantErr: "neither a list nor an object"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := decodeFacets(json.RawMessage(tt.raw))
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("decodeFacets error = %v, want one containing %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("decodeFacets: %v", err)
+            }
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("decodeFacets = %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestFacetsAsksForTheSmallestPage(t *testing.T) {
+    var pageSize string
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var payload RequestPayload
+        body, _ := io.ReadAll(r.Body)
+        if err := json.Unmarshal(body, &payload); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        pageSize = payload.Model.DisplaySettings.PageSize
+        w.Header().Set("Content-Type", "application/json")
+        io.WriteString(w, `{"CurrentPage":1,"TotalPages":40,"Products":[],
+            "FiltersData":[{"FilterName":"Country","Options":[{"Value":"Scotland","Count":38}]}]}`)
+    }))
+    defer srv.Close()
+
+    client := NewClient(Config{BaseURL: srv.URL})
+    facets, err := client.Facets(context.Background(), DefaultQuery())
+    if err != nil {
+        t.Fatalf("Facets: %v", err)
+    }
+    if pageSize != facetPageSize {
+        t.Errorf("requested page size %q, want %q", pageSize, facetPageSize)
+    }
+    if len(facets) != 1 || facets[0].Name != "Country" || facets[0].Values[0].Count != 38 {
+        t.Errorf("Facets = %+v", facets)
+    }
+}
+
+func TestFacetsReportsUnknownLayout(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        io.WriteString(w, `{"CurrentPage":1,"TotalPages":1,"Products":[],"FiltersData":[{"Group":"Country","Choices":[]}]}`)
+    }))
+    defer srv.Close()
+
+    _, err := NewClient(Config{BaseURL: srv.URL}).Facets(context.Background(), DefaultQuery())
+    if err == nil || !strings.Contains(err.Error(), "FiltersData layout not recognised") {
+        t.Fatalf("Facets error = %v, want the layout reported", err)
+    }
+}
+
+func TestFacetTags(t *testing.T) {
+    tags := make(FacetTags)
+    tags.add("1", "Country", "Scotland")
+    tags.add("1", "Country", "Scotland")
+    other := FacetTags{"1": {"CaskType": {"Sherry", "Bourbon"}}, "2": {"Country": {"Japan"}}}
+    tags.Merge(other)
+
+    p := Product{ProductID: "1"}
+    tags.Apply(&p)
+    want := map[string]string{"Country": "Scotland", "CaskType": "Sherry; Bourbon"}
+    if !reflect.DeepEqual(p.Attributes, want) {
+        t.Errorf("Attributes = %v, want %v", p.Attributes, want)
+    }
+    untagged := Product{ProductID: "3"}
+    tags.Apply(&untagged)
+    if untagged.Attributes != nil {
+        t.Errorf("untagged product got Attributes %v", untagged.Attributes)
+    }
+}
@@ -0,0 +1,142 @@
+package twe
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// Fixtures are recorded productlistdata responses, one pair of files per
+// page in a directory:
+//
+//  page-0001.body  the response body exactly as received (still compressed)
+//  page-0001.json  status code and headers
+//
+// Set-Cookie headers are never recorded, so fixtures hold no session data.
+
+// fixtureMeta is stored next to each recorded body.
+type fixtureMeta struct {
+    StatusCode int         `json:"status"`
+    Header     http.Header `json:"header"`
+    RecordedAt time.Time   `json:"recordedAt"`
+}
+
+func fixturePath(dir string, page int, ext string) string {
+    return filepath.Join(dir, fmt.Sprintf("page-%04d%s", page, ext))
+}
+
+// RecordingTransport passes requests to Next and saves every response as a
+// fixture in Dir. A retried page overwrites its earlier recording.
+type RecordingTransport struct {
+    Dir  string
+    Next http.RoundTripper // nil means http.DefaultTransport
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    page, err := requestPage(req)
+    if err != nil {
+        return nil, err
+    }
+    next := t.Next
+    if next == nil {
+        next = http.DefaultTransport
+    }
+    resp, err := next.RoundTrip(req)
+    if err != nil {
+        return nil, err
+    }
+
+    body, err := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if err != nil {
+        return nil, err
+    }
+    resp.Body = io.NopCloser(bytes.NewReader(body))
+
+    header := resp.Header.Clone()
+    header.Del("Set-Cookie")
+    meta, err := json.MarshalIndent(fixtureMeta{StatusCode: resp.StatusCode, Header: header, RecordedAt: time.Now().UTC()}, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+        return nil, fmt.Errorf("recording page %d: %w", page, err)
+    }
+    if err := os.WriteFile(fixturePath(t.Dir, page, ".body"), body, 0o644); err != nil {
+        return nil, fmt.Errorf("recording page %d: %w", page, err)
+    }
+    if err := os.WriteFile(fixturePath(t.Dir, page, ".json"), meta, 0o644); err != nil {
+        return nil, fmt.Errorf("recording page %d: %w", page, err)
+    }
+    return resp, nil
+}
+
+// ReplayTransport answers requests from the fixtures in Dir without touching
+// the network. A page that was never recorded gets a 404, which the Client
+// reports without retrying.
+type ReplayTransport struct {
+    Dir string
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    page, err := requestPage(req)
+    if err != nil {
+        return nil, err
+    }
+
+    body, err := os.ReadFile(fixturePath(t.Dir, page, ".body"))
+    if os.IsNotExist(err) {
+        return &http.Response{
+            StatusCode: http.StatusNotFound,
+            Status:     "404 Not Found",
+            Header:     http.Header{"Content-Type": {"text/plain"}},
+            Body:       io.NopCloser(bytes.NewReader([]byte(fmt.Sprintf("no recorded response for page %d in %s", page, t.Dir)))),
+            Request:    req,
+        }, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    data, err := os.ReadFile(fixturePath(t.Dir, page, ".json"))
+    if err != nil {
+        return nil, err
+    }
+    var meta fixtureMeta
+    if err := json.Unmarshal(data, &meta); err != nil {
+        return nil, fmt.Errorf("%s: %w", fixturePath(t.Dir, page, ".json"), err)
+    }
+
+    return &http.Response{
+        StatusCode:    meta.StatusCode,
+        Status:        fmt.Sprintf("%d %s", meta.StatusCode, http.StatusText(meta.StatusCode)),
+        Header:        meta.Header,
+        Body:          io.NopCloser(bytes.NewReader(body)),
+        ContentLength: int64(len(body)),
+        Request:       req,
+    }, nil
+}
+
+// requestPage reads the page number out of a productlistdata request and
+// leaves the body readable for whoever sends it.
+func requestPage(req *http.Request) (int, error) {
+    if req.Body == nil {
+        return 0, fmt.Errorf("request to %s has no body", req.URL)
+    }
+    payload, err := io.ReadAll(req.Body)
+    req.Body.Close()
+    if err != nil {
+        return 0, err
+    }
+    req.Body = io.NopCloser(bytes.NewReader(payload))
+
+    var parsed RequestPayload
+    if err := json.Unmarshal(payload, &parsed); err != nil {
+        return 0, fmt.Errorf("request to %s is not a productlistdata payload: %w", req.URL, err)
+    }
+    return parsed.Model.DisplaySettings.PageNumber, nil
+}
@@ -0,0 +1,50 @@
+package twe
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// pacer spaces out request starts to the same host, however many goroutines
+// are fetching.
+type pacer struct {
+    mu       sync.Mutex
+    interval time.Duration
+    next     time.Time
+}
+
+// wait blocks until the caller may start a request or ctx is done.
+func (p *pacer) wait(ctx context.Context) error {
+    p.mu.Lock()
+    now := time.Now()
+    start := p.next
+    if start.Before(now) {
+        start = now
+    }
+    p.next = start.Add(p.interval)
+    p.mu.Unlock()
+
+    delay := time.Until(start)
+    if delay <= 0 {
+        return ctx.Err()
+    }
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-timer.C:
+        return nil
+    }
+}
+
+// pause holds every request back for d, so all workers back off together
+// when the site says it is being hit too hard.
+func (p *pacer) pause(d time.Duration) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if until := time.Now().Add(d); until.After(p.next) {
+        p.next = until
+    }
+}
@@ -0,0 +1,302 @@
+package twe
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Product is a single entry of the "Products" array returned by the
+// productlistdata endpoint, plus the fields we add ourselves while crawling
+// (url and scrapedDate).
+type Product struct {
+    ProductID          string    `json:"ProductID"`
+    Name               string    `json:"Name"`
+    Description        string    `json:"Description"`
+    SalesPrice         float64   `json:"SalesPrice"`
+    SalesPriceExVat    float64   `json:"SalesPriceExVat"`
+    StrengthInPC       float64   `json:"StrengthInPC"`
+    SizeInCL           float64   `json:"SizeInCL"`
+    ProductImageUrl    string    `json:"ProductImageUrl"`
+    IsActive           bool      `json:"IsActive"`
+    MaxOrderQuantity   float64   `json:"MaxOrderQuantity"`
+    Manufacturer       string    `json:"Manufacturer"`
+    Brand              string    `json:"Brand"`
+    MasterCategoryName string    `json:"MasterCategoryName"`
+    CategoryName       string    `json:"CategoryName"`
+    Weight             float64   `json:"Weight"`
+    StockLevel         float64   `json:"StockLevel"`
+    StockControl       float64   `json:"StockControl"`
+    IsOutOfStock       bool      `json:"IsOutOfStock"`
+    URL                string    `json:"url"`
+    ScrapedDate        time.Time `json:"scrapedDate"`
+    // Details is only set when product pages were visited (see DetailFetcher).
+    Details *Details `json:"details,omitempty"`
+    // Attributes holds the facet values the product was listed under, keyed
+    // by facet name (see FacetTags).
+    Attributes map[string]string `json:"attributes,omitempty"`
+    // Queries names the queries of a multi-query crawl that listed the
+    // product, in the order they ran.
+    Queries []string `json:"queries,omitempty"`
+
+    // Extra keeps every field the API sent that we don't model explicitly,
+    // so nothing is lost when the product is written back out.
+    Extra map[string]json.RawMessage `json:"-"`
+}
+
+// FieldError describes a single field that could not be decoded into its
+// Product type. The product is still usable; the field keeps its zero value.
+type FieldError struct {
+    Field string
+    Raw   string
+    Err   error
+}
+
+func (e FieldError) Error() string {
+    return fmt.Sprintf("field %s: cannot decode %s: %v", e.Field, e.Raw, e.Err)
+}
+
+// DecodeProduct decodes a single raw product object. Numbers may arrive as
+// JSON numbers or strings, booleans as bools, numbers or strings, and any
+// field may be null or missing. Problems with individual fields are returned
+// as FieldErrors instead of failing the whole product; err is only set when
+// data is not a JSON object at all.
+func DecodeProduct(data []byte) (p Product, fieldErrs []FieldError, err error) {
+    var raw map[string]json.RawMessage
+    if err = json.Unmarshal(data, &raw); err != nil {
+        return p, nil, err
+    }
+    if raw == nil {
+        return p, nil, fmt.Errorf("product is null")
+    }
+
+    d := fieldDecoder{raw: raw}
+    p.ProductID = d.id("ProductID")
+    p.Name = d.str("Name")
+    p.Description = d.str("Description")
+    p.SalesPrice = d.num("SalesPrice")
+    p.SalesPriceExVat = d.num("SalesPriceExVat")
+    p.StrengthInPC = d.num("StrengthInPC")
+    p.SizeInCL = d.num("SizeInCL")
+    p.ProductImageUrl = d.str("ProductImageUrl")
+    p.IsActive = d.boolean("IsActive")
+    p.MaxOrderQuantity = d.num("MaxOrderQuantity")
+    p.Manufacturer = d.str("Manufacturer")
+    p.Brand = d.str("Brand")
+    p.MasterCategoryName = d.str("MasterCategoryName")
+    p.CategoryName = d.str("CategoryName")
+    p.Weight = d.num("Weight")
+    p.StockLevel = d.num("StockLevel")
+    p.StockControl = d.num("StockControl")
+    p.IsOutOfStock = d.boolean("IsOutOfStock")
+    p.URL = d.str("url")
+    p.ScrapedDate = d.time("scrapedDate")
+    if value := d.lookup("details"); value != nil {
+        var details Details
+        if err := json.Unmarshal(value, &details); err != nil {
+            d.fail("details", value, err)
+        } else {
+            p.Details = &details
+        }
+    }
+    if value := d.lookup("attributes"); value != nil {
+        if err := json.Unmarshal(value, &p.Attributes); err != nil {
+            d.fail("attributes", value, err)
+        }
+    }
+    if value := d.lookup("queries"); value != nil {
+        if err := json.Unmarshal(value, &p.Queries); err != nil {
+            d.fail("queries", value, err)
+        }
+    }
+
+    for key, value := range raw {
+        if d.seen[key] {
+            continue
+        }
+        if p.Extra == nil {
+            p.Extra = make(map[string]json.RawMessage)
+        }
+        p.Extra[key] = value
+    }
+    return p, d.errs, nil
+}
+
+// UnmarshalJSON decodes a product leniently, silently dropping fields that
+// cannot be decoded. Use DecodeProduct when the field problems matter.
+func (p *Product) UnmarshalJSON(data []byte) error {
+    decoded, _, err := DecodeProduct(data)
+    if err != nil {
+        return err
+    }
+    *p = decoded
+    return nil
+}
+
+// MarshalJSON writes the modelled fields together with any Extra fields the API
+// returned, so output.json carries the full upstream record.
+func (p Product) MarshalJSON() ([]byte, error) {
+    type plain Product
+    known, err := json.Marshal(plain(p))
+    if err != nil || len(p.Extra) == 0 {
+        return known, err
+    }
+
+    var merged map[string]json.RawMessage
+    if err := json.Unmarshal(known, &merged); err != nil {
+        return nil, err
+    }
+    for key, value := range p.Extra {
+        if _, ok := merged[key]; !ok {
+            merged[key] = value
+        }
+    }
+    return json.Marshal(merged)
+}
+
+// fieldDecoder pulls typed values out of a raw JSON object, recording which
+// keys were consumed and which ones failed to decode.
+type fieldDecoder struct {
+    raw  map[string]json.RawMessage
+    seen map[string]bool
+    errs []FieldError
+}
+
+// lookup returns the raw value for key, or nil when it is missing or null.
+func (d *fieldDecoder) lookup(key string) json.RawMessage {
+    if d.seen == nil {
+        d.seen = make(map[string]bool)
+    }
+    d.seen[key] = true
+    value, ok := d.raw[key]
+    if !ok || bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+        return nil
+    }
+    return value
+}
+
+func (d *fieldDecoder) fail(key string, value json.RawMessage, err error) {
+    d.errs = append(d.errs, FieldError{Field: key, Raw: string(value), Err: err})
+}
+
+func (d *fieldDecoder) str(key string) string {
+    value := d.lookup(key)
+    if value == nil {
+        return ""
+    }
+    var s string
+    if err := json.Unmarshal(value, &s); err == nil {
+        return s
+    }
+    // Numbers and booleans are kept in their literal form.
+    var n json.Number
+    if err := json.Unmarshal(value, &n); err == nil {
+        return n.String()
+    }
+    var b bool
+    if err := json.Unmarshal(value, &b); err == nil {
+        return strconv.FormatBool(b)
+    }
+    d.fail(key, value, fmt.Errorf("expected string"))
+    return ""
+}
+
+// id decodes identifiers, which the API sends as integers but which we
+// always handle as strings.
+func (d *fieldDecoder) id(key string) string {
+    value := d.lookup(key)
+    if value == nil {
+        return ""
+    }
+    var n json.Number
+    if err := json.Unmarshal(value, &n); err == nil {
+        if f, err := n.Float64(); err == nil && f == float64(int64(f)) {
+            return strconv.FormatInt(int64(f), 10)
+        }
+        return n.String()
+    }
+    var s string
+    if err := json.Unmarshal(value, &s); err == nil {
+        return strings.TrimSpace(s)
+    }
+    d.fail(key, value, fmt.Errorf("expected number or string"))
+    return ""
+}
+
+func (d *fieldDecoder) num(key string) float64 {
+    value := d.lookup(key)
+    if value == nil {
+        return 0
+    }
+    var f float64
+    if err := json.Unmarshal(value, &f); err == nil {
+        return f
+    }
+    var s string
+    if err := json.Unmarshal(value, &s); err == nil {
+        s = strings.TrimSpace(s)
+        if s == "" {
+            return 0
+        }
+        f, err := strconv.ParseFloat(s, 64)
+        if err != nil {
+            d.fail(key, value, err)
+            return 0
+        }
+        return f
+    }
+    d.fail(key, value, fmt.Errorf("expected number"))
+    return 0
+}
+
+func (d *fieldDecoder) boolean(key string) bool {
+    value := d.lookup(key)
+    if value == nil {
+        return false
+    }
+    var b bool
+    if err := json.Unmarshal(value, &b); err == nil {
+        return b
+    }
+    var f float64
+    if err := json.Unmarshal(value, &f); err == nil {
+        return f != 0
+    }
+    var s string
+    if err := json.Unmarshal(value, &s); err == nil {
+        s = strings.TrimSpace(s)
+        if s == "" {
+            return false
+        }
+        b, err := strconv.ParseBool(s)
+        if err != nil {
+            d.fail(key, value, err)
+            return false
+        }
+        return b
+    }
+    d.fail(key, value, fmt.Errorf("expected boolean"))
+    return false
+}
+
+func (d *fieldDecoder) time(key string) time.Time {
+    value := d.lookup(key)
+    if value == nil {
+        return time.Time{}
+    }
+    var t time.Time
+    if err := json.Unmarshal(value, &t); err == nil {
+        return t
+    }
+    var s string
+    if err := json.Unmarshal(value, &s); err == nil {
+        if t, err := time.Parse("2006-01-02", s); err == nil {
+            return t
+        }
+    }
+    d.fail(key, value, fmt.Errorf("expected RFC 3339 or YYYY-MM-DD time"))
+    return time.Time{}
+}
@@ -0,0 +1,135 @@
+package twe
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestDecodeProduct(t *testing.T) {
+    // fieldErr is a FieldError without its Err, which is compared by field
+    // and raw value only.
+    type fieldErr struct{ Field, Raw string }
+    tests := []struct {
+        name     string
+        data     string
+        want     Product
+        wantErrs []fieldErr
+    }{
+        {
+            name: "typed values",
+            data: `{"ProductID":7,"Name":"Ardbeg 10","SalesPrice":49.5,"StockLevel":12,"IsActive":true,"IsOutOfStock":false}`,
+            want: Product{ProductID: "7", Name: "Ardbeg 10", SalesPrice: 49.5, StockLevel: 12, IsActive: true},
+        },
+        {
+            name: "numbers and booleans as strings",
+            data: `{"ProductID":" 7 ","SalesPrice":" 49.50 ","StockLevel":"","StrengthInPC":"46","IsActive":"true","IsOutOfStock":"0"}`,
+            want: Product{ProductID: "7", SalesPrice: 49.5, StrengthInPC: 46, IsActive: true},
+        },
+        {
+            name: "booleans as numbers",
+            data: `{"IsActive":1,"IsOutOfStock":0}`,
+            want: Product{IsActive: true},
+        },
+        {
+            name: "fractional id",
+            data: `{"ProductID":7.5}`,
+            want: Product{ProductID: "7.5"},
+        },
+        {
+            name: "strings as numbers and booleans",
+            data: `{"Name":1792,"Brand":true}`,
+            want: Product{Name: "1792", Brand: "true"},
+        },
+        {
+            name: "nulls",
+            data: `{"ProductID":null,"Name":null,"SalesPrice":null,"IsActive":null,"scrapedDate":null,"details":null,"attributes":null}`,
+        },
+        {
+            name: "dates",
+            data: `{"scrapedDate":"2026-10-16"}`,
+            want: Product{ScrapedDate: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+        },
+        {
+            name: "our own fields",
+            data: `{"url":"https://example.com/p/7","scrapedDate":"2026-10-16T10:30:00Z","details":{"region":"Islay"},"attributes":{"Country":"Scotland"},"queries":["islay"]}`,
+            want: Product{
+                URL:         "https://example.com/p/7",
+                ScrapedDate: time.Date(2026, 10, 16, 10, 30, 0, 0, time.UTC),
+                Details:     &Details{Region: "Islay"},
+                Attributes:  map[string]string{"Country": "Scotland"},
+                Queries:     []string{"islay"},
+            },
+        },
+        {
+            name: "unknown fields",
+            data: `{"ProductID":1,"Volume":"70cl","Tags":["a", "b"],"Promo":null}`,
+            want: Product{ProductID: "1", Extra: map[string]json.RawMessage{
+                "Volume": json.RawMessage(`"70cl"`),
+                "Tags":   json.RawMessage(`["a", "b"]`),
+                "Promo":  json.RawMessage(`null`),
+            }},
+        },
+        {
+            name: "field errors",
+            data: `{"ProductID":{},"Name":"Ardbeg 10","SalesPrice":"£49","IsActive":"yes","Weight":[1],"scrapedDate":"yesterday","details":"none"}`,
+            want: Product{Name: "Ardbeg 10"},
+            wantErrs: []fieldErr{
+                {"ProductID", `{}`},
+                {"SalesPrice", `"£49"`},
+                {"IsActive", `"yes"`},
+                {"Weight", `[1]`},
+                {"scrapedDate", `"yesterday"`},
+                {"details", `"none"`},
+            },
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, fieldErrs, err := DecodeProduct([]byte(tt.data))
+            if err != nil {
+                t.Fatalf("DecodeProduct: %v", err)
+            }
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("DecodeProduct =\n%+v\nwant\n%+v", got, tt.want)
+            }
+            var gotErrs []fieldErr
+            for _, fe := range fieldErrs {
+                if fe.Err == nil {
+                    t.Errorf("field %s failed without an error", fe.Field)
+                }
+                gotErrs = append(gotErrs, fieldErr{fe.Field, fe.Raw})
+            }
+            if !reflect.DeepEqual(gotErrs, tt.wantErrs) {
+                t.Errorf("field errors = %v, want %v", gotErrs, tt.wantErrs)
+            }
+        })
+    }
+}
+
+func TestDecodeProductNotAnObject(t *testing.T) {
+    for _, data := range []string{`null`, `[]`, `"7"`, `{`} {
+        if _, _, err := DecodeProduct([]byte(data)); err == nil {
+            t.Errorf("DecodeProduct(%s) succeeded", data)
+        }
+    }
+}
+
+func TestProductKeepsUnknownFields(t *testing.T) {
+    var p Product
+    if err := json.Unmarshal([]byte(`{"ProductID":"7","SalesPrice":"oops","Volume":"70cl"}`), &p); err != nil {
+        t.Fatalf("Unmarshal: %v", err)
+    }
+    data, err := json.Marshal(p)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var out map[string]interface{}
+    if err := json.Unmarshal(data, &out); err != nil {
+        t.Fatal(err)
+    }
+    if out["ProductID"] != "7" || out["SalesPrice"] != 0.0 || out["Volume"] != "70cl" {
+        t.Errorf("round trip = %s", data)
+    }
+}
@@ -0,0 +1,105 @@
+package twe
+
+import "reflect"
+
+// Query describes one product listing request. The page number is filled in
+// by the Client while paginating, so DisplaySettings.PageNumber is ignored.
+type Query struct {
+    Filters FilteringCriterias
+    Display DisplaySettings
+}
+
+// DefaultQuery returns the catalogue-wide search the crawler has always run:
+// the search text "s" with the largest page size the endpoint accepts.
+func DefaultQuery() Query {
+    return Query{
+        Filters: FilteringCriterias{
+            SearchTextToFilterBy: "s",
+            IsOnOffer:            false,
+            IncludeOutOfStock:    false,
+            Price:                nil,
+        },
+        Display: DisplaySettings{
+            ViewMode:                  "grid",
+            PageSize:                  "1000",
+            SortingOrder:              "rdesc",
+            AnalyticsTrackingCategory: "Search page",
+        },
+    }
+}
+
+// CoversCatalogue reports whether q filters like DefaultQuery, i.e. a
+// complete crawl of it should return every listed product. Display settings
+// such as page size don't affect the result set.
+func (q Query) CoversCatalogue() bool {
+    return reflect.DeepEqual(q.Filters, DefaultQuery().Filters)
+}
+
+// Request Payload Structures (for the productlistdata endpoint)
+type RequestPayload struct {
+    Model Model `json:"model"`
+}
+
+type FilteringCriterias struct {
+    CategoryTagsToFilterBy string      `json:"CategoryTagsToFilterBy"`
+    CategoryIdsToFilterBy  string      `json:"CategoryIdsToFilterBy"`
+    BrandIdsToFilterBy     string      `json:"BrandIdsToFilterBy"`
+    BuyListIdsToFilterBy   string      `json:"BuyListIdsToFilterBy"`
+    SearchTextToFilterBy   string      `json:"SearchTextToFilterBy"`
+    URLWhereToDisplay      string      `json:"urlwheretodisplay"`
+    ExcludeCategoryTags    string      `json:"ExcludeCategoryTags"`
+    ExcludeCategoryIds     string      `json:"ExcludeCategoryIds"`
+    ExcludeBrandIds        string      `json:"ExcludeBrandIds"`
+    ExcludeBuyListIds      string      `json:"ExcludeBuyListIds"`
+    BottlingStatus         string      `json:"BottlingStatus"`
+    Category               string      `json:"Category"`
+    Country                string      `json:"Country"`
+    Region                 string      `json:"Region"`
+    Author                 string      `json:"Author"`
+    Brand                  string      `json:"Brand"`
+    GrapeVariety           string      `json:"GrapeVariety"`
+    FlavourProfile         string      `json:"FlavourProfile"`
+    Age                    string      `json:"Age"`
+    Vintage                string      `json:"Vintage"`
+    Type                   string      `json:"Type"`
+    Style                  string      `json:"Style"`
+    CaskType               string      `json:"CaskType"`
+    SingleCask             string      `json:"SingleCask"`
+    Bottler                string      `json:"Bottler"`
+    Series                 string      `json:"Series"`
+    Strength               string      `json:"Strength"`
+    Size                   string      `json:"Size"`
+    Certification          string      `json:"Certification"`
+    Sustainability         string      `json:"Sustainability"`
+    AgedAtOrigin           string      `json:"AgedAtOrigin"`
+    LimitedEdition         string      `json:"LimitedEdition"`
+    FoodPairing            string      `json:"FoodPairing"`
+    Colouring              string      `json:"Colouring"`
+    Flavour                string      `json:"Flavour"`
+    IsOnOffer              bool        `json:"IsOnOffer"`
+    IncludeOutOfStock      bool        `json:"IncludeOutOfStock"`
+    Price                  interface{} `json:"Price"` // Use interface{} for null
+}
+
+type DisplaySettings struct {
+    PageNumber                int    `json:"PageNumber"`
+    ViewMode                  string `json:"ViewMode"`
+    PageSize                  string `json:"PageSize"`
+    SortingOrder              string `json:"SortingOrder"`
+    AnalyticsTrackingCategory string `json:"AnalyticsTrackingCategory"`
+}
+
+type DataReturnedSettings struct {
+    RemoveSelectedFiltersFromFiltersData bool `json:"RemoveSelectedFiltersFromFiltersData"`
+    ReturnArrayOfProductDataForGA4       bool `json:"ReturnArrayOfProductDataForGA4"`
+    ReturnArrayOfProducts                bool `json:"ReturnArrayOfProducts"`
+    ReturnProductListHtml                bool `json:"ReturnProductListHtml"`
+}
+
+type Model struct {
+    FilteringCriterias      FilteringCriterias   `json:"FilteringCriterias"`
+    DisplaySettings         DisplaySettings      `json:"DisplaySettings"`
+    CurrentCustomerSettings string               `json:"CurrentCustomerSettings"`
+    ApiToken                string               `json:"ApiToken"`
+    DataReturnedSettings    DataReturnedSettings `json:"DataReturnedSettings"`
+}
@@ -0,0 +1,291 @@
+package twe
+
+import (
+    "fmt"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode"
+)
+
+// Query expressions are whitespace separated terms of the form key=value or
+// a bare key for boolean filters, e.g.
+//
+//	country=Scotland region=Islay age=12-18 onoffer
+//	brand="Port Ellen" price=50-200 sort=pasc pagesize=500
+//
+// Keys are matched case-insensitively against the FilteringCriterias field
+// names (and their JSON names), plus a handful of shorter aliases. Values are
+// passed to the API verbatim, so comma-separated ID lists work as the site
+// expects them.
+
+// queryAliases maps convenient short keys onto FilteringCriterias fields.
+var queryAliases = map[string]string{
+    "search":       "SearchTextToFilterBy",
+    "q":            "SearchTextToFilterBy",
+    "categoryids":  "CategoryIdsToFilterBy",
+    "categorytag":  "CategoryTagsToFilterBy",
+    "categorytags": "CategoryTagsToFilterBy",
+    "brandids":     "BrandIdsToFilterBy",
+    "buylistids":   "BuyListIdsToFilterBy",
+    "abv":          "Strength",
+    "onoffer":      "IsOnOffer",
+    "offers":       "IsOnOffer",
+    "outofstock":   "IncludeOutOfStock",
+}
+
+// displayKeys maps keys onto DisplaySettings fields.
+var displayKeys = map[string]string{
+    "pagesize":     "PageSize",
+    "sort":         "SortingOrder",
+    "sortingorder": "SortingOrder",
+    "view":         "ViewMode",
+    "viewmode":     "ViewMode",
+}
+
+// ParseQuery parses expr on top of DefaultQuery.
+func ParseQuery(expr string) (Query, error) {
+    q := DefaultQuery()
+    if err := q.Apply(expr); err != nil {
+        return Query{}, err
+    }
+    return q, nil
+}
+
+// Apply parses expr and sets each term on q, leaving unmentioned settings as
+// they are.
+func (q *Query) Apply(expr string) error {
+    terms, err := splitTerms(expr)
+    if err != nil {
+        return err
+    }
+    for _, term := range terms {
+        key, value, hasValue := strings.Cut(term, "=")
+        if err := q.Set(key, value, hasValue); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Set assigns a single filter or display setting. hasValue is false for bare
+// keys such as "onoffer", which are only valid for boolean filters.
+func (q *Query) Set(key, value string, hasValue bool) error {
+    name := strings.ToLower(strings.TrimSpace(key))
+    if name == "" {
+        return fmt.Errorf("query term %q has no key", key+"="+value)
+    }
+
+    if field, ok := displayKeys[name]; ok {
+        if !hasValue {
+            return fmt.Errorf("query key %q needs a value", key)
+        }
+        if field == "PageSize" {
+            if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+                return fmt.Errorf("pagesize must be a positive number, got %q", value)
+            }
+        }
+        reflect.ValueOf(&q.Display).Elem().FieldByName(field).SetString(value)
+        return nil
+    }
+
+    if name == "price" {
+        if !hasValue {
+            return fmt.Errorf("query key %q needs a value", key)
+        }
+        if value == "" {
+            q.Filters.Price = nil
+            return nil
+        }
+        if _, _, err := ParsePriceRange(value); err != nil {
+            return err
+        }
+        q.Filters.Price = value
+        return nil
+    }
+
+    field, ok := filterField(name)
+    if !ok {
+        return fmt.Errorf("unknown query key %q (known keys: %s)", key, strings.Join(QueryKeys(), ", "))
+    }
+
+    target := reflect.ValueOf(&q.Filters).Elem().FieldByIndex(field.Index)
+    switch target.Kind() {
+    case reflect.Bool:
+        b := true
+        if hasValue {
+            var err error
+            if b, err = strconv.ParseBool(value); err != nil {
+                return fmt.Errorf("query key %q expects true or false, got %q", key, value)
+            }
+        }
+        target.SetBool(b)
+    case reflect.String:
+        if !hasValue {
+            return fmt.Errorf("query key %q needs a value", key)
+        }
+        target.SetString(value)
+    default:
+        return fmt.Errorf("query key %q cannot be set", key)
+    }
+    return nil
+}
+
+// Filter returns the value of the string filter key, or "" when it is unset
+// or key names no string filter.
+func (q Query) Filter(key string) string {
+    field, ok := filterField(strings.ToLower(key))
+    if !ok || field.Type.Kind() != reflect.String {
+        return ""
+    }
+    return reflect.ValueOf(q.Filters).FieldByIndex(field.Index).String()
+}
+
+// ParsePriceRange parses a "min-max" price band. Either side may be left
+// empty for an open-ended band ("-20", "100-").
+func ParsePriceRange(s string) (min, max float64, err error) {
+    from, to, ok := strings.Cut(s, "-")
+    if !ok {
+        return 0, 0, fmt.Errorf("price must be a min-max range, got %q", s)
+    }
+    if from != "" {
+        if min, err = strconv.ParseFloat(from, 64); err != nil {
+            return 0, 0, fmt.Errorf("invalid minimum price %q", from)
+        }
+    }
+    if to != "" {
+        if max, err = strconv.ParseFloat(to, 64); err != nil {
+            return 0, 0, fmt.Errorf("invalid maximum price %q", to)
+        }
+        if max < min {
+            return 0, 0, fmt.Errorf("price range %q has max below min", s)
+        }
+    }
+    return min, max, nil
+}
+
+// QueryKeys lists every key accepted by Query.Set, for help output.
+func QueryKeys() []string {
+    keys := []string{"price"}
+    for key := range displayKeys {
+        keys = append(keys, key)
+    }
+    for key := range queryAliases {
+        keys = append(keys, key)
+    }
+    t := reflect.TypeOf(FilteringCriterias{})
+    for i := 0; i < t.NumField(); i++ {
+        if name := strings.ToLower(t.Field(i).Name); name != "price" {
+            keys = append(keys, name)
+        }
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// String renders the query in the expression syntax, listing only the
+// settings that differ from DefaultQuery. ParseQuery(q.String()) yields q.
+func (q Query) String() string {
+    var terms []string
+    def := DefaultQuery()
+
+    t := reflect.TypeOf(q.Filters)
+    cur := reflect.ValueOf(q.Filters)
+    base := reflect.ValueOf(def.Filters)
+    for i := 0; i < t.NumField(); i++ {
+        name := strings.ToLower(t.Field(i).Name)
+        value := cur.Field(i)
+        if reflect.DeepEqual(value.Interface(), base.Field(i).Interface()) {
+            continue
+        }
+        switch value.Kind() {
+        case reflect.Bool:
+            terms = append(terms, fmt.Sprintf("%s=%t", name, value.Bool()))
+        default:
+            terms = append(terms, name+"="+quoteTerm(fmt.Sprint(value.Interface())))
+        }
+    }
+
+    t = reflect.TypeOf(q.Display)
+    cur = reflect.ValueOf(q.Display)
+    base = reflect.ValueOf(def.Display)
+    for i := 0; i < t.NumField(); i++ {
+        name := strings.ToLower(t.Field(i).Name)
+        if name == "pagenumber" || cur.Field(i).String() == base.Field(i).String() {
+            continue
+        }
+        terms = append(terms, name+"="+quoteTerm(cur.Field(i).String()))
+    }
+    return strings.Join(terms, " ")
+}
+
+func filterField(name string) (reflect.StructField, bool) {
+    if alias, ok := queryAliases[name]; ok {
+        name = strings.ToLower(alias)
+    }
+    t := reflect.TypeOf(FilteringCriterias{})
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+        if strings.ToLower(f.Name) == name || strings.ToLower(tag) == name {
+            return f, true
+        }
+    }
+    return reflect.StructField{}, false
+}
+
+// splitTerms splits on whitespace, honouring single and double quotes so
+// values like brand="Port Ellen" stay in one term.
+func splitTerms(expr string) ([]string, error) {
+    var terms []string
+    var current strings.Builder
+    var quote rune
+    inTerm := false
+
+    for _, r := range expr {
+        switch {
+        case quote != 0:
+            if r == quote {
+                quote = 0
+            } else {
+                current.WriteRune(r)
+            }
+        case r == '"' || r == '\'':
+            quote = r
+            inTerm = true
+        case unicode.IsSpace(r):
+            if inTerm {
+                terms = append(terms, current.String())
+                current.Reset()
+                inTerm = false
+            }
+        default:
+            current.WriteRune(r)
+            inTerm = true
+        }
+    }
+    if quote != 0 {
+        return nil, fmt.Errorf("unterminated quote in query %q", expr)
+    }
+    if inTerm {
+        terms = append(terms, current.String())
+    }
+    return terms, nil
+}
+
+// quoteTerm quotes s so that splitTerms reads it back as one term. A value
+// holding both kinds of quote is written as adjacent quoted pieces, the way
+// a shell would: it's "new" becomes 'it'"'"'s "new"'.
+func quoteTerm(s string) string {
+    single, double := strings.Contains(s, "'"), strings.Contains(s, `"`)
+    switch {
+    case single && double:
+        return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+    case double:
+        return "'" + s + "'"
+    case s == "" || single || strings.IndexFunc(s, unicode.IsSpace) >= 0:
+        return `"` + s + `"`
+    }
+    return s
+}
@@ -0,0 +1,84 @@
+package twe
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestQueryStringRoundTrip(t *testing.T) {
+    tests := []struct {
+        name  string
+        key   string
+        value string
+    }{
+        {"plain", "country", "Scotland"},
+        {"space", "brand", "Port Ellen"},
+        {"tab", "brand", "Port\tEllen"},
+        {"empty", "search", ""},
+        {"single quote", "search", "Jack's"},
+        {"double quote", "search", `the "Beast"`},
+        {"both quotes", "search", `it's "new"`},
+        {"only quotes", "search", `'"'"`},
+        {"equals sign", "search", "a=b"},
+        {"display setting", "sort", "pasc"},
+        {"price", "price", "50-200"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            want := DefaultQuery()
+            if err := want.Set(tt.key, tt.value, true); err != nil {
+                t.Fatalf("Set(%q, %q): %v", tt.key, tt.value, err)
+            }
+            got, err := ParseQuery(want.String())
+            if err != nil {
+                t.Fatalf("ParseQuery(%q): %v", want.String(), err)
+            }
+            if !reflect.DeepEqual(got, want) {
+                t.Errorf("ParseQuery(%q) = %+v, want %+v", want.String(), got, want)
+            }
+        })
+    }
+}
+
+func TestParseQuery(t *testing.T) {
+    tests := []struct {
+        expr    string
+        want    func(q *Query)
+        wantErr bool
+    }{
+        {expr: "country=Scotland onoffer", want: func(q *Query) {
+            q.Filters.Country = "Scotland"
+            q.Filters.IsOnOffer = true
+        }},
+        {expr: `brand="Port Ellen" pagesize=500`, want: func(q *Query) {
+            q.Filters.Brand = "Port Ellen"
+            q.Display.PageSize = "500"
+        }},
+        {expr: `search='it'"'"'s'`, want: func(q *Query) { q.Filters.SearchTextToFilterBy = "it's" }},
+        {expr: "Country=Japan", want: func(q *Query) { q.Filters.Country = "Japan" }},
+        {expr: `brand="Port Ellen`, wantErr: true},
+        {expr: "pagesize=0", wantErr: true},
+        {expr: "colour=red", wantErr: true},
+        {expr: "country", wantErr: true},
+        {expr: "onoffer=maybe", wantErr: true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.expr, func(t *testing.T) {
+            got, err := ParseQuery(tt.expr)
+            if tt.wantErr {
+                if err == nil {
+                    t.Fatalf("ParseQuery(%q) succeeded, want an error", tt.expr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ParseQuery(%q): %v", tt.expr, err)
+            }
+            want := DefaultQuery()
+            tt.want(&want)
+            if !reflect.DeepEqual(got, want) {
+                t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.expr, got, want)
+            }
+        })
+    }
+}
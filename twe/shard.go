@@ -0,0 +1,123 @@
+package twe
+
+import (
+    "context"
+    "strconv"
+)
+
+// PriceBand is an inclusive SalesPrice range in pence, as sent in the Price
+// filter. A negative To leaves the band open-ended.
+type PriceBand struct {
+    From, To int
+}
+
+// AllPrices is the band every product falls in.
+var AllPrices = PriceBand{From: 0, To: -1}
+
+// openBandSplit is where the open-ended band starting at 0 is first split,
+// in pence. Higher open bands split at twice their start.
+const openBandSplit = 10000
+
+func (b PriceBand) String() string {
+    if b.To < 0 {
+        return pounds(b.From) + "-"
+    }
+    return pounds(b.From) + "-" + pounds(b.To)
+}
+
+// MarshalText writes b in its Price filter form, e.g. "20-39.99".
+func (b PriceBand) MarshalText() ([]byte, error) {
+    return []byte(b.String()), nil
+}
+
+func pounds(pence int) string {
+    return strconv.FormatFloat(float64(pence)/100, 'f', -1, 64)
+}
+
+// Split halves b. A band one penny wide cannot be split. The open-ended band
+// is split at £100, or at twice its start, so that repeated splits reach any
+// price in a few steps.
+func (b PriceBand) Split() (lower, upper PriceBand, ok bool) {
+    if b.To < 0 {
+        at := 2 * b.From
+        if at < openBandSplit {
+            at = openBandSplit
+        }
+        return PriceBand{b.From, at - 1}, PriceBand{at, -1}, true
+    }
+    if b.To <= b.From {
+        return b, b, false
+    }
+    mid := (b.From + b.To) / 2
+    return PriceBand{b.From, mid}, PriceBand{mid + 1, b.To}, true
+}
+
+// BandResult describes one band crawled by ShardByPrice.
+type BandResult struct {
+    Band PriceBand `json:"band"`
+    // Products is how many products the band listed. For a band that was
+    // split on its first page, it is an estimate from the page count.
+    Products int `json:"products"`
+    // Split is set when the band looked capped and was crawled as two
+    // halves instead.
+    Split bool `json:"split,omitempty"`
+    // Capped is set when the band looked capped but was too narrow to split.
+    Capped bool `json:"capped,omitempty"`
+}
+
+// ShardByPrice lists every product q matches, in or out of stock, by
+// crawling it in price bands. A band that lists at least limit products
+// looks capped and is split in two, until every band lists fewer. A band
+// whose first page already promises limit products is split without
+// fetching the rest. fn receives every product listed, so a product can
+// arrive more than once; onBand, if set, is called after each band.
+func (c *Client) ShardByPrice(ctx context.Context, q Query, limit int, fn func(Product), onBand func(BandResult)) error {
+    q.Filters.IncludeOutOfStock = true
+    pending := []PriceBand{AllPrices}
+    for len(pending) > 0 {
+        band := pending[0]
+        pending = pending[1:]
+        lower, upper, splittable := band.Split()
+
+        banded := q
+        banded.Filters.Price = band.String()
+        page, err := c.FetchPage(ctx, banded, 1)
+        if err != nil {
+            return err
+        }
+        for _, product := range page.Products {
+            fn(product)
+        }
+        result := BandResult{Band: band, Products: len(page.Products)}
+        if page.TotalPages > 1 {
+            result.Products = (page.TotalPages-1)*len(page.Products) + 1
+        }
+        if result.Products < limit || !splittable {
+            result.Products = len(page.Products)
+            if page.TotalPages > 1 {
+                it := c.ListProductsFrom(ctx, banded, 2)
+                for it.Next() {
+                    for _, product := range it.Page().Products {
+                        fn(product)
+                    }
+                    result.Products += len(it.Page().Products)
+                }
+                if err := it.Err(); err != nil {
+                    return err
+                }
+            }
+        }
+        if result.Products >= limit {
+            if splittable {
+                result.Split = true
+                pending = append([]PriceBand{lower, upper}, pending...)
+            } else {
+                result.Capped = true
+            }
+        }
+        if onBand != nil {
+            onBand(result)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,210 @@
+package twe
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+func TestPriceBandSplit(t *testing.T) {
+    tests := []struct {
+        band         PriceBand
+        lower, upper PriceBand
+        ok           bool
+    }{
+        {AllPrices, PriceBand{0, 9999}, PriceBand{10000, -1}, true},
+        {PriceBand{10000, -1}, PriceBand{10000, 19999}, PriceBand{20000, -1}, true},
+        {PriceBand{30000, -1}, PriceBand{30000, 59999}, PriceBand{60000, -1}, true},
+        {PriceBand{0, 9999}, PriceBand{0, 4999}, PriceBand{5000, 9999}, true},
+        {PriceBand{500, 501}, PriceBand{500, 500}, PriceBand{501, 501}, true},
+        {PriceBand{500, 500}, PriceBand{500, 500}, PriceBand{500, 500}, false},
+    }
+    for _, tt := range tests {
+        lower, upper, ok := tt.band.Split()
+        if lower != tt.lower || upper != tt.upper || ok != tt.ok {
+            t.Errorf("%v.Split() = %v, %v, %v; want %v, %v, %v", tt.band, lower, upper, ok, tt.lower, tt.upper, tt.ok)
+        }
+    }
+}
+
+func TestPriceBandString(t *testing.T) {
+    tests := []struct {
+        band PriceBand
+        want string
+    }{
+        {AllPrices, "0-"},
+        {PriceBand{10000, -1}, "100-"},
+        {PriceBand{2000, 3999}, "20-39.99"},
+        {PriceBand{1, 1}, "0.01-0.01"},
+    }
+    for _, tt := range tests {
+        if got := tt.band.String(); got != tt.want {
+            t.Errorf("%#v.String() = %q, want %q", tt.band, got, tt.want)
+        }
+    }
+}
+
+// shardServer lists products priced at prices (in pence), filtered by the
+// Price filter, pageSize to a page and truncated to the first resultCap,
+// like the site's listings.
+func shardServer(t *testing.T, prices []int, pageSize, resultCap int) *Client {
+    t.Helper()
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var payload RequestPayload
+        body, _ := io.ReadAll(r.Body)
+        if err := json.Unmarshal(body, &payload); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        filters := payload.Model.FilteringCriterias
+        if !filters.IncludeOutOfStock {
+            t.Errorf("band %v requested without out-of-stock products", filters.Price)
+        }
+        from, to, _ := strings.Cut(fmt.Sprint(filters.Price), "-")
+        min, _ := strconv.ParseFloat(from, 64)
+        max, err := strconv.ParseFloat(to, 64)
+        if err != nil {
+            max = 1e9
+        }
+
+        var ids []int
+        for id, price := range prices {
+            if pounds := float64(price) / 100; pounds >= min && pounds <= max {
+                ids = append(ids, id)
+            }
+        }
+        if len(ids) > resultCap {
+            ids = ids[:resultCap]
+        }
+        total := (len(ids) + pageSize - 1) / pageSize
+        page := payload.Model.DisplaySettings.PageNumber
+        var products []string
+        for i := (page - 1) * pageSize; i < len(ids) && i < page*pageSize; i++ {
+            products = append(products, fmt.Sprintf(`{"ProductID":"%d","SalesPrice":%v}`, ids[i], float64(prices[ids[i]])/100))
+        }
+        w.Header().Set("Content-Type", "application/json")
+        fmt.Fprintf(w, `{"CurrentPage":%d,"TotalPages":%d,"Products":[%s]}`, page, total, strings.Join(products, ","))
+    }))
+    t.Cleanup(srv.Close)
+    return NewClient(Config{BaseURL: srv.URL})
+}
+
+func TestShardByPrice(t *testing.T) {
+    tests := []struct {
+        name      string
+        prices    []int
+        pageSize  int
+        resultCap int
+        limit     int
+        want      []BandResult // nil to check only the invariants
+        wantFirst BandResult
+        capped    []PriceBand
+        wantFound int
+    }{
+        {
+            name:   "under the limit",
+            prices: []int{500, 1500, 250000}, pageSize: 2, resultCap: 4, limit: 4,
+            want:      []BandResult{{Band: AllPrices, Products: 3}},
+            wantFound: 3,
+        },
+        {
+            name:   "capped bands split until they fit",
+            prices: []int{500, 1500, 2500, 3500, 12000, 25000}, pageSize: 2, resultCap: 4, limit: 4,
+            want: []BandResult{
+                {Band: AllPrices, Products: 4, Split: true},
+                {Band: PriceBand{0, 9999}, Products: 4, Split: true},
+                {Band: PriceBand{0, 4999}, Products: 4, Split: true},
+                {Band: PriceBand{0, 2499}, Products: 2},
+                {Band: PriceBand{2500, 4999}, Products: 2},
+                {Band: PriceBand{5000, 9999}, Products: 0},
+                {Band: PriceBand{10000, -1}, Products: 2},
+            },
+            wantFound: 6,
+        },
+        {
+            name:   "split on the first page's estimate",
+            prices: []int{100, 200, 300, 400, 500, 600, 700, 800, 900, 1000}, pageSize: 2, resultCap: 100, limit: 4,
+            // Five pages of two promise about nine products.
+            wantFirst: BandResult{Band: AllPrices, Products: 9, Split: true},
+            wantFound: 10,
+        },
+        {
+            name:   "too many products at one price",
+            prices: []int{1000, 1000, 1000, 1000, 1000, 20000}, pageSize: 10, resultCap: 4, limit: 4,
+            capped:    []PriceBand{{1000, 1000}},
+            wantFound: 5,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            client := shardServer(t, tt.prices, tt.pageSize, tt.resultCap)
+            found := make(map[string]bool)
+            var bands []BandResult
+            err := client.ShardByPrice(context.Background(), DefaultQuery(), tt.limit,
+                func(p Product) { found[p.ProductID] = true },
+                func(b BandResult) { bands = append(bands, b) })
+            if err != nil {
+                t.Fatalf("ShardByPrice: %v", err)
+            }
+            if len(found) != tt.wantFound {
+                t.Errorf("found %d products, want %d", len(found), tt.wantFound)
+            }
+            if tt.want != nil && !reflect.DeepEqual(bands, tt.want) {
+                t.Errorf("bands = %+v, want %+v", bands, tt.want)
+            }
+            if tt.wantFirst.Band != (PriceBand{}) && bands[0] != tt.wantFirst {
+                t.Errorf("first band = %+v, want %+v", bands[0], tt.wantFirst)
+            }
+            var capped []PriceBand
+            for _, b := range bands {
+                if b.Capped {
+                    capped = append(capped, b.Band)
+                }
+            }
+            if !reflect.DeepEqual(capped, tt.capped) {
+                t.Errorf("capped bands = %v, want %v", capped, tt.capped)
+            }
+
+            // The bands that were not split must cover every price once.
+            var covered []PriceBand
+            for _, b := range bands {
+                switch {
+                case b.Split:
+                    if b.Products < tt.limit {
+                        t.Errorf("band %v split with %d products", b.Band, b.Products)
+                    }
+                    continue
+                case b.Capped:
+                    if _, _, ok := b.Band.Split(); ok {
+                        t.Errorf("band %v capped though it can be split", b.Band)
+                    }
+                case b.Products >= tt.limit:
+                    t.Errorf("band %v kept with %d products", b.Band, b.Products)
+                }
+                covered = append(covered, b.Band)
+            }
+            next := 0
+            sort.Slice(covered, func(i, j int) bool { return covered[i].From < covered[j].From })
+            for _, band := range covered {
+                if band.From != next {
+                    t.Fatalf("bands %v leave a gap or overlap at %d", covered, next)
+                }
+                next = band.To + 1
+                if band.To < 0 {
+                    next = -1
+                }
+            }
+            if next != -1 {
+                t.Errorf("bands %v stop at %d", covered, next)
+            }
+        })
+    }
+}
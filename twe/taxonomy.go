@@ -0,0 +1,93 @@
+package twe
+
+import "sort"
+
+// TaxonomyChange is one difference between two harvests of the filters the
+// site offers.
+type TaxonomyChange struct {
+    Facet string
+    // Value is empty when the whole facet was added or removed.
+    Value FacetValue
+    // Was holds the previous label when only the label changed.
+    Was string
+}
+
+// TaxonomyDiff lists what changed between two sets of facets.
+type TaxonomyDiff struct {
+    AddedFacets   []TaxonomyChange
+    RemovedFacets []TaxonomyChange
+    AddedValues   []TaxonomyChange
+    RemovedValues []TaxonomyChange
+    Relabelled    []TaxonomyChange
+}
+
+// Empty reports whether nothing changed.
+func (d TaxonomyDiff) Empty() bool {
+    return len(d.AddedFacets)+len(d.RemovedFacets)+len(d.AddedValues)+len(d.RemovedValues)+len(d.Relabelled) == 0
+}
+
+// DiffTaxonomy compares two harvests. Facets are matched by name and values
+// by their filter value, so a renamed value shows up as relabelled rather
+// than as one removal and one addition. Product counts are not compared;
+// they change on every run.
+func DiffTaxonomy(before, after []Facet) TaxonomyDiff {
+    var d TaxonomyDiff
+    old := facetsByName(before)
+    now := facetsByName(after)
+
+    for _, name := range sortedNames(now) {
+        facet := now[name]
+        previous, ok := old[name]
+        if !ok {
+            d.AddedFacets = append(d.AddedFacets, TaxonomyChange{Facet: name})
+            continue
+        }
+        oldValues := valuesByValue(previous)
+        for _, value := range facet.Values {
+            was, ok := oldValues[value.Value]
+            switch {
+            case !ok:
+                d.AddedValues = append(d.AddedValues, TaxonomyChange{Facet: name, Value: value})
+            case was.Label != value.Label:
+                d.Relabelled = append(d.Relabelled, TaxonomyChange{Facet: name, Value: value, Was: was.Label})
+            }
+        }
+        newValues := valuesByValue(facet)
+        for _, value := range previous.Values {
+            if _, ok := newValues[value.Value]; !ok {
+                d.RemovedValues = append(d.RemovedValues, TaxonomyChange{Facet: name, Value: value})
+            }
+        }
+    }
+    for _, name := range sortedNames(old) {
+        if _, ok := now[name]; !ok {
+            d.RemovedFacets = append(d.RemovedFacets, TaxonomyChange{Facet: name})
+        }
+    }
+    return d
+}
+
+func facetsByName(facets []Facet) map[string]Facet {
+    byName := make(map[string]Facet, len(facets))
+    for _, facet := range facets {
+        byName[facet.Name] = facet
+    }
+    return byName
+}
+
+func valuesByValue(facet Facet) map[string]FacetValue {
+    byValue := make(map[string]FacetValue, len(facet.Values))
+    for _, value := range facet.Values {
+        byValue[value.Value] = value
+    }
+    return byValue
+}
+
+func sortedNames(facets map[string]Facet) []string {
+    names := make([]string, 0, len(facets))
+    for name := range facets {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
@@ -0,0 +1,60 @@
+package twe
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestDiffTaxonomy(t *testing.T) {
+    country := Facet{Name: "Country", Label: "Country", Values: []FacetValue{
+        {"Scotland", "Scotland", 120}, {"Japan", "Japan", 30},
+    }}
+    tests := []struct {
+        name          string
+        before, after []Facet
+        want          TaxonomyDiff
+    }{
+        {name: "nothing before or after"},
+        {
+            name:   "only counts changed",
+            before: []Facet{country},
+            after: []Facet{{Name: "Country", Label: "Country", Values: []FacetValue{
+                {"Japan", "Japan", 31}, {"Scotland", "Scotland", 99},
+            }}},
+        },
+        {
+            name:  "first harvest",
+            after: []Facet{country, {Name: "CaskType"}},
+            want:  TaxonomyDiff{AddedFacets: []TaxonomyChange{{Facet: "CaskType"}, {Facet: "Country"}}},
+        },
+        {
+            name:   "facet removed",
+            before: []Facet{country, {Name: "Age"}},
+            after:  []Facet{country},
+            want:   TaxonomyDiff{RemovedFacets: []TaxonomyChange{{Facet: "Age"}}},
+        },
+        {
+            name:   "values added, removed and relabelled",
+            before: []Facet{country},
+            after: []Facet{{Name: "Country", Label: "Country of origin", Values: []FacetValue{
+                {"Scotland", "Scotland (UK)", 120}, {"Taiwan", "Taiwan", 4}, {"India", "India", 2},
+            }}},
+            want: TaxonomyDiff{
+                AddedValues:   []TaxonomyChange{{Facet: "Country", Value: FacetValue{"Taiwan", "Taiwan", 4}}, {Facet: "Country", Value: FacetValue{"India", "India", 2}}},
+                RemovedValues: []TaxonomyChange{{Facet: "Country", Value: FacetValue{"Japan", "Japan", 30}}},
+                Relabelled:    []TaxonomyChange{{Facet: "Country", Value: FacetValue{"Scotland", "Scotland (UK)", 120}, Was: "Scotland"}},
+            },
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := DiffTaxonomy(tt.before, tt.after)
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("DiffTaxonomy = %+v, want %+v", got, tt.want)
+            }
+            if got.Empty() != reflect.DeepEqual(tt.want, TaxonomyDiff{}) {
+                t.Errorf("Empty() = %v", got.Empty())
+            }
+        })
+    }
+}
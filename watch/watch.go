@@ -0,0 +1,288 @@
+// Package watch evaluates a buyer's watchlist against a crawl: which
+// products to follow, and the conditions on their price and stock that
+// raise an alert.
+package watch
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "math"
+    "os"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+
+    "gopkg.in/yaml.v3"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+// List is a parsed watchlist file.
+type List struct {
+    Watches []Watch `yaml:"watches"`
+}
+
+// Watch selects products, either by ProductID or by match rules, and lists
+// the conditions that raise an alert for them.
+type Watch struct {
+    Name string `yaml:"name"`
+    // Products are ProductIDs followed whatever Match says.
+    Products []string `yaml:"products"`
+    Match    Match    `yaml:"match"`
+    When     When     `yaml:"when"`
+
+    products map[string]bool
+    name     *regexp.Regexp
+    minABV   float64
+    maxABV   float64
+}
+
+// Match rules select products by their listing fields. Every rule set must
+// hold; a watch with no rules follows only its Products.
+type Match struct {
+    // Brand and Category compare case-insensitively; Category matches
+    // CategoryName or MasterCategoryName.
+    Brand    string `yaml:"brand"`
+    Category string `yaml:"category"`
+    // Name is a regular expression searched for in the product name.
+    Name string `yaml:"name"`
+    // ABV is a "min-max" StrengthInPC range, either side optional.
+    ABV string `yaml:"abv"`
+    // MaxPricePerLitre bounds SalesPrice per litre of SizeInCL.
+    MaxPricePerLitre float64 `yaml:"max_price_per_litre"`
+}
+
+func (m Match) empty() bool {
+    return m == Match{}
+}
+
+// When lists the conditions of a watch. Each one set raises its own alert.
+type When struct {
+    // PriceBelow fires when SalesPrice falls below it.
+    PriceBelow float64 `yaml:"price_below"`
+    // DropsByPercent fires when SalesPrice drops by more than this share
+    // since the previous crawl.
+    DropsByPercent float64 `yaml:"drops_by_percent"`
+    // BackInStock fires when a product that was out of stock is not any
+    // more.
+    BackInStock bool `yaml:"back_in_stock"`
+    // StockBelow fires when StockLevel falls below it.
+    StockBelow float64 `yaml:"stock_below"`
+}
+
+// Condition names a When field in alerts.
+type Condition string
+
+const (
+    PriceBelow     Condition = "price_below"
+    DropsByPercent Condition = "drops_by_percent"
+    BackInStock    Condition = "back_in_stock"
+    StockBelow     Condition = "stock_below"
+)
+
+// Alert is one condition of one watch fired by one product. Before is the
+// value at the previous crawl, nil when the product was not seen then.
+type Alert struct {
+    Watch     string    `json:"watch"`
+    Condition Condition `json:"condition"`
+    // Threshold is the condition's setting: a price, a percentage or a
+    // stock level. It is left out for back_in_stock.
+    Threshold float64  `json:"threshold,omitempty"`
+    ProductID string   `json:"productId"`
+    Name      string   `json:"name"`
+    URL       string   `json:"url,omitempty"`
+    Before    *float64 `json:"before"`
+    After     float64  `json:"after"`
+    // Product is the product as the crawl saw it.
+    Product twe.Product `json:"-"`
+}
+
+// Describe renders what fired a on one line, without the product.
+func (a Alert) Describe() string {
+    switch a.Condition {
+    case PriceBelow:
+        if a.Before == nil {
+            return fmt.Sprintf("£%.2f, below £%.2f", a.After, a.Threshold)
+        }
+        return fmt.Sprintf("£%.2f -> £%.2f, below £%.2f", *a.Before, a.After, a.Threshold)
+    case DropsByPercent:
+        return fmt.Sprintf("£%.2f -> £%.2f, down %.1f%% (more than %g%%)", *a.Before, a.After, (*a.Before-a.After) / *a.Before * 100, a.Threshold)
+    case BackInStock:
+        return fmt.Sprintf("back in stock, stock %g -> %g", *a.Before, a.After)
+    case StockBelow:
+        if a.Before == nil {
+            return fmt.Sprintf("stock %g, below %g", a.After, a.Threshold)
+        }
+        return fmt.Sprintf("stock %g -> %g, below %g", *a.Before, a.After, a.Threshold)
+    }
+    return string(a.Condition)
+}
+
+// Load reads and checks the watchlist at path.
+func Load(path string) (*List, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    list, err := Parse(data)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    return list, nil
+}
+
+// Parse parses and checks a watchlist.
+func Parse(data []byte) (*List, error) {
+    var list List
+    dec := yaml.NewDecoder(bytes.NewReader(data))
+    dec.KnownFields(true)
+    if err := dec.Decode(&list); err != nil {
+        return nil, err
+    }
+    if len(list.Watches) == 0 {
+        return nil, errors.New("no watches")
+    }
+    for i := range list.Watches {
+        w := &list.Watches[i]
+        if w.Name == "" {
+            w.Name = fmt.Sprintf("watch %d", i+1)
+        }
+        if err := w.compile(); err != nil {
+            return nil, fmt.Errorf("%s: %w", w.Name, err)
+        }
+    }
+    return &list, nil
+}
+
+func (w *Watch) compile() error {
+    if len(w.Products) == 0 && w.Match.empty() {
+        return errors.New("needs products or match rules")
+    }
+    if w.When == (When{}) {
+        return errors.New("needs at least one condition under when")
+    }
+    if w.When.PriceBelow < 0 || w.When.DropsByPercent < 0 || w.When.StockBelow < 0 || w.Match.MaxPricePerLitre < 0 {
+        return errors.New("thresholds cannot be negative")
+    }
+    w.products = make(map[string]bool, len(w.Products))
+    for _, id := range w.Products {
+        w.products[id] = true
+    }
+    if w.Match.Name != "" {
+        re, err := regexp.Compile(w.Match.Name)
+        if err != nil {
+            return fmt.Errorf("match.name: %w", err)
+        }
+        w.name = re
+    }
+    w.minABV, w.maxABV = 0, math.Inf(1)
+    if w.Match.ABV != "" {
+        from, to, ok := strings.Cut(w.Match.ABV, "-")
+        var err error
+        if !ok {
+            return fmt.Errorf("match.abv must be a min-max range, got %q", w.Match.ABV)
+        }
+        if from != "" {
+            if w.minABV, err = strconv.ParseFloat(from, 64); err != nil {
+                return fmt.Errorf("match.abv: invalid minimum %q", from)
+            }
+        }
+        if to != "" {
+            if w.maxABV, err = strconv.ParseFloat(to, 64); err != nil {
+                return fmt.Errorf("match.abv: invalid maximum %q", to)
+            }
+        }
+        if w.maxABV < w.minABV {
+            return fmt.Errorf("match.abv %q has max below min", w.Match.ABV)
+        }
+    }
+    return nil
+}
+
+// Follows reports whether w watches p.
+func (w *Watch) Follows(p twe.Product) bool {
+    if w.products[p.ProductID] {
+        return true
+    }
+    m := w.Match
+    if m.empty() {
+        return false
+    }
+    if m.Brand != "" && !strings.EqualFold(p.Brand, m.Brand) {
+        return false
+    }
+    if m.Category != "" && !strings.EqualFold(p.CategoryName, m.Category) && !strings.EqualFold(p.MasterCategoryName, m.Category) {
+        return false
+    }
+    if w.name != nil && !w.name.MatchString(p.Name) {
+        return false
+    }
+    if p.StrengthInPC < w.minABV || p.StrengthInPC > w.maxABV {
+        return false
+    }
+    if m.MaxPricePerLitre > 0 && (p.SizeInCL <= 0 || p.SalesPrice/p.SizeInCL*100 > m.MaxPricePerLitre) {
+        return false
+    }
+    return true
+}
+
+// Follows reports whether any watch in l watches p.
+func (l *List) Follows(p twe.Product) bool {
+    for i := range l.Watches {
+        if l.Watches[i].Follows(p) {
+            return true
+        }
+    }
+    return false
+}
+
+// Evaluate checks the products of a crawl against every watch. before holds
+// the previous crawl keyed by ProductID and may be empty. Conditions fire
+// on the crawl that makes them true: a product already below a price or
+// stock threshold last time does not fire again. Without a previous value
+// those two fire whenever they hold, and the drop and restock conditions
+// cannot fire. Alerts are ordered by watch, condition, then ProductID.
+func (l *List) Evaluate(before map[string]twe.Product, after []twe.Product) []Alert {
+    var alerts []Alert
+    for _, w := range l.Watches {
+        var fired []Alert
+        for _, now := range after {
+            if !w.Follows(now) {
+                continue
+            }
+            was, seen := before[now.ProductID]
+            alert := func(cond Condition, threshold, old, new float64) {
+                a := Alert{Watch: w.Name, Condition: cond, Threshold: threshold,
+                    ProductID: now.ProductID, Name: now.Name, URL: now.URL, After: new, Product: now}
+                if seen {
+                    a.Before = &old
+                }
+                fired = append(fired, a)
+            }
+            if t := w.When.PriceBelow; t > 0 && now.SalesPrice < t && (!seen || was.SalesPrice >= t) {
+                alert(PriceBelow, t, was.SalesPrice, now.SalesPrice)
+            }
+            if t := w.When.DropsByPercent; t > 0 && seen && was.SalesPrice > 0 &&
+                (was.SalesPrice-now.SalesPrice)/was.SalesPrice*100 > t {
+                alert(DropsByPercent, t, was.SalesPrice, now.SalesPrice)
+            }
+            if w.When.BackInStock && seen && was.IsOutOfStock && !now.IsOutOfStock {
+                alert(BackInStock, 0, was.StockLevel, now.StockLevel)
+            }
+            if t := w.When.StockBelow; t > 0 && now.StockLevel < t && (!seen || was.StockLevel >= t) {
+                alert(StockBelow, t, was.StockLevel, now.StockLevel)
+            }
+        }
+        rank := map[Condition]int{PriceBelow: 0, DropsByPercent: 1, BackInStock: 2, StockBelow: 3}
+        sort.SliceStable(fired, func(i, j int) bool {
+            if fired[i].Condition != fired[j].Condition {
+                return rank[fired[i].Condition] < rank[fired[j].Condition]
+            }
+            return twe.LessProductID(fired[i].ProductID, fired[j].ProductID)
+        })
+        alerts = append(alerts, fired...)
+    }
+    return alerts
+}
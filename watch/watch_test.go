@@ -0,0 +1,220 @@
+package watch
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+
+    "theWhiskyExchangeCrawler/twe"
+)
+
+func TestParse(t *testing.T) {
+    tests := []struct {
+        name    string
+        yaml    string
+        wantErr string
+    }{
+        {name: "products", yaml: "watches:\n  - products: [\"1\"]\n    when: {price_below: 50}\n"},
+        {name: "match", yaml: "watches:\n  - match: {brand: Ardbeg, abv: 46-}\n    when: {back_in_stock: true}\n"},
+        {name: "empty", yaml: "watches: []\n", wantErr: "no watches"},
+        {name: "unknown key", yaml: "watches:\n  - products: [\"1\"]\n    when: {price_under: 50}\n", wantErr: "field price_under not found"},
+        {name: "nothing followed", yaml: "watches:\n  - name: cheap\n    when: {price_below: 50}\n", wantErr: "cheap: needs products or match rules"},
+        {name: "no condition", yaml: "watches:\n  - products: [\"1\"]\n", wantErr: "watch 1: needs at least one condition"},
+        {name: "negative threshold", yaml: "watches:\n  - products: [\"1\"]\n    when: {stock_below: -1}\n", wantErr: "cannot be negative"},
+        {name: "bad regexp", yaml: "watches:\n  - match: {name: \"(\"}\n    when: {price_below: 50}\n", wantErr: "match.name"},
+        {name: "abv without range", yaml: "watches:\n  - match: {abv: \"46\"}\n    when: {price_below: 50}\n", wantErr: "min-max range"},
+        {name: "abv backwards", yaml: "watches:\n  - match: {abv: 60-40}\n    when: {price_below: 50}\n", wantErr: "max below min"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := Parse([]byte(tt.yaml))
+            if tt.wantErr == "" {
+                if err != nil {
+                    t.Fatalf("Parse: %v", err)
+                }
+                return
+            }
+            if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                t.Fatalf("Parse error = %v, want one containing %q", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestLoadExample(t *testing.T) {
+    if _, err := Load("../watchlist.example.yaml"); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestFollows(t *testing.T) {
+    ardbeg := twe.Product{ProductID: "7", Name: "Ardbeg 10 Year Old", Brand: "Ardbeg", CategoryName: "Islay Single Malt",
+        MasterCategoryName: "Whisky", StrengthInPC: 46, SizeInCL: 70, SalesPrice: 49}
+    tests := []struct {
+        name  string
+        match Match
+        ids   []string
+        want  bool
+    }{
+        {name: "by id", ids: []string{"7"}, want: true},
+        {name: "other id", ids: []string{"8"}},
+        {name: "brand ignores case", match: Match{Brand: "ARDBEG"}, want: true},
+        {name: "other brand", match: Match{Brand: "Laphroaig"}},
+        {name: "category", match: Match{Category: "islay single malt"}, want: true},
+        {name: "master category", match: Match{Category: "whisky"}, want: true},
+        {name: "name regexp", match: Match{Name: `\b10 Year`}, want: true},
+        {name: "abv in range", match: Match{ABV: "46-50"}, want: true},
+        {name: "abv open-ended", match: Match{ABV: "-45"}},
+        {name: "price per litre", match: Match{MaxPricePerLitre: 70}, want: true},
+        {name: "too dear per litre", match: Match{MaxPricePerLitre: 69.99}},
+        {name: "every rule must hold", match: Match{Brand: "Ardbeg", Name: "Uigeadail"}},
+        {name: "id wins over rules", ids: []string{"7"}, match: Match{Brand: "Laphroaig"}, want: true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            w := Watch{Products: tt.ids, Match: tt.match, When: When{PriceBelow: 1}}
+            if err := w.compile(); err != nil {
+                t.Fatal(err)
+            }
+            if got := w.Follows(ardbeg); got != tt.want {
+                t.Errorf("Follows = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestEvaluate(t *testing.T) {
+    product := func(id string, price, stock float64) twe.Product {
+        return twe.Product{ProductID: id, Name: "Whisky " + id, SalesPrice: price, StockLevel: stock, IsOutOfStock: stock == 0}
+    }
+    type fired struct {
+        Condition Condition
+        ProductID string
+        Before    interface{} // nil or float64
+        After     float64
+    }
+    tests := []struct {
+        name   string
+        when   When
+        before []twe.Product
+        after  []twe.Product
+        want   []fired
+    }{
+        {
+            name:   "price falls below",
+            when:   When{PriceBelow: 50},
+            before: []twe.Product{product("1", 55, 5), product("2", 45, 5)},
+            after:  []twe.Product{product("1", 49, 5), product("2", 40, 5), product("3", 20, 5)},
+            // 2 was already below; 3 has no previous crawl.
+            want: []fired{{PriceBelow, "1", 55.0, 49}, {PriceBelow, "3", nil, 20}},
+        },
+        {
+            name:   "price exactly at the threshold",
+            when:   When{PriceBelow: 50},
+            before: []twe.Product{product("1", 60, 5)},
+            after:  []twe.Product{product("1", 50, 5)},
+        },
+        {
+            name:   "drop by percent",
+            when:   When{DropsByPercent: 10},
+            before: []twe.Product{product("1", 100, 5), product("2", 100, 5), product("3", 0, 5)},
+            after:  []twe.Product{product("1", 89, 5), product("2", 90, 5), product("3", 0, 5), product("4", 1, 5)},
+            want:   []fired{{DropsByPercent, "1", 100.0, 89}},
+        },
+        {
+            name:   "back in stock",
+            when:   When{BackInStock: true},
+            before: []twe.Product{product("1", 50, 0), product("2", 50, 3)},
+            after:  []twe.Product{product("1", 50, 12), product("2", 50, 4), product("3", 50, 1)},
+            want:   []fired{{BackInStock, "1", 0.0, 12}},
+        },
+        {
+            name:   "stock falls below",
+            when:   When{StockBelow: 3},
+            before: []twe.Product{product("1", 50, 3), product("2", 50, 2)},
+            after:  []twe.Product{product("1", 50, 2), product("2", 50, 1), product("3", 50, 0)},
+            want:   []fired{{StockBelow, "1", 3.0, 2}, {StockBelow, "3", nil, 0}},
+        },
+        {
+            name:   "ordered by condition then id",
+            when:   When{PriceBelow: 50, DropsByPercent: 5, BackInStock: true},
+            before: []twe.Product{product("10", 60, 0), product("9", 60, 5)},
+            after:  []twe.Product{product("10", 40, 5), product("9", 45, 5)},
+            want: []fired{
+                {PriceBelow, "9", 60.0, 45}, {PriceBelow, "10", 60.0, 40},
+                {DropsByPercent, "9", 60.0, 45}, {DropsByPercent, "10", 60.0, 40},
+                {BackInStock, "10", 0.0, 5},
+            },
+        },
+        {
+            name:  "products not followed",
+            when:  When{PriceBelow: 50},
+            after: []twe.Product{product("99", 1, 5)},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            list := &List{Watches: []Watch{{Name: "w", Products: []string{"1", "2", "3", "4", "9", "10"}, When: tt.when}}}
+            if err := list.Watches[0].compile(); err != nil {
+                t.Fatal(err)
+            }
+            before := make(map[string]twe.Product)
+            for _, p := range tt.before {
+                before[p.ProductID] = p
+            }
+
+            var got []fired
+            for _, a := range list.Evaluate(before, tt.after) {
+                f := fired{Condition: a.Condition, ProductID: a.ProductID, After: a.After}
+                if a.Before != nil {
+                    f.Before = *a.Before
+                }
+                if a.Watch != "w" || a.Name != "Whisky "+a.ProductID || a.Product.ProductID != a.ProductID {
+                    t.Errorf("alert %+v does not name its watch and product", a)
+                }
+                got = append(got, f)
+            }
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("Evaluate = %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestEvaluateOrdersByWatch(t *testing.T) {
+    list, err := Parse([]byte(`watches:
+  - name: stock
+    products: ["1"]
+    when: {stock_below: 5}
+  - name: price
+    products: ["1"]
+    when: {price_below: 50}
+`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    alerts := list.Evaluate(nil, []twe.Product{{ProductID: "1", SalesPrice: 40, StockLevel: 2}})
+    if len(alerts) != 2 || alerts[0].Watch != "stock" || alerts[1].Watch != "price" {
+        t.Errorf("Evaluate = %+v, want the stock watch's alert first", alerts)
+    }
+}
+
+func TestAlertDescribe(t *testing.T) {
+    before := func(v float64) *float64 { return &v }
+    tests := []struct {
+        alert Alert
+        want  string
+    }{
+        {Alert{Condition: PriceBelow, Threshold: 50, After: 45}, "£45.00, below £50.00"},
+        {Alert{Condition: PriceBelow, Threshold: 50, Before: before(55), After: 45}, "£55.00 -> £45.00, below £50.00"},
+        {Alert{Condition: DropsByPercent, Threshold: 10, Before: before(100), After: 85}, "£100.00 -> £85.00, down 15.0% (more than 10%)"},
+        {Alert{Condition: BackInStock, Before: before(0), After: 6}, "back in stock, stock 0 -> 6"},
+        {Alert{Condition: StockBelow, Threshold: 3, After: 1}, "stock 1, below 3"},
+        {Alert{Condition: StockBelow, Threshold: 3, Before: before(4), After: 1}, "stock 4 -> 1, below 3"},
+    }
+    for _, tt := range tests {
+        if got := tt.alert.Describe(); got != tt.want {
+            t.Errorf("Describe(%s) = %q, want %q", tt.alert.Condition, got, tt.want)
+        }
+    }
+}